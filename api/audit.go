@@ -0,0 +1,351 @@
+package api
+
+import (
+	"context"
+	"data-cron-server/audit"
+	"data-cron-server/auth"
+	"data-cron-server/config"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordAuthFailure records a request rejected by the authenticator
+func (r *Router) recordAuthFailure(req *http.Request, failure auth.Failure) {
+	principal := ""
+	switch {
+	case failure.Admin != "":
+		principal = "admin:" + failure.Admin
+	case failure.User != "":
+		principal = "user:" + failure.User
+	case failure.KeyID != "":
+		principal = "key:" + failure.KeyID
+	}
+
+	r.recordEvent(audit.Event{
+		Principal: principal,
+		User:      failure.User,
+		SourceIP:  r.auth.ClientIP(req),
+		Action:    audit.ActionAuthFailure,
+		Target:    req.Method + " " + auth.RedactPath(req.URL.Path),
+		Status:    failure.Status,
+		Reason:    failure.Reason,
+	})
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// auditWrites records every mutating request with hashes of the affected
+// state before and after the handler ran, and every read of an admin
+// impersonating a user. Successful writes name their principal in the next
+// revision of the configuration. It must run after authentication, so the
+// principal is known.
+func (r *Router) auditWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := routePath(req.URL.Path)
+		write := isWrite(req.Method, path)
+		_, impersonated := auth.ImpersonatorFromContext(req.Context())
+		if !write && (r.audit == nil || !impersonated) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		event, snapshot := r.describeWrite(req, path)
+		if !write {
+			// Reads change nothing, so there is nothing to hash
+			event.Action = strings.TrimSuffix(event.Action, ".") + ".read"
+			snapshot = func() []byte { return nil }
+		}
+		if r.audit != nil {
+			event.Before = audit.Hash(snapshot())
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, req.WithContext(context.WithValue(req.Context(), auditEventKey{}, &event)))
+
+		if write && recorder.status < http.StatusBadRequest {
+			r.config.Attribute(revisionPrincipal(event))
+		}
+		if r.audit == nil {
+			return
+		}
+		event.After = audit.Hash(snapshot())
+		event.Status = recorder.status
+		r.recordEvent(event)
+	})
+}
+
+// auditEventKey is the context key of the audit event of a request
+type auditEventKey struct{}
+
+// annotateAudit completes the audit event of a request with what only the
+// handler knows, e.g. the name of an account created from the body
+func annotateAudit(req *http.Request, annotate func(event *audit.Event)) {
+	if event, ok := req.Context().Value(auditEventKey{}).(*audit.Event); ok {
+		annotate(event)
+	}
+}
+
+// revisionPrincipal names the principal of an event in revisions of the
+// configuration, including the admin impersonating it
+func revisionPrincipal(event audit.Event) string {
+	if event.Impersonator != "" {
+		return event.Principal + " (" + event.Impersonator + ")"
+	}
+	return event.Principal
+}
+
+// requestPrincipal returns the principal of an authenticated request and the
+// admin impersonating it, if any
+func requestPrincipal(req *http.Request) (principal, impersonator string) {
+	if name, _, ok := auth.AdminFromContext(req.Context()); ok {
+		principal = "admin:" + name
+	} else if actor, ok := auth.ActorFromContext(req.Context()); ok {
+		principal = "user:" + actor
+	}
+	if admin, ok := auth.ImpersonatorFromContext(req.Context()); ok {
+		impersonator = "admin:" + admin
+	}
+	return principal, impersonator
+}
+
+// recordEvent appends an event to the audit log
+func (r *Router) recordEvent(event audit.Event) {
+	if err := r.audit.Record(event); err != nil {
+		log.Printf("Error writing audit log: %v", err)
+	}
+}
+
+// isWrite reports whether a request changes state. Job toggles and the
+// reload are GET requests, but change state as well.
+func isWrite(method, path string) bool {
+	if method != http.MethodGet && method != http.MethodHead {
+		return true
+	}
+	return strings.HasSuffix(path, "/on") || strings.HasSuffix(path, "/off") || matchPath(path, "/admin/*/reload")
+}
+
+// describeWrite returns the audit event of a write request without its
+// hashes, and a function taking a snapshot of the state it affects
+func (r *Router) describeWrite(req *http.Request, path string) (audit.Event, func() []byte) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	part := func(i int) string {
+		if i < len(parts) {
+			return parts[i]
+		}
+		return ""
+	}
+
+	verb := map[string]string{
+		http.MethodPost:   "create",
+		http.MethodPut:    "update",
+		http.MethodPatch:  "update",
+		http.MethodDelete: "delete",
+	}[req.Method]
+	switch last := parts[len(parts)-1]; last {
+	case "on", "off", "rotate", "restore", "rollback":
+		verb = last
+	}
+
+	event := audit.Event{SourceIP: r.auth.ClientIP(req)}
+	event.Principal, event.Impersonator = requestPrincipal(req)
+	namespace, _ := auth.UserFromContext(req.Context())
+
+	none := func() []byte { return nil }
+
+	switch part(0) {
+	case "admin":
+		area, subject := part(2), part(3)
+		event.Action = "admin." + area + "." + verb
+		if resource := part(4); resource != "" && resource != verb {
+			// e.g. admin.users.keys.create
+			event.Action = "admin." + area + "." + resource + "." + verb
+		}
+		event.Target = strings.Join(parts[3:], "/")
+
+		switch area {
+		case "reload":
+			event.Action = "admin.reload"
+			return event, r.config.SnapshotUsers
+		case "users", "orgs":
+			if subject == "" {
+				return event, r.config.SnapshotUsers
+			}
+			event.User = subject
+			if area == "orgs" {
+				event.User = config.OrgNamespace(subject)
+			}
+			return event, func() []byte { return r.config.SnapshotUser(event.User) }
+		case "config", "backups", "revisions":
+			return event, r.config.SnapshotUsers
+		case "admins":
+			return event, r.admins.Snapshot
+		}
+		return event, none
+
+	case "cron":
+		event.Action = "cron." + verb
+		event.User = namespace
+		job := part(2)
+		if job == "" || job == "on" || job == "off" {
+			// Creating, replacing or toggling all jobs
+			return event, func() []byte { return r.config.SnapshotUser(namespace) }
+		}
+		event.Target = job
+		return event, func() []byte { return r.config.SnapshotUserJob(namespace, job) }
+
+	case "data":
+		event.Action = "data." + verb
+		event.User = namespace
+		event.Target = part(2)
+		if event.Target == "-" && part(3) == "encryption" {
+			// Encrypting or decrypting changes all data of the namespace
+			event.Action = "data.encryption." + verb
+			event.Target = ""
+			return event, func() []byte { return r.config.SnapshotUser(namespace) }
+		}
+		if part(3) == "share" {
+			event.Action = "data.share"
+			return event, none
+		}
+		return event, func() []byte { return r.config.SnapshotUserData(namespace, event.Target) }
+
+	case "share":
+		// Writes through share links are authenticated by the link
+		event.Principal = "share"
+		event.Action = "share." + verb
+		event.User = part(1)
+		event.Target = part(2)
+		return event, func() []byte { return r.config.SnapshotUserData(event.User, event.Target) }
+	}
+
+	event.Action = part(0) + "." + verb
+	return event, none
+}
+
+// handleAdminAudit handles querying the audit log
+func (r *Router) handleAdminAudit(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.audit == nil {
+		http.Error(w, "Audit log disabled", http.StatusNotFound)
+		return
+	}
+
+	filter, err := parseAuditFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.User = req.URL.Query().Get("user")
+
+	r.respondAuditEvents(w, filter)
+}
+
+// handleAdminAuditVerify handles checking the hash chain of the audit log
+func (r *Router) handleAdminAuditVerify(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.audit == nil {
+		http.Error(w, "Audit log disabled", http.StatusNotFound)
+		return
+	}
+
+	count, err := r.audit.Verify()
+	result := map[string]interface{}{
+		"valid":  err == nil,
+		"events": count,
+	}
+	if err != nil {
+		result["error"] = err.Error()
+	}
+
+	respondJSON(w, result)
+}
+
+// handleAudit handles querying the audit events of the user's namespace
+func (r *Router) handleAudit(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.audit == nil {
+		http.Error(w, "Audit log disabled", http.StatusNotFound)
+		return
+	}
+
+	user, ok := auth.UserFromContext(req.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := parseAuditFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.User = user
+
+	r.respondAuditEvents(w, filter)
+}
+
+// respondAuditEvents responds with the events matching the filter
+func (r *Router) respondAuditEvents(w http.ResponseWriter, filter audit.Filter) {
+	events, err := r.audit.Query(filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, events)
+}
+
+// parseAuditFilter reads the principal, impersonator, action, since, until
+// and limit query parameters
+func parseAuditFilter(req *http.Request) (audit.Filter, error) {
+	query := req.URL.Query()
+	filter := audit.Filter{
+		Principal:    query.Get("principal"),
+		Impersonator: query.Get("impersonator"),
+		Action:       query.Get("action"),
+	}
+
+	for name, dest := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("Invalid %s, expected RFC 3339 time", name)
+			}
+			*dest = t
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return filter, errors.New("Invalid limit")
+		}
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
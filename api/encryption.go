@@ -0,0 +1,123 @@
+package api
+
+import (
+	"data-cron-server/auth"
+	"data-cron-server/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// HeaderEncryptionSecret carries the secret that unwraps the data key of a
+// namespace with encrypted data
+const HeaderEncryptionSecret = "X-Encryption-Secret"
+
+// unlockData returns the data key of the namespace, unwrapped with the
+// secret of the request, or nil if its data is not encrypted. It responds
+// with an error if the key cannot be unwrapped.
+func (r *Router) unlockData(w http.ResponseWriter, req *http.Request, user string) (*config.DataKey, bool) {
+	if r.auth.CheckSecretLockout(w, req, user) {
+		return nil, false
+	}
+	dataKey, err := r.config.UnlockData(user, req.Header.Get(HeaderEncryptionSecret))
+	r.countSecret(req, user, err)
+	if err != nil {
+		respondDataEncryptionError(w, err)
+		return nil, false
+	}
+	return dataKey, true
+}
+
+// countSecret counts a wrong encryption secret against the client and the
+// namespace, so secrets cannot be guessed faster than keys
+func (r *Router) countSecret(req *http.Request, user string, err error) {
+	switch {
+	case errors.Is(err, config.ErrWrongSecret):
+		r.auth.SecretFailed(req, user)
+	case err == nil:
+		r.auth.SecretSucceeded(req, user)
+	}
+}
+
+// respondDataEncryptionError responds with the status of an error of data
+// encryption
+func respondDataEncryptionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, config.ErrSecretRequired), errors.Is(err, config.ErrWrongSecret):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, config.ErrWeakSecret):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, config.ErrNotEncrypted), errors.Is(err, config.ErrEncryptionChanged):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fmt.Sprintf("Failed to process encrypted data: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// handleDataEncryption handles enabling, re-keying and disabling the
+// encryption of the data values of a namespace
+func (r *Router) handleDataEncryption(w http.ResponseWriter, req *http.Request) {
+	// Get user from context
+	user, ok := auth.UserFromContext(req.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		respondJSON(w, map[string]bool{"enabled": r.config.DataEncrypted(user)})
+
+	case http.MethodPut:
+		// Encrypt with a new secret; once encrypted, the current secret is
+		// required as well
+		var body struct {
+			Secret string `json:"secret"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if r.auth.CheckSecretLockout(w, req, user) {
+			return
+		}
+		enabled := r.config.DataEncrypted(user)
+		err := r.config.SetDataSecret(user, req.Header.Get(HeaderEncryptionSecret), body.Secret)
+		r.countSecret(req, user, err)
+		if err != nil {
+			respondDataEncryptionError(w, err)
+			return
+		}
+
+		message := "Data encrypted"
+		if enabled {
+			message = "Encryption secret changed"
+		}
+		response := struct {
+			Success bool   `json:"success"`
+			Message string `json:"message"`
+		}{
+			Success: true,
+			Message: message,
+		}
+		respondJSON(w, response)
+
+	case http.MethodDelete:
+		if r.auth.CheckSecretLockout(w, req, user) {
+			return
+		}
+		err := r.config.DisableDataEncryption(user, req.Header.Get(HeaderEncryptionSecret))
+		r.countSecret(req, user, err)
+		if err != nil {
+			respondDataEncryptionError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
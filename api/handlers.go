@@ -1,54 +1,270 @@
 package api
 
 import (
+	"data-cron-server/audit"
 	"data-cron-server/auth"
 	"data-cron-server/config"
 	"data-cron-server/cron"
 	"data-cron-server/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
-// handleAdminReload handles reloading the configuration file
+// handleAdminReload handles reloading the configuration from its store.
+// The users are replaced in place, so every component sees the reloaded
+// configuration, and only the jobs that changed are rescheduled.
 func (r *Router) handleAdminReload(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Get the config file path from environment variable
-	configFilePath := os.Getenv("CONFIG_FILE_PATH")
-	if configFilePath == "" {
-		configFilePath = "./config/config.json" // Default path
+	principal, _ := requestPrincipal(req)
+	users, err := r.config.Reload(principal)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load configuration: %v", err), http.StatusInternalServerError)
+		return
+	}
+	jobs := r.scheduler.Reconcile()
+
+	response := struct {
+		Success bool           `json:"success"`
+		Message string         `json:"message"`
+		Users   config.Changes `json:"users"`
+		Jobs    cron.JobsDiff  `json:"jobs"`
+	}{
+		Success: true,
+		Message: "Configuration reloaded",
+		Users:   users,
+		Jobs:    jobs,
 	}
 
-	// Load the configuration
-	newConfig, err := config.LoadConfig(configFilePath)
+	respondJSON(w, response)
+}
+
+// respondBackupError responds with the status of an error of the backups
+func respondBackupError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, config.ErrNoBackups):
+		http.Error(w, "Backups are not supported by the configuration store", http.StatusNotFound)
+	case errors.Is(err, config.ErrBackupNotFound):
+		http.Error(w, "Backup not found", http.StatusNotFound)
+	default:
+		http.Error(w, fmt.Sprintf("Failed to %s: %v", action, err), http.StatusInternalServerError)
+	}
+}
+
+// handleAdminBackups handles listing the backups of the configuration file
+func (r *Router) handleAdminBackups(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backups, err := r.config.Backups()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to load configuration: %v", err), http.StatusInternalServerError)
+		respondBackupError(w, "list backups", err)
+		return
+	}
+
+	respondJSON(w, backups)
+}
+
+// handleAdminBackup handles getting the content of a backup
+func (r *Router) handleAdminBackup(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Stop the current scheduler
-	r.scheduler.Stop()
+	generation, err := strconv.Atoi(getPathPart(req.URL.Path, 3)) // /admin/{super_key}/backups/{generation}
+	if err != nil {
+		http.Error(w, "Backup not found", http.StatusNotFound)
+		return
+	}
+
+	backup, err := r.config.LoadBackup(generation)
+	if err != nil {
+		respondBackupError(w, "load backup", err)
+		return
+	}
 
-	// Update the configuration
-	r.config = newConfig
+	respondJSON(w, config.RedactUsers(backup.Users))
+}
 
-	// Create a new scheduler with the updated configuration
-	r.scheduler = cron.NewScheduler(r.config)
+// handleAdminBackupRestore handles replacing the configuration by a backup
+func (r *Router) handleAdminBackupRestore(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	generation, err := strconv.Atoi(getPathPart(req.URL.Path, 3)) // /admin/{super_key}/backups/{generation}/restore
+	if err != nil {
+		http.Error(w, "Backup not found", http.StatusNotFound)
+		return
+	}
+
+	principal, _ := requestPrincipal(req)
+	if err := r.config.RestoreBackup(generation, principal); err != nil {
+		respondBackupError(w, "restore backup", err)
+		return
+	}
+
+	// Reschedule the jobs that changed
+	r.scheduler.Reconcile()
 
 	response := struct {
 		Success bool   `json:"success"`
 		Message string `json:"message"`
 	}{
 		Success: true,
-		Message: fmt.Sprintf("Configuration reloaded from %s", configFilePath),
+		Message: fmt.Sprintf("Configuration restored from backup %d, the replaced configuration is backup 1", generation),
+	}
+
+	respondJSON(w, response)
+}
+
+// revisionHistory returns the history of the configuration, responding
+// with an error if it keeps none
+func (r *Router) revisionHistory(w http.ResponseWriter) *config.History {
+	history := r.config.History()
+	if history == nil {
+		http.Error(w, "Revision history disabled", http.StatusNotFound)
+	}
+	return history
+}
+
+// handleAdminRevisions handles listing the revisions of the configuration
+func (r *Router) handleAdminRevisions(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	history := r.revisionHistory(w)
+	if history == nil {
+		return
+	}
+
+	respondJSON(w, history.Revisions())
+}
+
+// handleAdminRevision handles getting the users of a revision
+func (r *Router) handleAdminRevision(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	history := r.revisionHistory(w)
+	if history == nil {
+		return
+	}
+
+	number, err := strconv.Atoi(getPathPart(req.URL.Path, 3)) // /admin/{super_key}/revisions/{number}
+	if err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	users, err := history.Users(number)
+	if err == config.ErrRevisionNotFound {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load revision: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, config.RedactUsers(users))
+}
+
+// handleAdminRevisionDiff handles comparing two revisions
+func (r *Router) handleAdminRevisionDiff(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	history := r.revisionHistory(w)
+	if history == nil {
+		return
+	}
+
+	from, err := strconv.Atoi(getPathPart(req.URL.Path, 3)) // /admin/{super_key}/revisions/{from}/diff/{to}
+	if err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	to, err := strconv.Atoi(getPathPart(req.URL.Path, 5))
+	if err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	diff, err := history.Diff(from, to)
+	if err == config.ErrRevisionNotFound {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to compare revisions: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, diff)
+}
+
+// handleAdminRevisionRollback handles replacing the configuration by a
+// revision
+func (r *Router) handleAdminRevisionRollback(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.revisionHistory(w) == nil {
+		return
+	}
+
+	number, err := strconv.Atoi(getPathPart(req.URL.Path, 3)) // /admin/{super_key}/revisions/{number}/rollback
+	if err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	principal, _ := requestPrincipal(req)
+	err = r.config.Rollback(number, principal)
+	if err == config.ErrRevisionNotFound {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to roll back: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Reschedule the jobs that changed
+	jobs := r.scheduler.Reconcile()
+
+	response := struct {
+		Success bool          `json:"success"`
+		Message string        `json:"message"`
+		Jobs    cron.JobsDiff `json:"jobs"`
+	}{
+		Success: true,
+		Message: fmt.Sprintf("Configuration rolled back to revision %d", number),
+		Jobs:    jobs,
 	}
 
 	respondJSON(w, response)
@@ -133,8 +349,13 @@ func (r *Router) handleCronAllJobsActivation(w http.ResponseWriter, req *http.Re
 func (r *Router) handleAdminUsers(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
-		// List all users
-		users := r.config.GetAllUsers()
+		// List all users, organizations are listed separately
+		users := make([]string, 0)
+		for _, user := range r.config.GetAllUsers() {
+			if !config.IsOrg(user) {
+				users = append(users, user)
+			}
+		}
 		respondJSON(w, users)
 
 	case http.MethodPost:
@@ -152,6 +373,10 @@ func (r *Router) handleAdminUsers(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, "User ID is required", http.StatusBadRequest)
 			return
 		}
+		if config.IsOrg(userData.User) {
+			http.Error(w, fmt.Sprintf("User ID must not start with %s", config.OrgPrefix), http.StatusBadRequest)
+			return
+		}
 
 		// Create user
 		r.config.CreateUser(userData.User)
@@ -165,23 +390,629 @@ func (r *Router) handleAdminUsers(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		if r.config.DeleteUser(user) {
+		if !config.IsOrg(user) && r.config.DeleteUser(user) {
 			w.WriteHeader(http.StatusNoContent)
 		} else {
 			http.Error(w, "User not found", http.StatusNotFound)
 		}
 
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiKeyInfo is the public view of an API key, without its hash
+type apiKeyInfo struct {
+	ID         string         `json:"id"`
+	Label      string         `json:"label,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	Scopes     []config.Scope `json:"scopes,omitempty"`
+	NotBefore  *time.Time     `json:"not_before,omitempty"`
+	ExpiresAt  *time.Time     `json:"expires_at,omitempty"`
+	ReplacedBy string         `json:"replaced_by,omitempty"`
+}
+
+// newAPIKeyInfo creates the public view of an API key
+func newAPIKeyInfo(key *config.APIKey) apiKeyInfo {
+	return apiKeyInfo{
+		ID:         key.ID,
+		Label:      key.Label,
+		CreatedAt:  key.CreatedAt,
+		Scopes:     key.Scopes,
+		NotBefore:  key.NotBefore,
+		ExpiresAt:  key.ExpiresAt,
+		ReplacedBy: key.ReplacedBy,
+	}
+}
+
+// handleAdminUserKeys handles listing and issuing API keys for a user
+func (r *Router) handleAdminUserKeys(w http.ResponseWriter, req *http.Request) {
+	user := getPathPart(req.URL.Path, 3) // /admin/{super_key}/users/{user}/keys
+	if r.config.GetUser(user) == nil || config.IsOrg(user) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		// List keys without their hashes
+		keys := r.config.GetUserAPIKeys(user)
+		infos := make([]apiKeyInfo, 0, len(keys))
+		for _, key := range keys {
+			infos = append(infos, newAPIKeyInfo(key))
+		}
+		respondJSON(w, infos)
+
+	case http.MethodPost:
+		// Issue a new key, the body with a label, scopes and validity period
+		// is optional
+		var keyData struct {
+			Label     string         `json:"label"`
+			Scopes    []config.Scope `json:"scopes"`
+			NotBefore *time.Time     `json:"not_before"`
+			ExpiresAt *time.Time     `json:"expires_at"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&keyData); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := auth.ValidateScopes(keyData.Scopes); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid scopes: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := auth.ValidateKeyValidity(keyData.NotBefore, keyData.ExpiresAt, time.Now()); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid validity period: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		plaintext, key, err := auth.IssueAPIKey(keyData.Label)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to generate key: %v", err), http.StatusInternalServerError)
+			return
+		}
+		key.Scopes = keyData.Scopes
+		key.NotBefore, key.ExpiresAt = keyData.NotBefore, keyData.ExpiresAt
+		if err := r.auth.EnableSigning(key, plaintext); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to generate key: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if !r.config.AddUserAPIKey(user, key) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		respondJSONStatus(w, http.StatusCreated, issuedKeyResponse{apiKeyInfo: newAPIKeyInfo(key), Key: plaintext})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminUserKey handles labelling and revoking a single API key
+func (r *Router) handleAdminUserKey(w http.ResponseWriter, req *http.Request) {
+	user := getPathPart(req.URL.Path, 3) // /admin/{super_key}/users/{user}/keys/{key_id}
+	keyID := getPathPart(req.URL.Path, 5)
+
+	switch req.Method {
+	case http.MethodPut, http.MethodPatch:
+		// Change the label
+		var keyData struct {
+			Label string `json:"label"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&keyData); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if !r.config.SetUserAPIKeyLabel(user, keyID, keyData.Label) {
+			http.Error(w, "Key not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		// Revoke the key
+		if !r.config.DeleteUserAPIKey(user, keyID) {
+			http.Error(w, "Key not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminUserKeyRotate handles replacing an API key of a user by a new
+// one
+func (r *Router) handleAdminUserKeyRotate(w http.ResponseWriter, req *http.Request) {
+	user := getPathPart(req.URL.Path, 3) // /admin/{super_key}/users/{user}/keys/{key_id}/rotate
+	keyID := getPathPart(req.URL.Path, 5)
+
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var old *config.APIKey
+	if !config.IsOrg(user) {
+		old = findAPIKey(r.config.GetUserAPIKeys(user), keyID)
+	}
+	plaintext, key, graceEnd, ok := issueRotatedKey(w, req, old)
+	if !ok {
+		return
+	}
+	if err := r.auth.EnableSigning(key, plaintext); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to generate key: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if !r.config.RotateUserAPIKey(user, keyID, key, graceEnd) {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+
+	respondJSONStatus(w, http.StatusCreated, issuedKeyResponse{apiKeyInfo: newAPIKeyInfo(key), Key: plaintext})
+}
+
+// findAPIKey returns the key with the ID, nil if there is none
+func findAPIKey(keys []*config.APIKey, keyID string) *config.APIKey {
+	for _, key := range keys {
+		if key.ID == keyID {
+			return key
+		}
+	}
+	return nil
+}
+
+// issueRotatedKey issues the key replacing old with the same label and
+// scopes. The new key expires at expires_at from the body or, if old
+// expires, after the same lifetime. The old key stays valid for the grace
+// period in seconds from the body, by default one day. It responds with an
+// error and returns false if the key cannot be rotated.
+func issueRotatedKey(w http.ResponseWriter, req *http.Request, old *config.APIKey) (string, *config.APIKey, time.Time, bool) {
+	if old == nil {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return "", nil, time.Time{}, false
+	}
+	if old.ReplacedBy != "" {
+		http.Error(w, fmt.Sprintf("Key was already rotated, replaced by %s", old.ReplacedBy), http.StatusConflict)
+		return "", nil, time.Time{}, false
+	}
+
+	var rotateData struct {
+		GracePeriod *int       `json:"grace_period"`
+		ExpiresAt   *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&rotateData); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return "", nil, time.Time{}, false
+	}
+
+	now := time.Now().UTC()
+	grace := auth.DefaultRotationGrace
+	if rotateData.GracePeriod != nil {
+		if *rotateData.GracePeriod < 0 {
+			http.Error(w, "grace_period must not be negative", http.StatusBadRequest)
+			return "", nil, time.Time{}, false
+		}
+		grace = time.Duration(*rotateData.GracePeriod) * time.Second
+	}
+
+	expiresAt := rotateData.ExpiresAt
+	if expiresAt == nil && old.ExpiresAt != nil {
+		lifetime := now.Add(old.ExpiresAt.Sub(old.CreatedAt))
+		expiresAt = &lifetime
+	}
+	if err := auth.ValidateKeyValidity(nil, expiresAt, now); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid validity period: %v", err), http.StatusBadRequest)
+		return "", nil, time.Time{}, false
+	}
+
+	plaintext, key, err := auth.IssueAPIKey(old.Label)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to generate key: %v", err), http.StatusInternalServerError)
+		return "", nil, time.Time{}, false
+	}
+	key.Scopes = old.Scopes
+	key.ExpiresAt = expiresAt
+
+	return plaintext, key, now.Add(grace), true
+}
+
+// handleAdminExpiringKeys handles listing the user and admin keys that
+// expire soon
+func (r *Router) handleAdminExpiringKeys(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	within := r.auth.KeyExpiryWarning()
+	if value := req.URL.Query().Get("within_days"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			http.Error(w, "Invalid within_days", http.StatusBadRequest)
+			return
+		}
+		within = time.Duration(days) * 24 * time.Hour
+	}
+
+	respondJSON(w, r.auth.ExpiringKeys(within, time.Now()))
+}
+
+// adminInfo is the public view of an admin account
+type adminInfo struct {
+	Name         string       `json:"name"`
+	Role         string       `json:"role"`
+	CreatedAt    time.Time    `json:"created_at"`
+	Keys         []apiKeyInfo `json:"keys"`
+	AllowedCIDRs []string     `json:"allowed_cidrs,omitempty"`
+}
+
+// newAdminInfo creates the public view of an admin account
+func newAdminInfo(name string, admin *config.Admin) adminInfo {
+	keys := make([]apiKeyInfo, 0, len(admin.Keys))
+	for _, key := range admin.Keys {
+		keys = append(keys, newAPIKeyInfo(key))
+	}
+
+	return adminInfo{
+		Name:         name,
+		Role:         admin.Role,
+		CreatedAt:    admin.CreatedAt,
+		Keys:         keys,
+		AllowedCIDRs: admin.AllowedCIDRs,
+	}
+}
+
+// issuedKeyResponse is returned when a key is issued. It is the only
+// response that contains the plaintext key.
+type issuedKeyResponse struct {
+	apiKeyInfo
+	Key string `json:"key"`
+}
+
+// persistAdmins saves the admin accounts right away, as a key issued to an
+// admin is only handed out once. It responds with an error if they cannot
+// be saved; the change is then kept in memory and saved with the next
+// auto-save.
+func (r *Router) persistAdmins(w http.ResponseWriter) bool {
+	if err := r.admins.Save(); err != nil {
+		log.Printf("Error saving admins: %v", err)
+		http.Error(w, "Failed to save admins", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// handleAdminAdmins handles listing and creating admin accounts
+func (r *Router) handleAdminAdmins(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		// List all admins with their keys
+		admins := make([]adminInfo, 0)
+		for _, name := range r.admins.GetAllAdmins() {
+			if admin := r.admins.GetAdmin(name); admin != nil {
+				admins = append(admins, newAdminInfo(name, admin))
+			}
+		}
+		respondJSON(w, admins)
+
+	case http.MethodPost:
+		// Create a new admin together with its first key
+		var adminData struct {
+			Name      string     `json:"name"`
+			Role      string     `json:"role"`
+			Label     string     `json:"label"`
+			NotBefore *time.Time `json:"not_before"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}
+
+		if err := json.NewDecoder(req.Body).Decode(&adminData); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if adminData.Name == "" || adminData.Name == auth.BootstrapAdmin {
+			http.Error(w, "A valid admin name is required", http.StatusBadRequest)
+			return
+		}
+		if !auth.ValidRole(adminData.Role) {
+			http.Error(w, fmt.Sprintf("Role must be one of %s, %s or %s", auth.RoleViewer, auth.RoleOperator, auth.RoleOwner), http.StatusBadRequest)
+			return
+		}
+		if err := auth.ValidateKeyValidity(adminData.NotBefore, adminData.ExpiresAt, time.Now()); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid validity period: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		plaintext, key, err := auth.IssueAPIKey(adminData.Label)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to generate key: %v", err), http.StatusInternalServerError)
+			return
+		}
+		key.NotBefore, key.ExpiresAt = adminData.NotBefore, adminData.ExpiresAt
+
+		if _, created := r.admins.CreateAdmin(adminData.Name, adminData.Role); !created {
+			http.Error(w, "Admin already exists", http.StatusConflict)
+			return
+		}
+		r.admins.AddAdminKey(adminData.Name, key)
+		annotateAudit(req, func(event *audit.Event) {
+			event.Target = adminData.Name + "/keys/" + key.ID
+			event.Reason = "role " + adminData.Role
+		})
+		if !r.persistAdmins(w) {
+			return
+		}
+
+		response := struct {
+			Name string            `json:"name"`
+			Role string            `json:"role"`
+			Key  issuedKeyResponse `json:"key"`
+		}{
+			Name: adminData.Name,
+			Role: adminData.Role,
+			Key:  issuedKeyResponse{apiKeyInfo: newAPIKeyInfo(key), Key: plaintext},
+		}
+
+		respondJSONStatus(w, http.StatusCreated, response)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminAdmin handles changing the role of and deleting an admin account
+func (r *Router) handleAdminAdmin(w http.ResponseWriter, req *http.Request) {
+	name := getPathPart(req.URL.Path, 3) // /admin/{super_key}/admins/{name}
+
+	switch req.Method {
+	case http.MethodGet:
+		admin := r.admins.GetAdmin(name)
+		if admin == nil {
+			http.Error(w, "Admin not found", http.StatusNotFound)
+			return
+		}
+		respondJSON(w, newAdminInfo(name, admin))
+
+	case http.MethodPut:
+		// Change the role and/or the address allowlist
+		var adminData struct {
+			Role         string    `json:"role"`
+			AllowedCIDRs *[]string `json:"allowed_cidrs"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&adminData); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if adminData.Role != "" || adminData.AllowedCIDRs == nil {
+			if !auth.ValidRole(adminData.Role) {
+				http.Error(w, fmt.Sprintf("Role must be one of %s, %s or %s", auth.RoleViewer, auth.RoleOperator, auth.RoleOwner), http.StatusBadRequest)
+				return
+			}
+		}
+		if adminData.AllowedCIDRs != nil {
+			if err := auth.ValidateCIDRs(*adminData.AllowedCIDRs); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid allowed_cidrs: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		admin := r.admins.GetAdmin(name)
+		if admin == nil {
+			http.Error(w, "Admin not found", http.StatusNotFound)
+			return
+		}
+		var changes []string
+		if adminData.Role != "" {
+			changes = append(changes, fmt.Sprintf("role %s to %s", admin.Role, adminData.Role))
+			r.admins.SetAdminRole(name, adminData.Role)
+		}
+		if adminData.AllowedCIDRs != nil {
+			changes = append(changes, fmt.Sprintf("allowed_cidrs %s", strings.Join(*adminData.AllowedCIDRs, ",")))
+			r.admins.SetAdminAllowedCIDRs(name, *adminData.AllowedCIDRs)
+		}
+		annotateAudit(req, func(event *audit.Event) {
+			event.Reason = strings.Join(changes, "; ")
+		})
+		if !r.persistAdmins(w) {
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		// Delete the admin and revoke all of its keys
+		if !r.admins.DeleteAdmin(name) {
+			http.Error(w, "Admin not found", http.StatusNotFound)
+			return
+		}
+		if !r.persistAdmins(w) {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminAdminKeys handles issuing additional keys for an admin account
+func (r *Router) handleAdminAdminKeys(w http.ResponseWriter, req *http.Request) {
+	name := getPathPart(req.URL.Path, 3) // /admin/{super_key}/admins/{name}/keys
+
+	switch req.Method {
+	case http.MethodPost:
+		var keyData struct {
+			Label     string     `json:"label"`
+			NotBefore *time.Time `json:"not_before"`
+			ExpiresAt *time.Time `json:"expires_at"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&keyData); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := auth.ValidateKeyValidity(keyData.NotBefore, keyData.ExpiresAt, time.Now()); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid validity period: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		plaintext, key, err := auth.IssueAPIKey(keyData.Label)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to generate key: %v", err), http.StatusInternalServerError)
+			return
+		}
+		key.NotBefore, key.ExpiresAt = keyData.NotBefore, keyData.ExpiresAt
+
+		if !r.admins.AddAdminKey(name, key) {
+			http.Error(w, "Admin not found", http.StatusNotFound)
+			return
+		}
+		annotateAudit(req, func(event *audit.Event) {
+			event.Target = name + "/keys/" + key.ID
+		})
+		if !r.persistAdmins(w) {
+			return
+		}
+
+		respondJSONStatus(w, http.StatusCreated, issuedKeyResponse{apiKeyInfo: newAPIKeyInfo(key), Key: plaintext})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminAdminKey handles revoking a key of an admin account
+func (r *Router) handleAdminAdminKey(w http.ResponseWriter, req *http.Request) {
+	name := getPathPart(req.URL.Path, 3) // /admin/{super_key}/admins/{name}/keys/{key_id}
+	keyID := getPathPart(req.URL.Path, 5)
+
+	switch req.Method {
+	case http.MethodDelete:
+		if !r.admins.DeleteAdminKey(name, keyID) {
+			http.Error(w, "Key not found", http.StatusNotFound)
+			return
+		}
+		if !r.persistAdmins(w) {
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminAdminKeyRotate handles replacing a key of an admin account by
+// a new one
+func (r *Router) handleAdminAdminKeyRotate(w http.ResponseWriter, req *http.Request) {
+	name := getPathPart(req.URL.Path, 3) // /admin/{super_key}/admins/{name}/keys/{key_id}/rotate
+	keyID := getPathPart(req.URL.Path, 5)
+
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var old *config.APIKey
+	if admin := r.admins.GetAdmin(name); admin != nil {
+		old = findAPIKey(admin.Keys, keyID)
+	}
+	plaintext, key, graceEnd, ok := issueRotatedKey(w, req, old)
+	if !ok {
+		return
+	}
+
+	if !r.admins.RotateAdminKey(name, keyID, key, graceEnd) {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	annotateAudit(req, func(event *audit.Event) {
+		event.Reason = "replaced by key " + key.ID
+	})
+	if !r.persistAdmins(w) {
+		return
+	}
+
+	respondJSONStatus(w, http.StatusCreated, issuedKeyResponse{apiKeyInfo: newAPIKeyInfo(key), Key: plaintext})
+}
+
+// handleAdminLockouts handles listing and clearing all lockouts
+func (r *Router) handleAdminLockouts(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		respondJSON(w, r.auth.Lockouts())
+
+	case http.MethodDelete:
+		r.auth.ClearAllLockouts()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminLockout handles clearing the lockout of one IP or key
+func (r *Router) handleAdminLockout(w http.ResponseWriter, req *http.Request) {
+	id := getPathPart(req.URL.Path, 3) // /admin/{super_key}/lockouts/{id}
+
+	switch req.Method {
+	case http.MethodDelete:
+		if !r.auth.ClearLockout(id) {
+			http.Error(w, "Lockout not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminConfigExport renders the configuration in the format of the
+// format parameter, JSON by default
+func (r *Router) handleAdminConfigExport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := config.FormatJSON
+	if name := req.URL.Query().Get("format"); name != "" {
+		var err error
+		if format, err = config.ParseFormat(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	data, err := r.config.Export(format)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to export config: %v", err), http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Set("Content-Type", config.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"config.%s\"", format))
+	w.Write(data)
 }
 
 // handleAdminConfig handles the admin config endpoint
 func (r *Router) handleAdminConfig(w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
-		// Get full config
-		respondJSON(w, r.config.Users)
+		// Get full config without the credentials of the keys
+		respondJSON(w, r.config.RedactedUsers())
 
 	case http.MethodPut:
 		// Replace full config
@@ -212,11 +1043,10 @@ func (r *Router) handleAdminConfig(w http.ResponseWriter, req *http.Request) {
 		}
 
 		// Replace config
-		r.config.Users = users
+		r.config.ReplaceUsers(users)
 
-		// Reload scheduler
-		r.scheduler.Stop()
-		r.scheduler = cron.NewScheduler(r.config)
+		// Reschedule the jobs that changed
+		r.scheduler.Reconcile()
 
 		w.WriteHeader(http.StatusOK)
 
@@ -236,8 +1066,14 @@ func (r *Router) handleStatus(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		// Get job statuses
+		// Get job statuses, limited to the jobs the key may see
 		statuses := r.scheduler.GetAllJobStatus(user)
+		scopes := auth.ScopesFromContext(req.Context())
+		for jobID := range statuses {
+			if !auth.ScopesAllow(scopes, auth.ResourceStatus, req.Method, jobID) {
+				delete(statuses, jobID)
+			}
+		}
 		respondJSON(w, statuses)
 
 	default:
@@ -256,8 +1092,14 @@ func (r *Router) handleCronJobs(w http.ResponseWriter, req *http.Request) {
 
 	switch req.Method {
 	case http.MethodGet:
-		// List all jobs
-		jobs := r.config.GetUserJobs(user)
+		// List all jobs the key may see
+		scopes := auth.ScopesFromContext(req.Context())
+		jobs := make([]*config.CronJob, 0)
+		for _, job := range r.config.GetUserJobs(user) {
+			if auth.ScopesAllow(scopes, auth.ResourceCron, req.Method, job.ID) {
+				jobs = append(jobs, job)
+			}
+		}
 		respondJSON(w, jobs)
 
 	case http.MethodPost:
@@ -326,12 +1168,8 @@ func (r *Router) handleCronJobs(w http.ResponseWriter, req *http.Request) {
 			r.scheduler.RemoveJob(user, job.ID)
 		}
 
-		// Add new jobs
-		userData := r.config.GetUser(user)
-		if userData == nil {
-			userData = r.config.CreateUser(user)
-		}
-		userData.Cron = jobs
+		// Replace the jobs
+		r.config.SetUserCron(user, jobs)
 
 		// Add jobs to scheduler
 		for _, job := range jobs {
@@ -374,6 +1212,27 @@ func (r *Router) handleCronJobActivation(w http.ResponseWriter, req *http.Reques
 	}
 	jobID := parts[2] // cron/user_key/job_id/[on|off]
 
+	r.setJobActivation(w, user, jobID, activate)
+}
+
+// handleAdminJobActivation handles activating or deactivating a cron job of
+// any user by an operator
+func (r *Router) handleAdminJobActivation(w http.ResponseWriter, req *http.Request, activate bool) {
+	// Only allow GET method for activation/deactivation endpoints
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := getPathPart(req.URL.Path, 3) // /admin/{super_key}/users/{user}/cron/{job_id}/[on|off]
+	jobID := getPathPart(req.URL.Path, 5)
+
+	r.setJobActivation(w, user, jobID, activate)
+}
+
+// setJobActivation activates or deactivates a cron job in the config and
+// the scheduler and responds with the new state
+func (r *Router) setJobActivation(w http.ResponseWriter, user, jobID string, activate bool) {
 	// Get the job
 	job, exists := r.config.GetUserJob(user, jobID)
 	if !exists {
@@ -517,8 +1376,14 @@ func (r *Router) handleDataKeys(w http.ResponseWriter, req *http.Request) {
 
 	switch req.Method {
 	case http.MethodGet:
-		// List all data keys
-		keys := r.config.GetUserKeys(user)
+		// List all data keys the key may see
+		scopes := auth.ScopesFromContext(req.Context())
+		keys := make([]string, 0)
+		for _, key := range r.config.GetUserKeys(user) {
+			if auth.ScopesAllow(scopes, auth.ResourceData, req.Method, key) {
+				keys = append(keys, key)
+			}
+		}
 		respondJSON(w, keys)
 
 	default:
@@ -544,8 +1409,16 @@ func (r *Router) handleData(w http.ResponseWriter, req *http.Request) {
 
 	switch req.Method {
 	case http.MethodGet:
-		// Get data
-		data, exists := r.config.GetUserData(user, dataKey)
+		// Get data, decrypted if the namespace is encrypted
+		key, ok := r.unlockData(w, req, user)
+		if !ok {
+			return
+		}
+		data, exists, err := r.config.ReadUserData(user, dataKey, key)
+		if err != nil {
+			respondDataEncryptionError(w, err)
+			return
+		}
 		if !exists {
 			http.Error(w, "Data not found", http.StatusNotFound)
 			return
@@ -561,8 +1434,15 @@ func (r *Router) handleData(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		// Set data
-		r.config.SetUserData(user, dataKey, data)
+		// Set data, encrypted if the namespace is encrypted
+		key, ok := r.unlockData(w, req, user)
+		if !ok {
+			return
+		}
+		if err := r.config.WriteUserData(user, dataKey, data, key); err != nil {
+			respondDataEncryptionError(w, err)
+			return
+		}
 
 		w.WriteHeader(http.StatusOK)
 
@@ -580,6 +1460,356 @@ func (r *Router) handleData(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// handleDataShare handles minting a share link for a data key
+func (r *Router) handleDataShare(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user from context
+	user, ok := auth.UserFromContext(req.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	dataKey := getPathPart(req.URL.Path, 2) // /data/{user_key}/{data_key}/share
+
+	// Links carry no encryption secret
+	if r.config.DataEncrypted(user) {
+		http.Error(w, "Encrypted data cannot be shared", http.StatusConflict)
+		return
+	}
+
+	// The body is optional, links default to reading for a day
+	shareData := struct {
+		Method    string `json:"method"`
+		ExpiresIn int64  `json:"expires_in"`
+	}{
+		Method:    http.MethodGet,
+		ExpiresIn: int64((24 * time.Hour).Seconds()),
+	}
+	if err := json.NewDecoder(req.Body).Decode(&shareData); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	shareData.Method = strings.ToUpper(shareData.Method)
+	if shareData.Method != http.MethodGet && shareData.Method != http.MethodPut {
+		http.Error(w, "Method must be GET or PUT", http.StatusBadRequest)
+		return
+	}
+
+	lifetime := time.Duration(shareData.ExpiresIn) * time.Second
+	if lifetime <= 0 || lifetime > auth.MaxShareLinkLifetime {
+		http.Error(w, fmt.Sprintf("expires_in must be between 1 and %d seconds", int64(auth.MaxShareLinkLifetime.Seconds())), http.StatusBadRequest)
+		return
+	}
+
+	// A key may only share what it could access itself
+	if !auth.ScopesAllow(auth.ScopesFromContext(req.Context()), auth.ResourceData, shareData.Method, dataKey) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	expires := time.Now().Add(lifetime).UTC().Truncate(time.Second)
+	link, err := r.auth.SignShareLink(user, dataKey, shareData.Method, expires)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to sign share link: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	response := struct {
+		URL       string    `json:"url"`
+		Method    string    `json:"method"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{
+		URL:       scheme + "://" + req.Host + link,
+		Method:    shareData.Method,
+		ExpiresAt: expires,
+	}
+
+	respondJSONStatus(w, http.StatusCreated, response)
+}
+
+// handleShare handles requests made with a share link
+func (r *Router) handleShare(w http.ResponseWriter, req *http.Request) {
+	// The path is /share/{user}/{data_key} with both parts escaped
+	parts := strings.Split(strings.Trim(req.URL.EscapedPath(), "/"), "/")
+	if len(parts) != 3 {
+		http.NotFound(w, req)
+		return
+	}
+	user, userErr := url.PathUnescape(parts[1])
+	dataKey, keyErr := url.PathUnescape(parts[2])
+	if userErr != nil || keyErr != nil {
+		http.Error(w, "Invalid share link", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.auth.VerifyShareLink(req, user, dataKey); err != nil {
+		if err == auth.ErrShareLinkExpired {
+			http.Error(w, "Share link expired", http.StatusGone)
+			return
+		}
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Links minted before the data was encrypted stop working
+	if r.config.DataEncrypted(user) {
+		http.Error(w, "Encrypted data cannot be shared", http.StatusConflict)
+		return
+	}
+
+	// The data is read and written without a data key, which fails if it
+	// was encrypted since the check above
+	switch req.Method {
+	case http.MethodGet:
+		data, exists, err := r.config.ReadUserData(user, dataKey, nil)
+		if err != nil {
+			respondDataEncryptionError(w, err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Data not found", http.StatusNotFound)
+			return
+		}
+
+		respondJSON(w, data)
+
+	case http.MethodPut:
+		var data interface{}
+		if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := r.config.WriteUserData(user, dataKey, data, nil); err != nil {
+			respondDataEncryptionError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminShareRotate handles revoking all share links of a user
+func (r *Router) handleAdminShareRotate(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := getPathPart(req.URL.Path, 3) // /admin/{super_key}/users/{user}/share/rotate
+
+	if err := r.auth.RotateShareSalt(user); err != nil {
+		if err == auth.ErrUserNotFound {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to rotate share salt: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminUserAllowlist handles reading and replacing the address
+// allowlist of a user
+func (r *Router) handleAdminUserAllowlist(w http.ResponseWriter, req *http.Request) {
+	user := getPathPart(req.URL.Path, 3) // /admin/{super_key}/users/{user}/allowlist
+	if config.IsOrg(user) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		cidrs, exists := r.config.GetUserAllowedCIDRs(user)
+		if !exists {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if cidrs == nil {
+			cidrs = []string{}
+		}
+		respondJSON(w, map[string][]string{"allowed_cidrs": cidrs})
+
+	case http.MethodPut:
+		// An empty list allows any address again
+		var allowlistData struct {
+			AllowedCIDRs []string `json:"allowed_cidrs"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&allowlistData); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := auth.ValidateCIDRs(allowlistData.AllowedCIDRs); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid allowed_cidrs: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if len(allowlistData.AllowedCIDRs) == 0 {
+			allowlistData.AllowedCIDRs = nil
+		}
+		if !r.config.SetUserAllowedCIDRs(user, allowlistData.AllowedCIDRs) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// orgInfo is the view of an organization
+type orgInfo struct {
+	Org     string            `json:"org"`
+	Members map[string]string `json:"members"`
+}
+
+// handleAdminOrgs handles listing and creating organizations
+func (r *Router) handleAdminOrgs(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		respondJSON(w, r.config.GetAllOrgs())
+
+	case http.MethodPost:
+		var orgData struct {
+			Org string `json:"org"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&orgData); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		org := strings.TrimPrefix(orgData.Org, config.OrgPrefix)
+		if org == "" || strings.Contains(org, "/") {
+			http.Error(w, "Organization name is required and must not contain /", http.StatusBadRequest)
+			return
+		}
+
+		if !r.config.CreateOrg(org) {
+			http.Error(w, "Organization already exists", http.StatusConflict)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminOrg handles reading and deleting an organization
+func (r *Router) handleAdminOrg(w http.ResponseWriter, req *http.Request) {
+	org := strings.TrimPrefix(getPathPart(req.URL.Path, 3), config.OrgPrefix) // /admin/{super_key}/orgs/{org}
+	namespace := config.OrgNamespace(org)
+
+	switch req.Method {
+	case http.MethodGet:
+		members, exists := r.config.GetOrgMembers(org)
+		if !exists {
+			http.Error(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		respondJSON(w, orgInfo{Org: org, Members: members})
+
+	case http.MethodDelete:
+		// Delete the organization with its jobs and data
+		for _, job := range r.config.GetUserJobs(namespace) {
+			r.scheduler.RemoveJob(namespace, job.ID)
+		}
+
+		if !r.config.DeleteUser(namespace) {
+			http.Error(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminOrgMembers handles listing the members of an organization
+func (r *Router) handleAdminOrgMembers(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	org := getPathPart(req.URL.Path, 3) // /admin/{super_key}/orgs/{org}/members
+
+	members, exists := r.config.GetOrgMembers(org)
+	if !exists {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, members)
+}
+
+// handleAdminOrgMember handles adding, changing and removing a member of an
+// organization
+func (r *Router) handleAdminOrgMember(w http.ResponseWriter, req *http.Request) {
+	org := getPathPart(req.URL.Path, 3) // /admin/{super_key}/orgs/{org}/members/{user}
+	user := getPathPart(req.URL.Path, 5)
+
+	switch req.Method {
+	case http.MethodPut:
+		var memberData struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&memberData); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if !auth.ValidOrgRole(memberData.Role) {
+			http.Error(w, fmt.Sprintf("Role must be one of %s, %s or %s", auth.OrgRoleViewer, auth.OrgRoleMember, auth.OrgRoleOwner), http.StatusBadRequest)
+			return
+		}
+
+		if config.IsOrg(user) || r.config.GetUser(user) == nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		if !r.config.SetOrgMember(org, user, memberData.Role) {
+			http.Error(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodDelete:
+		if !r.config.DeleteOrgMember(org, user) {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // respondJSON responds with JSON
 func respondJSON(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -588,6 +1818,15 @@ func respondJSON(w http.ResponseWriter, data interface{}) {
 	}
 }
 
+// respondJSONStatus responds with JSON and the given status code
+func respondJSONStatus(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
 // getPathPart gets a part from a URL path
 func getPathPart(path string, index int) string {
 	parts := strings.Split(strings.Trim(path, "/"), "/")
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+)
+
+// bufferedResponse holds back a response until it is known whether the
+// change it acknowledges is on disk
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// Header returns the header of the held back response
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+// WriteHeader records the status code
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// Write buffers the body
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(data)
+}
+
+// syncWrites syncs the configuration journal after a write and before its
+// response is sent, so a success is only reported for a change that
+// survives a crash. If the journal cannot be written the request fails,
+// though the change stays in memory and is saved with the next snapshot.
+func (r *Router) syncWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isWrite(req.Method, routePath(req.URL.Path)) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		response := &bufferedResponse{header: w.Header()}
+		next.ServeHTTP(response, req)
+
+		if err := r.config.SyncJournal(); err != nil {
+			log.Printf("Error writing configuration journal: %v", err)
+			for name := range response.header {
+				delete(response.header, name)
+			}
+			http.Error(w, "Failed to persist change", http.StatusInternalServerError)
+			return
+		}
+
+		if response.status == 0 {
+			response.status = http.StatusOK
+		}
+		w.WriteHeader(response.status)
+		w.Write(response.body.Bytes())
+	})
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// guardReadOnly rejects writes while the configuration is read-only, e.g.
+// because the configuration file was corrupt at startup. Restoring a backup
+// and reloading a repaired configuration stay possible, as they make the
+// configuration writable again.
+func (r *Router) guardReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := routePath(req.URL.Path)
+		reason, readOnly := r.config.ReadOnly()
+		recovery := matchPath(path, "/admin/*/backups/*/restore") || matchPath(path, "/admin/*/revisions/*/rollback") ||
+			matchPath(path, "/admin/*/reload")
+		if readOnly && isWrite(req.Method, path) && !recovery {
+			http.Error(w, fmt.Sprintf("Configuration is read-only: %s", reason), http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
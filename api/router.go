@@ -1,6 +1,7 @@
 package api
 
 import (
+	"data-cron-server/audit"
 	"data-cron-server/auth"
 	"data-cron-server/config"
 	"data-cron-server/cron"
@@ -12,23 +13,34 @@ import (
 type Router struct {
 	mux        *http.ServeMux
 	config     *config.Config
+	admins     *config.Admins
 	scheduler  *cron.Scheduler
 	auth       *auth.Authenticator
+	audit      *audit.Log
 }
 
-// NewRouter creates a new router
-func NewRouter(cfg *config.Config, scheduler *cron.Scheduler, superAdminKey string) http.Handler {
+// NewRouter creates a new router. Writes and authentication failures are
+// recorded in the audit log unless it is nil.
+func NewRouter(cfg *config.Config, admins *config.Admins, scheduler *cron.Scheduler, authenticator *auth.Authenticator, auditLog *audit.Log) http.Handler {
 	router := &Router{
 		mux:       http.NewServeMux(),
 		config:    cfg,
+		admins:    admins,
 		scheduler: scheduler,
-		auth:      auth.NewAuthenticator(cfg, superAdminKey),
+		auth:      authenticator,
+		audit:     auditLog,
+	}
+
+	if auditLog != nil {
+		authenticator.SetFailureHook(router.recordAuthFailure)
 	}
 
 	// Setup routes
 	router.setupAdminRoutes()
 	router.setupCronRoutes()
 	router.setupDataRoutes()
+	router.setupShareRoutes()
+	router.setupAuditRoutes()
 	router.setupHealthCheck()
 
 	return router.mux
@@ -37,43 +49,136 @@ func NewRouter(cfg *config.Config, scheduler *cron.Scheduler, superAdminKey stri
 // setupAdminRoutes sets up admin routes
 func (r *Router) setupAdminRoutes() {
 	// Admin routes - require super admin authentication
-	adminHandler := r.auth.RequireSuperAdmin(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		path := req.URL.Path
+	adminHandler := r.auth.RequireSuperAdmin(r.guardReadOnly(r.auditWrites(r.syncWrites(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := routePath(req.URL.Path)
+
+		// Check the role of the admin before routing
+		_, role, _ := auth.AdminFromContext(req.Context())
+		if !auth.RoleAllows(role, requiredAdminRole(req.Method, path)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 
 		// Route based on path pattern
 		switch {
 		case matchPath(path, "/admin/*/reload"):
 			r.handleAdminReload(w, req)
+		case matchPath(path, "/admin/*/backups"):
+			r.handleAdminBackups(w, req)
+		case matchPath(path, "/admin/*/backups/*"):
+			r.handleAdminBackup(w, req)
+		case matchPath(path, "/admin/*/backups/*/restore"):
+			r.handleAdminBackupRestore(w, req)
+		case matchPath(path, "/admin/*/revisions"):
+			r.handleAdminRevisions(w, req)
+		case matchPath(path, "/admin/*/revisions/*"):
+			r.handleAdminRevision(w, req)
+		case matchPath(path, "/admin/*/revisions/*/diff/*"):
+			r.handleAdminRevisionDiff(w, req)
+		case matchPath(path, "/admin/*/revisions/*/rollback"):
+			r.handleAdminRevisionRollback(w, req)
+		case matchPath(path, "/admin/*/keys/expiring"):
+			r.handleAdminExpiringKeys(w, req)
+		case matchPath(path, "/admin/*/audit"):
+			r.handleAdminAudit(w, req)
+		case matchPath(path, "/admin/*/audit/verify"):
+			r.handleAdminAuditVerify(w, req)
+		case matchPath(path, "/admin/*/lockouts"):
+			r.handleAdminLockouts(w, req)
+		case matchPath(path, "/admin/*/lockouts/*"):
+			r.handleAdminLockout(w, req)
+		case matchPath(path, "/admin/*/admins"):
+			r.handleAdminAdmins(w, req)
+		case matchPath(path, "/admin/*/admins/*"):
+			r.handleAdminAdmin(w, req)
+		case matchPath(path, "/admin/*/admins/*/keys"):
+			r.handleAdminAdminKeys(w, req)
+		case matchPath(path, "/admin/*/admins/*/keys/*/rotate"):
+			r.handleAdminAdminKeyRotate(w, req)
+		case matchPath(path, "/admin/*/admins/*/keys/*"):
+			r.handleAdminAdminKey(w, req)
+		case matchPath(path, "/admin/*/orgs"):
+			r.handleAdminOrgs(w, req)
+		case matchPath(path, "/admin/*/orgs/*"):
+			r.handleAdminOrg(w, req)
+		case matchPath(path, "/admin/*/orgs/*/members"):
+			r.handleAdminOrgMembers(w, req)
+		case matchPath(path, "/admin/*/orgs/*/members/*"):
+			r.handleAdminOrgMember(w, req)
+		case matchPath(path, "/admin/*/users/*/allowlist"):
+			r.handleAdminUserAllowlist(w, req)
+		case matchPath(path, "/admin/*/users/*/share/rotate"):
+			r.handleAdminShareRotate(w, req)
+		case matchPath(path, "/admin/*/users/*/cron/*/on"):
+			r.handleAdminJobActivation(w, req, true)
+		case matchPath(path, "/admin/*/users/*/cron/*/off"):
+			r.handleAdminJobActivation(w, req, false)
+		case matchPath(path, "/admin/*/users/*/keys"):
+			r.handleAdminUserKeys(w, req)
+		case matchPath(path, "/admin/*/users/*/keys/*/rotate"):
+			r.handleAdminUserKeyRotate(w, req)
+		case matchPath(path, "/admin/*/users/*/keys/*"):
+			r.handleAdminUserKey(w, req)
 		case matchPath(path, "/admin/*/users"):
 			r.handleAdminUsers(w, req)
 		case matchPath(path, "/admin/*/config"):
 			r.handleAdminConfig(w, req)
+		case matchPath(path, "/admin/*/config/export"):
+			r.handleAdminConfigExport(w, req)
 		default:
 			http.NotFound(w, req)
 		}
-	}))
+	})))))
 
 	r.mux.Handle("/admin/", adminHandler)
+	r.mux.Handle("/v1/admin/", adminHandler)
+}
+
+// requiredAdminRole returns the minimum role needed for an admin request
+func requiredAdminRole(method, path string) string {
+	switch {
+	case matchPath(path, "/admin/*/reload"),
+		getPathPart(path, 2) == "lockouts",
+		getPathPart(path, 2) == "audit",
+		matchPath(path, "/admin/*/users/*/cron/*/on"),
+		matchPath(path, "/admin/*/users/*/cron/*/off"):
+		return auth.RoleOperator
+	case getPathPart(path, 2) == "admins":
+		return auth.RoleOwner
+	case method == http.MethodGet:
+		return auth.RoleViewer
+	default:
+		return auth.RoleOwner
+	}
+}
+
+// requireUser wraps a user handler with authentication, only accepting
+// signed requests if the authenticator requires them
+func (r *Router) requireUser(next http.Handler) http.Handler {
+	if r.auth.SignaturesRequired() {
+		return r.auth.RequireSignature(next)
+	}
+	return r.auth.RequireUser(next)
 }
 
 // setupCronRoutes sets up cron routes
 func (r *Router) setupCronRoutes() {
 	// Cron routes - require user authentication
-	cronHandler := r.auth.RequireUser(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		path := req.URL.Path
+	cronHandler := r.requireUser(r.guardReadOnly(r.auditWrites(r.syncWrites(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := routePath(req.URL.Path)
 
 		// Route based on path pattern
 		switch {
 		case matchPath(path, "/status/*"):
 			r.handleStatus(w, req)
-		case matchPath(path, "/cron/*/on"):
-			r.handleCronAllJobsActivation(w, req, true)
-		case matchPath(path, "/cron/*/off"):
-			r.handleCronAllJobsActivation(w, req, false)
 		case matchPath(path, "/cron/*/*/on"):
 			r.handleCronJobActivation(w, req, true)
 		case matchPath(path, "/cron/*/*/off"):
 			r.handleCronJobActivation(w, req, false)
+		case matchPath(path, "/cron/*/on"):
+			r.handleCronAllJobsActivation(w, req, true)
+		case matchPath(path, "/cron/*/off"):
+			r.handleCronAllJobsActivation(w, req, false)
 		case matchPath(path, "/cron/*/*"):
 			r.handleCronJob(w, req)
 		case matchPath(path, "/cron/*"):
@@ -81,40 +186,82 @@ func (r *Router) setupCronRoutes() {
 		default:
 			http.NotFound(w, req)
 		}
-	}))
+	})))))
 
 	r.mux.Handle("/status/", cronHandler)
 	r.mux.Handle("/cron/", cronHandler)
+	r.mux.Handle("/v1/status", cronHandler)
+	r.mux.Handle("/v1/cron", cronHandler)
+	r.mux.Handle("/v1/cron/", cronHandler)
 }
 
 // setupDataRoutes sets up data routes
 func (r *Router) setupDataRoutes() {
 	// Data routes - require user authentication
-	dataHandler := r.auth.RequireUser(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		path := req.URL.Path
+	dataHandler := r.requireUser(r.guardReadOnly(r.auditWrites(r.syncWrites(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := routePath(req.URL.Path)
 
 		// Route based on path pattern
 		switch {
 		case matchPath(path, "/data/*/keys"):
 			r.handleDataKeys(w, req)
+		case matchPath(path, "/data/*/-/encryption"):
+			// "-" takes the place of the data key, like that of the user
+			// key in keyless routes, so no data key is shadowed
+			r.handleDataEncryption(w, req)
+		case matchPath(path, "/data/*/*/share"):
+			r.handleDataShare(w, req)
 		case matchPath(path, "/data/*/*"):
 			r.handleData(w, req)
 		default:
 			http.NotFound(w, req)
 		}
-	}))
+	})))))
 
 	r.mux.Handle("/data/", dataHandler)
+	r.mux.Handle("/v1/data/", dataHandler)
+}
+
+// setupShareRoutes sets up the public share link route, which is
+// authenticated by the link signature instead of a key
+func (r *Router) setupShareRoutes() {
+	r.mux.Handle("/share/", r.guardReadOnly(r.auditWrites(r.syncWrites(http.HandlerFunc(r.handleShare)))))
+}
+
+// setupAuditRoutes sets up the route users read the audit events of their
+// namespace from
+func (r *Router) setupAuditRoutes() {
+	auditHandler := r.requireUser(http.HandlerFunc(r.handleAudit))
+
+	r.mux.Handle("/audit/", auditHandler)
+	r.mux.Handle("/v1/audit", auditHandler)
 }
 
-// setupHealthCheck sets up health check route
+// setupHealthCheck sets up health check route. A read-only configuration is
+// reported as unhealthy with the reason.
 func (r *Router) setupHealthCheck() {
 	r.mux.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
+		if reason, readOnly := r.config.ReadOnly(); readOnly {
+			http.Error(w, "Read-only: "+reason, http.StatusServiceUnavailable)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
 }
 
+// routePath maps a keyless route onto the layout of the legacy routes, so
+// that /v1/cron/{job_id} is matched like /cron/{user_key}/{job_id}. Handlers
+// read the same path indexes for both forms.
+func routePath(path string) string {
+	if !strings.HasPrefix(path, auth.KeylessPrefix) {
+		return path
+	}
+
+	resource, rest, _ := strings.Cut(strings.TrimPrefix(path, auth.KeylessPrefix), "/")
+	return "/" + resource + "/-/" + rest
+}
+
 // matchPath checks if a path matches a pattern
 func matchPath(path, pattern string) bool {
 	pathParts := strings.Split(strings.Trim(path, "/"), "/")
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"data-cron-server/auth"
+	"data-cron-server/config"
+	"data-cron-server/cron"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDataKeyNamedEncryption(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.CreateUser("alice")
+	plaintext, key, err := auth.IssueAPIKey("test")
+	if err != nil {
+		t.Fatalf("IssueAPIKey() failed: %v", err)
+	}
+	cfg.AddUserAPIKey("alice", key)
+
+	scheduler := cron.NewScheduler(cfg)
+	defer scheduler.Stop()
+	router := NewRouter(cfg, config.NewAdmins(), scheduler, auth.NewAuthenticator(cfg, "super_admin_key"), nil)
+
+	serve := func(method, path, body, secret string) (int, string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", plaintext)
+		if secret != "" {
+			req.Header.Set(HeaderEncryptionSecret, secret)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code, strings.TrimSpace(rr.Body.String())
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		secret   string
+		expected int
+		response string
+	}{
+		{"write data key", "PUT", "/v1/data/encryption", `{"mode":"none"}`, "", http.StatusOK, ""},
+		{"read data key", "GET", "/v1/data/encryption", "", "", http.StatusOK, `{"mode":"none"}`},
+		{"read data key with path key", "GET", "/data/" + plaintext + "/encryption", "", "", http.StatusOK, `{"mode":"none"}`},
+		{"check encryption", "GET", "/v1/data/-/encryption", "", "", http.StatusOK, `{"enabled":false}`},
+		{"encrypt data", "PUT", "/v1/data/-/encryption", `{"secret":"correct horse battery"}`, "", http.StatusOK, ""},
+		{"check encryption with path key", "GET", "/data/" + plaintext + "/-/encryption", "", "", http.StatusOK, `{"enabled":true}`},
+		{"read encrypted data key", "GET", "/v1/data/encryption", "", "correct horse battery", http.StatusOK, `{"mode":"none"}`},
+		{"decrypt data", "DELETE", "/v1/data/-/encryption", "", "correct horse battery", http.StatusNoContent, ""},
+		{"delete data key", "DELETE", "/v1/data/encryption", "", "", http.StatusNoContent, ""},
+		{"read deleted data key", "GET", "/v1/data/encryption", "", "", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		code, body := serve(tt.method, tt.path, tt.body, tt.secret)
+		if code != tt.expected || (tt.response != "" && body != tt.response) {
+			t.Errorf("%s: %s %s = %d, %s, expected %d, %s", tt.name, tt.method, tt.path, code, body, tt.expected, tt.response)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ActionAuthFailure is the action of rejected authentication attempts
+const ActionAuthFailure = "auth.failure"
+
+// Query limits
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+// Event is one entry of the audit log
+type Event struct {
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+	// Principal is who acted, e.g. "user:alice", "admin:super_admin",
+	// "share" or "key:<id>" for failed attempts
+	Principal string `json:"principal,omitempty"`
+	// Impersonator is the admin acting as the principal, e.g.
+	// "admin:support"
+	Impersonator string `json:"impersonator,omitempty"`
+	// User is the user or organization whose jobs or data were affected
+	User     string `json:"user,omitempty"`
+	SourceIP string `json:"source_ip,omitempty"`
+	Action   string `json:"action"`
+	Target   string `json:"target,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	// Before and After are hashes of the affected state, empty if it did
+	// not exist
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	// PrevHash chains the event to the one before, Hash covers the event
+	// including PrevHash
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// Filter selects events in a query. Empty fields match everything.
+type Filter struct {
+	User         string
+	Principal    string
+	Impersonator string
+	// Action matches the action or, ending with ".", actions starting with it
+	Action string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// Log is an append-only audit log file. Each event carries the hash of the
+// one before, so changing or removing events breaks the chain.
+type Log struct {
+	mutex    sync.Mutex
+	path     string
+	file     *os.File
+	seq      int64
+	lastHash string
+}
+
+// Open opens or creates the audit log at the given path and continues its
+// hash chain
+func Open(path string) (*Log, error) {
+	l := &Log{path: path}
+
+	err := l.scan(func(event *Event, line int) error {
+		if event != nil {
+			l.seq = event.Seq
+			l.lastHash = event.Hash
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// The log only records what happened, so only the server may read it
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	l.file = file
+
+	return l, nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.file.Close()
+}
+
+// Record appends an event, filling in its sequence number, time and hashes
+func (l *Log) Record(event Event) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.seq++
+	event.Seq = l.seq
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	event.PrevHash = l.lastHash
+	event.Hash = ""
+
+	hash, err := eventHash(event)
+	if err != nil {
+		return err
+	}
+	event.Hash = hash
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit event: %w", err)
+	}
+
+	// One write per line, so events are never interleaved
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit event: %w", err)
+	}
+	l.lastHash = hash
+
+	return nil
+}
+
+// Query returns the most recent events matching the filter, newest first
+func (l *Log) Query(filter Filter) ([]Event, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	var events []Event
+	err := l.scan(func(event *Event, line int) error {
+		if event == nil || !filter.matches(event) {
+			return nil
+		}
+		events = append(events, *event)
+		if len(events) > limit {
+			events = events[1:]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Newest first
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	if events == nil {
+		events = []Event{}
+	}
+
+	return events, nil
+}
+
+// Verify checks the hash chain of the whole log and returns the number of
+// events. The error names the first line that was changed, removed or
+// inserted.
+func (l *Log) Verify() (int64, error) {
+	// Hold the lock so no event is half written while reading
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var count int64
+	prevHash := ""
+
+	err := l.scan(func(event *Event, line int) error {
+		if event == nil {
+			return fmt.Errorf("line %d: malformed event", line)
+		}
+		if event.PrevHash != prevHash {
+			return fmt.Errorf("line %d: event %d does not follow the event before", line, event.Seq)
+		}
+
+		stored := event.Hash
+		event.Hash = ""
+		hash, err := eventHash(*event)
+		if err != nil {
+			return err
+		}
+		if hash != stored {
+			return fmt.Errorf("line %d: event %d was modified", line, event.Seq)
+		}
+
+		prevHash = stored
+		count++
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return count, err
+	}
+
+	// Events cut off at the end of the file leave the chain intact, but the
+	// server still knows the last hash it wrote
+	if prevHash != l.lastHash {
+		return count, fmt.Errorf("the log ends before event %d", l.seq)
+	}
+
+	return count, nil
+}
+
+// scan calls fn for each line of the log file, with nil for lines that are
+// not valid events
+func (l *Log) scan(fn func(event *Event, line int) error) error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal([]byte(text), &event); err != nil {
+			if err := fn(nil, line); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(&event, line); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// matches reports whether an event passes the filter
+func (f Filter) matches(event *Event) bool {
+	if f.User != "" && event.User != f.User {
+		return false
+	}
+	if f.Principal != "" && event.Principal != f.Principal {
+		return false
+	}
+	if f.Impersonator != "" && event.Impersonator != f.Impersonator {
+		return false
+	}
+	if f.Action != "" {
+		if strings.HasSuffix(f.Action, ".") {
+			if !strings.HasPrefix(event.Action, f.Action) {
+				return false
+			}
+		} else if event.Action != f.Action {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && event.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && event.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// eventHash computes the hash of an event with an empty Hash field
+func eventHash(event Event) (string, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal audit event: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Hash returns the hex SHA-256 of a state snapshot, or an empty string if
+// there is none
+func Hash(snapshot []byte) string {
+	if snapshot == nil {
+		return ""
+	}
+	sum := sha256.Sum256(snapshot)
+	return hex.EncodeToString(sum[:])
+}
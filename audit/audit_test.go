@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestLog(t *testing.T) (*Log, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l, path
+}
+
+func TestChain(t *testing.T) {
+	l, path := openTestLog(t)
+
+	for _, action := range []string{"cron.create", "data.update", "data.delete"} {
+		if err := l.Record(Event{Principal: "user:alice", User: "alice", Action: action}); err != nil {
+			t.Fatalf("Record() failed: %v", err)
+		}
+	}
+
+	if count, err := l.Verify(); err != nil || count != 3 {
+		t.Fatalf("Verify() = %d, %v, expected 3 events and no error", count, err)
+	}
+
+	// Reopening continues the chain
+	l.Close()
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer l.Close()
+	if err := l.Record(Event{Principal: "admin:root", Action: "admin.reload"}); err != nil {
+		t.Fatalf("Record() failed: %v", err)
+	}
+	if count, err := l.Verify(); err != nil || count != 4 {
+		t.Fatalf("Verify() after reopening = %d, %v, expected 4 events and no error", count, err)
+	}
+
+	events, err := l.Query(Filter{})
+	if err != nil {
+		t.Fatalf("Query() failed: %v", err)
+	}
+	if events[0].Seq != 4 || events[0].PrevHash != events[1].Hash {
+		t.Errorf("Query() returned events out of order or unchained")
+	}
+}
+
+func TestTamperDetection(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+	}{
+		{"modified", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], "data.update", "data.create", 1)
+			return lines
+		}},
+		{"removed", func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}},
+		{"inserted", func(lines []string) []string {
+			return append(lines[:2], append([]string{lines[0]}, lines[2:]...)...)
+		}},
+		{"truncated", func(lines []string) []string {
+			return lines[:2]
+		}},
+	}
+
+	for _, test := range tests {
+		l, path := openTestLog(t)
+		for _, action := range []string{"cron.create", "data.update", "data.delete"} {
+			if err := l.Record(Event{User: "alice", Action: action}); err != nil {
+				t.Fatalf("Record() failed: %v", err)
+			}
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile() failed: %v", err)
+		}
+		lines := test.tamper(strings.Split(strings.TrimSpace(string(data)), "\n"))
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+
+		if _, err := l.Verify(); err == nil {
+			t.Errorf("%s: Verify() did not detect the change", test.name)
+		}
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+	l, _ := openTestLog(t)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	events := []Event{
+		{Principal: "user:alice", User: "alice", Action: "cron.create"},
+		{Principal: "user:alice", User: "alice", Action: "data.update"},
+		{Principal: "user:bob", User: "@team", Action: "data.delete"},
+		{Principal: "key:0123456789abcdef", Action: ActionAuthFailure},
+		{Principal: "admin:root", User: "alice", Action: "admin.users.delete"},
+	}
+	for i, event := range events {
+		event.Time = start.Add(time.Duration(i) * time.Hour)
+		if err := l.Record(event); err != nil {
+			t.Fatalf("Record() failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected []int64
+	}{
+		{"everything", Filter{}, []int64{5, 4, 3, 2, 1}},
+		{"user", Filter{User: "alice"}, []int64{5, 2, 1}},
+		{"principal", Filter{Principal: "user:bob"}, []int64{3}},
+		{"action", Filter{Action: "data.update"}, []int64{2}},
+		{"action prefix", Filter{Action: "data."}, []int64{3, 2}},
+		{"since", Filter{Since: start.Add(3 * time.Hour)}, []int64{5, 4}},
+		{"until", Filter{Until: start.Add(time.Hour)}, []int64{2, 1}},
+		{"limit", Filter{User: "alice", Limit: 2}, []int64{5, 2}},
+	}
+
+	for _, test := range tests {
+		result, err := l.Query(test.filter)
+		if err != nil {
+			t.Fatalf("%s: Query() failed: %v", test.name, err)
+		}
+		var seqs []int64
+		for _, event := range result {
+			seqs = append(seqs, event.Seq)
+		}
+		if len(seqs) != len(test.expected) {
+			t.Errorf("%s: Query() returned events %v, expected %v", test.name, seqs, test.expected)
+			continue
+		}
+		for i := range seqs {
+			if seqs[i] != test.expected[i] {
+				t.Errorf("%s: Query() returned events %v, expected %v", test.name, seqs, test.expected)
+				break
+			}
+		}
+	}
+}
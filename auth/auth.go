@@ -1,11 +1,20 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
 	"data-cron-server/config"
 	"errors"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// KeylessPrefix is the path prefix of routes that never carry a key in the
+// path and must authenticate via headers
+const KeylessPrefix = "/v1/"
+
 // Common errors
 var (
 	ErrInvalidKey        = errors.New("invalid authentication key")
@@ -16,73 +25,305 @@ var (
 // Authenticator provides authentication functionality
 type Authenticator struct {
 	config        *config.Config
+	admins        *config.Admins
 	superAdminKey string
+	pathKeys      bool
+	signedOnly    bool
+	maxClockSkew  time.Duration
+	nonces        *nonceCache
+	shareSecret   []byte
+	// signingSecret encrypts the signing secrets of API keys
+	signingSecret []byte
+	limiter       *limiter
+	// trustedProxies may set X-Forwarded-For
+	trustedProxies []*net.IPNet
+	// superAdminCIDRs restricts where the super admin key may be used from
+	superAdminCIDRs []string
+	// certIdentity is the client certificate field naming the user, empty
+	// if certificates do not authenticate users
+	certIdentity string
+	// jwt validates bearer tokens, nil if tokens are not accepted
+	jwt *JWTVerifier
+	// onFailure is called for rejected requests
+	onFailure FailureFunc
+	// expiryWarning is how long before they expire keys are reported
+	expiryWarning time.Duration
 }
 
 // NewAuthenticator creates a new authenticator
 func NewAuthenticator(cfg *config.Config, superAdminKey string) *Authenticator {
 	return &Authenticator{
 		config:        cfg,
+		admins:        config.NewAdmins(),
 		superAdminKey: superAdminKey,
+		pathKeys:      true,
+		maxClockSkew:  DefaultMaxClockSkew,
+		nonces:        newNonceCache(),
+		shareSecret:   newShareSecret(),
+		signingSecret: newShareSecret(),
+		limiter:       newLimiter(),
+		expiryWarning: DefaultExpiryWarning,
 	}
 }
 
-// AuthenticateSuperAdmin authenticates a super admin request
+// SetSignatures configures signed requests. When required, user routes
+// only accept signed requests; skew is the allowed clock difference.
+func (a *Authenticator) SetSignatures(required bool, skew time.Duration) {
+	a.signedOnly = required
+	a.maxClockSkew = skew
+}
+
+// SignaturesRequired reports whether user routes only accept signed requests
+func (a *Authenticator) SignaturesRequired() bool {
+	return a.signedOnly
+}
+
+// SetAdmins sets the named admin accounts that may authenticate in addition
+// to the super admin key
+func (a *Authenticator) SetAdmins(admins *config.Admins) {
+	a.admins = admins
+}
+
+// SetPathKeys enables or disables the legacy mode where keys are read from
+// the URL path. Headers are accepted either way.
+func (a *Authenticator) SetPathKeys(enabled bool) {
+	a.pathKeys = enabled
+}
+
+// AuthenticateSuperAdmin authenticates a super admin request. The keys are
+// compared as hashes in constant time, so neither their content nor their
+// length leaks through timing.
 func (a *Authenticator) AuthenticateSuperAdmin(key string) error {
-	if key != a.superAdminKey {
+	given := sha256.Sum256([]byte(key))
+	expected := sha256.Sum256([]byte(a.superAdminKey))
+	if subtle.ConstantTimeCompare(given[:], expected[:]) != 1 {
 		return ErrInvalidSuperAdmin
 	}
 	return nil
 }
 
+// AuthenticateAdmin resolves the admin name and role for a key. The super
+// admin key authenticates the bootstrap owner.
+func (a *Authenticator) AuthenticateAdmin(key string) (string, string, error) {
+	name, role, _, err := a.authenticateAdmin(key)
+	return name, role, err
+}
+
+// authenticateAdmin resolves the admin name, role and address allowlist
+// for a key
+func (a *Authenticator) authenticateAdmin(key string) (string, string, []string, error) {
+	if err := a.AuthenticateSuperAdmin(key); err == nil {
+		return BootstrapAdmin, RoleOwner, a.superAdminCIDRs, nil
+	}
+
+	if a.jwt != nil && isJWT(key) {
+		name, role, err := a.authenticateJWTAdmin(key)
+		if err != nil {
+			return "", "", nil, ErrInvalidSuperAdmin
+		}
+		return name, role, nil, nil
+	}
+
+	keyID, ok := parseKeyID(key)
+	if !ok {
+		return "", "", nil, ErrInvalidSuperAdmin
+	}
+
+	name, admin, apiKey, found := a.admins.FindAdminKey(keyID)
+	if !found || !matchesHash(key, apiKey.Hash) {
+		return "", "", nil, ErrInvalidSuperAdmin
+	}
+	if err := checkKeyValidity(apiKey, time.Now()); err != nil {
+		return "", "", nil, err
+	}
+
+	return name, admin.Role, admin.AllowedCIDRs, nil
+}
+
+// AuthenticateKey resolves the user that owns the given API key
+func (a *Authenticator) AuthenticateKey(key string) (string, error) {
+	user, _, err := a.lookupKey(key)
+	return user, err
+}
+
+// lookupKey resolves the user and the stored record of an API key
+func (a *Authenticator) lookupKey(key string) (string, *config.APIKey, error) {
+	keyID, ok := parseKeyID(key)
+	if !ok {
+		return "", nil, ErrInvalidKey
+	}
+
+	user, apiKey, found := a.config.FindAPIKey(keyID)
+	if !found || !matchesHash(key, apiKey.Hash) {
+		return "", nil, ErrInvalidKey
+	}
+	if err := checkKeyValidity(apiKey, time.Now()); err != nil {
+		return "", nil, err
+	}
+
+	return user, apiKey, nil
+}
+
 // AuthenticateUser authenticates a user request
 func (a *Authenticator) AuthenticateUser(user, key string) error {
-	if userData := a.config.GetUser(user); userData == nil {
+	if !a.isUser(user) {
 		return ErrUserNotFound
 	}
-	
-	// In this simplified authentication model, the key is the user's ID
-	// In a real system, you would compare against a stored key
-	if user != key {
+
+	owner, err := a.AuthenticateKey(key)
+	if err != nil {
+		return err
+	}
+	if owner != user {
 		return ErrInvalidKey
 	}
-	
+
 	return nil
 }
 
-// RequireSuperAdmin is a middleware that requires super admin authentication
+// RequireSuperAdmin is a middleware that requires admin authentication,
+// either with the super admin key, the key of a named admin or a JWT with
+// a role claim. The admin and its role are stored in the request context.
 func (a *Authenticator) RequireSuperAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := getKeyFromPath(r.URL.Path, 1) // /admin/{super_key}/...
+		key := a.keyFromRequest(r) // /admin/{super_key}/... or header
+
+		// Refuse clients that failed too often
+		keyID := a.credentialKeyID(key)
+		ids := a.limiterIDs(r, keyID)
+		if a.checkLockout(w, r, ids, keyID) {
+			return
+		}
 		
-		if err := a.AuthenticateSuperAdmin(key); err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		name, role, cidrs, err := a.authenticateAdmin(key)
+		if err != nil {
+			a.limiter.fail(ids, time.Now())
+			a.reject(w, r, Failure{KeyID: keyID, Reason: err.Error(), Code: failureCode(err), Status: http.StatusUnauthorized})
+			return
+		}
+		a.limiter.succeed(ids[1:])
+
+		// The key is valid, but maybe not from this address
+		if !addressAllowed(a.ClientIP(r), cidrs) {
+			a.reject(w, r, Failure{Admin: name, KeyID: keyID, Reason: "address not allowed", Status: http.StatusForbidden})
 			return
 		}
 		
-		next.ServeHTTP(w, r)
+		ctx := ContextWithAdmin(r.Context(), name, role)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// RequireUser is a middleware that requires user authentication
+// RequireUser is a middleware that requires user authentication, either
+// with an API key, a request signed by one, a JWT or, if no key is given,
+// a verified client certificate. Admins may impersonate a user with the
+// X-Impersonate-User header.
 func (a *Authenticator) RequireUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := getKeyFromPath(r.URL.Path, 1) // /{endpoint}/{user_key}/...
-		
-		// The user ID is the same as the key in this implementation
-		user := key
-		
-		if err := a.AuthenticateUser(user, key); err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		var user string
+		var apiKey *config.APIKey
+		var err error
+
+		// Refuse clients that failed too often
+		key := a.keyFromRequest(r) // /{endpoint}/{user_key}/... or header
+		keyID := a.credentialKeyID(key)
+		if isSigned(r) {
+			keyID = r.Header.Get(HeaderKeyID)
+		}
+		ids := a.limiterIDs(r, keyID)
+		if a.checkLockout(w, r, ids, keyID) {
+			return
+		}
+
+		if r.Header.Get(HeaderImpersonate) != "" {
+			a.serveImpersonated(w, r, next, key, ids)
+			return
+		}
+
+		certUser, hasCert := a.certUser(r)
+		switch {
+		case isSigned(r):
+			user, apiKey, err = a.AuthenticateSignature(r)
+		case key == "" && hasCert:
+			// Certificates carry no scopes and grant full access
+			user, apiKey = certUser, &config.APIKey{}
+		case a.jwt != nil && isJWT(key):
+			// So do tokens
+			user, err = a.authenticateJWT(key)
+			apiKey = &config.APIKey{}
+		default:
+			// Resolve the user that owns the key
+			user, apiKey, err = a.lookupKey(key)
+		}
+		if err != nil {
+			a.limiter.fail(ids, time.Now())
+			a.reject(w, r, Failure{KeyID: keyID, Reason: err.Error(), Code: failureCode(err), Status: http.StatusUnauthorized})
+			return
+		}
+		a.limiter.succeed(ids[1:])
+
+		// The key is valid, but maybe not from this address
+		cidrs, _ := a.config.GetUserAllowedCIDRs(user)
+		if !addressAllowed(a.ClientIP(r), cidrs) {
+			a.reject(w, r, Failure{User: user, KeyID: keyID, Reason: "address not allowed", Status: http.StatusForbidden})
+			return
+		}
+
+		// Check the key scopes before the handler runs
+		resource, target, listing := requestTarget(r.URL.Path, r.Method)
+		if !scopesAllow(apiKey.Scopes, resource, r.Method, target, listing) {
+			a.reject(w, r, Failure{User: user, KeyID: keyID, Reason: "outside key scopes", Status: http.StatusForbidden})
 			return
 		}
 		
-		// Store the user in the request context
-		ctx := ContextWithUser(r.Context(), user)
+		// Act in an organization the user is a member of
+		namespace, status := a.resolveNamespace(r, user, resource)
+		if status != http.StatusOK {
+			a.reject(w, r, Failure{User: user, KeyID: keyID, Reason: "namespace not allowed", Status: status})
+			return
+		}
+
+		// Store the namespace, the user and the key scopes in the request
+		// context
+		ctx := ContextWithUser(r.Context(), namespace)
+		ctx = ContextWithActor(ctx, user)
+		ctx = ContextWithScopes(ctx, apiKey.Scopes)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// credentialKeyID returns the ID of the key a credential names. Tokens name
+// no key, their header is shared by every token of the issuer, so failed
+// tokens only count against the client IP.
+func (a *Authenticator) credentialKeyID(credential string) string {
+	if a.jwt != nil && isJWT(credential) {
+		return ""
+	}
+	keyID, _ := parseKeyID(credential)
+	return keyID
+}
+
+// keyFromRequest extracts the credential from the Authorization or X-API-Key
+// header, falling back to path segment 1 when legacy path keys are enabled
+func (a *Authenticator) keyFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	if a.pathKeys && !strings.HasPrefix(r.URL.Path, KeylessPrefix) {
+		return getKeyFromPath(r.URL.Path, 1)
+	}
+
+	return ""
+}
+
 // getKeyFromPath extracts a key from a URL path at the given index
 func getKeyFromPath(path string, index int) string {
 	// Skip leading slash
@@ -24,20 +24,53 @@ func TestAuthenticateSuperAdmin(t *testing.T) {
 	}
 }
 
+// issueTestKey issues an API key for the given user and returns the plaintext
+func issueTestKey(t *testing.T, cfg *config.Config, user string) string {
+	t.Helper()
+
+	plaintext, key, err := IssueAPIKey("test")
+	if err != nil {
+		t.Fatalf("IssueAPIKey() failed: %v", err)
+	}
+	if !cfg.AddUserAPIKey(user, key) {
+		t.Fatalf("AddUserAPIKey() failed for user %s", user)
+	}
+
+	return plaintext
+}
+
+func TestIssueAPIKey(t *testing.T) {
+	plaintext, key, err := IssueAPIKey("ci")
+	if err != nil {
+		t.Fatalf("IssueAPIKey() failed: %v", err)
+	}
+
+	if key.Label != "ci" {
+		t.Errorf("IssueAPIKey() label = %s, expected ci", key.Label)
+	}
+	if key.Hash == plaintext || key.Hash != HashAPIKey(plaintext) {
+		t.Error("IssueAPIKey() did not store the hash of the key")
+	}
+	if id, ok := parseKeyID(plaintext); !ok || id != key.ID {
+		t.Errorf("IssueAPIKey() key ID mismatch: got %s, expected %s", id, key.ID)
+	}
+}
+
 func TestAuthenticateUser(t *testing.T) {
 	cfg := config.NewConfig()
 	user := "testuser"
 	cfg.CreateUser(user)
+	key := issueTestKey(t, cfg, user)
 	
 	auth := NewAuthenticator(cfg, "super_admin_key")
 	
 	// Valid user and key
-	if err := auth.AuthenticateUser(user, user); err != nil {
+	if err := auth.AuthenticateUser(user, key); err != nil {
 		t.Errorf("AuthenticateUser() returned error for valid user and key: %v", err)
 	}
 	
 	// User not found
-	if err := auth.AuthenticateUser("nonexistent", "nonexistent"); err != ErrUserNotFound {
+	if err := auth.AuthenticateUser("nonexistent", key); err != ErrUserNotFound {
 		t.Errorf("AuthenticateUser() did not return expected error for nonexistent user: %v", err)
 	}
 	
@@ -45,6 +78,43 @@ func TestAuthenticateUser(t *testing.T) {
 	if err := auth.AuthenticateUser(user, "invalid_key"); err != ErrInvalidKey {
 		t.Errorf("AuthenticateUser() did not return expected error for invalid key: %v", err)
 	}
+
+	// The user name is no longer a valid key
+	if err := auth.AuthenticateUser(user, user); err != ErrInvalidKey {
+		t.Errorf("AuthenticateUser() accepted the user name as key: %v", err)
+	}
+
+	// Key of another user
+	cfg.CreateUser("otheruser")
+	otherKey := issueTestKey(t, cfg, "otheruser")
+	if err := auth.AuthenticateUser(user, otherKey); err != ErrInvalidKey {
+		t.Errorf("AuthenticateUser() accepted another user's key: %v", err)
+	}
+}
+
+func TestAuthenticateKeyRevoked(t *testing.T) {
+	cfg := config.NewConfig()
+	user := "testuser"
+	cfg.CreateUser(user)
+	key := issueTestKey(t, cfg, user)
+
+	auth := NewAuthenticator(cfg, "super_admin_key")
+
+	if owner, err := auth.AuthenticateKey(key); err != nil || owner != user {
+		t.Fatalf("AuthenticateKey() = %s, %v, expected %s", owner, err, user)
+	}
+
+	// Tampered secret with a valid key ID
+	keyID, _ := parseKeyID(key)
+	if _, err := auth.AuthenticateKey(keyID + keySeparator + "tampered"); err != ErrInvalidKey {
+		t.Errorf("AuthenticateKey() accepted a tampered key: %v", err)
+	}
+
+	// Revoked key
+	cfg.DeleteUserAPIKey(user, keyID)
+	if _, err := auth.AuthenticateKey(key); err != ErrInvalidKey {
+		t.Errorf("AuthenticateKey() accepted a revoked key: %v", err)
+	}
 }
 
 func TestRequireSuperAdmin(t *testing.T) {
@@ -82,6 +152,7 @@ func TestRequireUser(t *testing.T) {
 	cfg := config.NewConfig()
 	user := "testuser"
 	cfg.CreateUser(user)
+	key := issueTestKey(t, cfg, user)
 	
 	auth := NewAuthenticator(cfg, "super_admin_key")
 	
@@ -96,22 +167,22 @@ func TestRequireUser(t *testing.T) {
 	
 	middleware := auth.RequireUser(handler)
 	
-	// Valid user
-	req := httptest.NewRequest("GET", "/data/"+user+"/keys", nil)
+	// Valid key
+	req := httptest.NewRequest("GET", "/data/"+key+"/keys", nil)
 	rr := httptest.NewRecorder()
 	middleware.ServeHTTP(rr, req)
 	
 	if rr.Code != http.StatusOK {
-		t.Errorf("RequireUser() returned status %d for valid user, expected %d", rr.Code, http.StatusOK)
+		t.Errorf("RequireUser() returned status %d for valid key, expected %d", rr.Code, http.StatusOK)
 	}
 	
-	// Invalid user
-	req = httptest.NewRequest("GET", "/data/invalid/keys", nil)
+	// User name instead of a key
+	req = httptest.NewRequest("GET", "/data/"+user+"/keys", nil)
 	rr = httptest.NewRecorder()
 	middleware.ServeHTTP(rr, req)
 	
 	if rr.Code != http.StatusUnauthorized {
-		t.Errorf("RequireUser() returned status %d for invalid user, expected %d", rr.Code, http.StatusUnauthorized)
+		t.Errorf("RequireUser() returned status %d for user name as key, expected %d", rr.Code, http.StatusUnauthorized)
 	}
 }
 
@@ -141,3 +212,112 @@ func TestUserContext(t *testing.T) {
 		t.Errorf("UserFromContext() returned %s, expected empty string", ctxUser)
 	}
 }
+
+func TestRequireUserHeaders(t *testing.T) {
+	cfg := config.NewConfig()
+	user := "testuser"
+	cfg.CreateUser(user)
+	key := issueTestKey(t, cfg, user)
+
+	auth := NewAuthenticator(cfg, "super_admin_key")
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	middleware := auth.RequireUser(handler)
+
+	tests := []struct {
+		name     string
+		path     string
+		header   string
+		value    string
+		pathKeys bool
+		expected int
+	}{
+		{"bearer token", "/v1/data/keys", "Authorization", "Bearer " + key, true, http.StatusOK},
+		{"lowercase bearer scheme", "/v1/data/keys", "Authorization", "bearer " + key, true, http.StatusOK},
+		{"api key header", "/v1/data/keys", "X-API-Key", key, true, http.StatusOK},
+		{"basic scheme is ignored", "/v1/data/keys", "Authorization", "Basic " + key, true, http.StatusUnauthorized},
+		{"no credentials", "/v1/data/keys", "", "", true, http.StatusUnauthorized},
+		{"header on legacy route", "/data/ignored/keys", "X-API-Key", key, false, http.StatusOK},
+		{"path key enabled", "/data/" + key + "/keys", "", "", true, http.StatusOK},
+		{"path key disabled", "/data/" + key + "/keys", "", "", false, http.StatusUnauthorized},
+		{"keyless route ignores path", "/v1/" + key + "/keys", "", "", true, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth.SetPathKeys(tt.pathKeys)
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rr := httptest.NewRecorder()
+			middleware.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expected {
+				t.Errorf("RequireUser() returned status %d, expected %d", rr.Code, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAuthenticateAdmin(t *testing.T) {
+	cfg := config.NewConfig()
+	admins := config.NewAdmins()
+	auth := NewAuthenticator(cfg, "test_super_admin_key")
+	auth.SetAdmins(admins)
+
+	// The super admin key is the bootstrap owner
+	name, role, err := auth.AuthenticateAdmin("test_super_admin_key")
+	if err != nil || name != BootstrapAdmin || role != RoleOwner {
+		t.Errorf("AuthenticateAdmin() = %s, %s, %v for super admin key", name, role, err)
+	}
+
+	// Named admin
+	admins.CreateAdmin("oncall", RoleViewer)
+	plaintext, key, _ := IssueAPIKey("laptop")
+	admins.AddAdminKey("oncall", key)
+
+	name, role, err = auth.AuthenticateAdmin(plaintext)
+	if err != nil || name != "oncall" || role != RoleViewer {
+		t.Errorf("AuthenticateAdmin() = %s, %s, %v for named admin", name, role, err)
+	}
+
+	// User keys are not admin keys
+	cfg.CreateUser("testuser")
+	userKey := issueTestKey(t, cfg, "testuser")
+	if _, _, err := auth.AuthenticateAdmin(userKey); err != ErrInvalidSuperAdmin {
+		t.Errorf("AuthenticateAdmin() accepted a user key: %v", err)
+	}
+
+	// Deleted admin
+	admins.DeleteAdmin("oncall")
+	if _, _, err := auth.AuthenticateAdmin(plaintext); err != ErrInvalidSuperAdmin {
+		t.Errorf("AuthenticateAdmin() accepted the key of a deleted admin: %v", err)
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role     string
+		required string
+		expected bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleOperator, false},
+		{RoleOperator, RoleViewer, true},
+		{RoleOperator, RoleOwner, false},
+		{RoleOwner, RoleOperator, true},
+		{"", RoleViewer, false},
+		{"root", RoleViewer, false},
+	}
+
+	for _, tt := range tests {
+		if got := RoleAllows(tt.role, tt.required); got != tt.expected {
+			t.Errorf("RoleAllows(%q, %q) = %v, expected %v", tt.role, tt.required, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Client certificate fields that can identify a user
+const (
+	CertIdentityCN  = "cn"
+	CertIdentitySAN = "san"
+)
+
+// SetClientCertIdentity enables authentication with verified client
+// certificates. The user ID is taken from the subject common name (cn) or
+// the DNS, email and URI subject alternative names (san).
+func (a *Authenticator) SetClientCertIdentity(field string) error {
+	switch field {
+	case CertIdentityCN, CertIdentitySAN, "":
+		a.certIdentity = field
+		return nil
+	default:
+		return fmt.Errorf("expected %s or %s, got %q", CertIdentityCN, CertIdentitySAN, field)
+	}
+}
+
+// certUser returns the user a verified client certificate maps to
+func (a *Authenticator) certUser(r *http.Request) (string, bool) {
+	if a.certIdentity == "" || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+
+	var identities []string
+	switch a.certIdentity {
+	case CertIdentityCN:
+		identities = []string{cert.Subject.CommonName}
+	case CertIdentitySAN:
+		identities = append(identities, cert.DNSNames...)
+		identities = append(identities, cert.EmailAddresses...)
+		for _, uri := range cert.URIs {
+			identities = append(identities, uri.String())
+		}
+	}
+
+	// The first identity naming an existing user wins
+	for _, identity := range identities {
+		if identity != "" && a.isUser(identity) {
+			return identity, true
+		}
+	}
+
+	return "", false
+}
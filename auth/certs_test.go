@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"data-cron-server/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientCertAuthentication(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.CreateUser("backup-server")
+	cfg.CreateUser("otheruser")
+	otherKey := issueTestKey(t, cfg, "otheruser")
+
+	withCert := func(req *http.Request, cert *x509.Certificate) *http.Request {
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return req
+	}
+	cnCert := &x509.Certificate{Subject: pkix.Name{CommonName: "backup-server"}}
+	sanCert := &x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}, DNSNames: []string{"host.example", "backup-server"}}
+
+	serve := func(auth *Authenticator, req *http.Request) (int, string) {
+		var user string
+		middleware := auth.RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, _ = UserFromContext(r.Context())
+			w.WriteHeader(http.StatusOK)
+		}))
+		rr := httptest.NewRecorder()
+		middleware.ServeHTTP(rr, req)
+		return rr.Code, user
+	}
+
+	// Disabled by default
+	auth := NewAuthenticator(cfg, "super_admin_key")
+	if code, _ := serve(auth, withCert(httptest.NewRequest("GET", "/v1/data/keys", nil), cnCert)); code != http.StatusUnauthorized {
+		t.Errorf("RequireUser() returned status %d with certificates disabled, expected %d", code, http.StatusUnauthorized)
+	}
+
+	// Common name
+	if err := auth.SetClientCertIdentity(CertIdentityCN); err != nil {
+		t.Fatalf("SetClientCertIdentity() failed: %v", err)
+	}
+	if code, user := serve(auth, withCert(httptest.NewRequest("GET", "/v1/data/keys", nil), cnCert)); code != http.StatusOK || user != "backup-server" {
+		t.Errorf("RequireUser() = %d, %s for common name, expected %d, backup-server", code, user, http.StatusOK)
+	}
+	if code, _ := serve(auth, withCert(httptest.NewRequest("GET", "/v1/data/keys", nil), sanCert)); code != http.StatusUnauthorized {
+		t.Errorf("RequireUser() returned status %d for unknown common name, expected %d", code, http.StatusUnauthorized)
+	}
+
+	// Unverified certificates are ignored
+	req := httptest.NewRequest("GET", "/v1/data/keys", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cnCert}}
+	if code, _ := serve(auth, req); code != http.StatusUnauthorized {
+		t.Errorf("RequireUser() returned status %d for unverified certificate, expected %d", code, http.StatusUnauthorized)
+	}
+
+	// A key takes precedence over the certificate
+	req = withCert(httptest.NewRequest("GET", "/v1/data/keys", nil), cnCert)
+	req.Header.Set("X-API-Key", otherKey)
+	if code, user := serve(auth, req); code != http.StatusOK || user != "otheruser" {
+		t.Errorf("RequireUser() = %d, %s with key and certificate, expected %d, otheruser", code, user, http.StatusOK)
+	}
+
+	// Subject alternative names
+	if err := auth.SetClientCertIdentity(CertIdentitySAN); err != nil {
+		t.Fatalf("SetClientCertIdentity() failed: %v", err)
+	}
+	if code, user := serve(auth, withCert(httptest.NewRequest("GET", "/v1/data/keys", nil), sanCert)); code != http.StatusOK || user != "backup-server" {
+		t.Errorf("RequireUser() = %d, %s for SAN, expected %d, backup-server", code, user, http.StatusOK)
+	}
+
+	if err := auth.SetClientCertIdentity("serial"); err == nil {
+		t.Error("SetClientCertIdentity() accepted an unknown field")
+	}
+}
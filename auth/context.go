@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"data-cron-server/config"
 )
 
 // contextKey is a private type for context keys
@@ -10,8 +11,23 @@ type contextKey int
 const (
 	// userKey is the context key for the user
 	userKey contextKey = iota
+	// scopesKey is the context key for the scopes of the API key
+	scopesKey
+	// adminKey is the context key for the authenticated admin
+	adminKey
+	// actorKey is the context key for the authenticated user
+	actorKey
+	// impersonatorKey is the context key for the admin impersonating the
+	// user
+	impersonatorKey
 )
 
+// adminValue is the admin stored in the context
+type adminValue struct {
+	name string
+	role string
+}
+
 // ContextWithUser returns a new context with the user value
 func ContextWithUser(ctx context.Context, user string) context.Context {
 	return context.WithValue(ctx, userKey, user)
@@ -22,3 +38,51 @@ func UserFromContext(ctx context.Context) (string, bool) {
 	user, ok := ctx.Value(userKey).(string)
 	return user, ok
 }
+
+// ContextWithActor returns a new context with the authenticated user, which
+// differs from the user value when acting in an organization
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// ActorFromContext returns the authenticated user from the context
+func ActorFromContext(ctx context.Context) (string, bool) {
+	actor, ok := ctx.Value(actorKey).(string)
+	return actor, ok
+}
+
+// ContextWithImpersonator returns a new context with the admin that
+// impersonates the user
+func ContextWithImpersonator(ctx context.Context, admin string) context.Context {
+	return context.WithValue(ctx, impersonatorKey, admin)
+}
+
+// ImpersonatorFromContext returns the admin impersonating the user, if the
+// request is impersonated
+func ImpersonatorFromContext(ctx context.Context) (string, bool) {
+	admin, ok := ctx.Value(impersonatorKey).(string)
+	return admin, ok
+}
+
+// ContextWithScopes returns a new context with the scopes of the API key
+func ContextWithScopes(ctx context.Context, scopes []config.Scope) context.Context {
+	return context.WithValue(ctx, scopesKey, scopes)
+}
+
+// ScopesFromContext returns the scopes of the API key from the context.
+// No scopes means the key grants full access.
+func ScopesFromContext(ctx context.Context) []config.Scope {
+	scopes, _ := ctx.Value(scopesKey).([]config.Scope)
+	return scopes
+}
+
+// ContextWithAdmin returns a new context with the admin name and role
+func ContextWithAdmin(ctx context.Context, name, role string) context.Context {
+	return context.WithValue(ctx, adminKey, adminValue{name: name, role: role})
+}
+
+// AdminFromContext returns the admin name and role from the context
+func AdminFromContext(ctx context.Context) (string, string, bool) {
+	admin, ok := ctx.Value(adminKey).(adminValue)
+	return admin.name, admin.role, ok
+}
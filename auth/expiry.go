@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"bytes"
+	"data-cron-server/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Key validity errors
+var (
+	ErrKeyExpired     = errors.New("key expired")
+	ErrKeyNotYetValid = errors.New("key not yet valid")
+)
+
+// HeaderAuthError carries a machine readable reason why a credential was
+// rejected, so clients can tell an expired key from a wrong one
+const HeaderAuthError = "X-Auth-Error"
+
+// Values of the X-Auth-Error header
+const (
+	CodeKeyExpired     = "key_expired"
+	CodeKeyNotYetValid = "key_not_yet_valid"
+)
+
+// DefaultRotationGrace is how long a rotated key stays valid next to the
+// key replacing it
+const DefaultRotationGrace = 24 * time.Hour
+
+// DefaultExpiryWarning is how long before they expire keys are reported as
+// expiring
+const DefaultExpiryWarning = 14 * 24 * time.Hour
+
+// ExpiringKey describes a key that expires soon or has expired
+type ExpiringKey struct {
+	// Owner is "user:<user>" or "admin:<name>"
+	Owner      string    `json:"owner"`
+	KeyID      string    `json:"key_id"`
+	Label      string    `json:"label,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Expired    bool      `json:"expired"`
+	ReplacedBy string    `json:"replaced_by,omitempty"`
+	// Notified is set once the expiry webhook was called for the key
+	Notified bool `json:"notified"`
+}
+
+// checkKeyValidity checks the validity period of a key
+func checkKeyValidity(key *config.APIKey, now time.Time) error {
+	if key.NotBefore != nil && now.Before(*key.NotBefore) {
+		return ErrKeyNotYetValid
+	}
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return ErrKeyExpired
+	}
+	return nil
+}
+
+// failureCode returns the X-Auth-Error code for an authentication error
+func failureCode(err error) string {
+	switch err {
+	case ErrKeyExpired:
+		return CodeKeyExpired
+	case ErrKeyNotYetValid:
+		return CodeKeyNotYetValid
+	}
+	return ""
+}
+
+// ValidateKeyValidity checks the validity period requested for a new key
+func ValidateKeyValidity(notBefore, expiresAt *time.Time, now time.Time) error {
+	if expiresAt == nil {
+		return nil
+	}
+	if !expiresAt.After(now) {
+		return errors.New("expires_at must be in the future")
+	}
+	if notBefore != nil && !expiresAt.After(*notBefore) {
+		return errors.New("expires_at must be after not_before")
+	}
+	return nil
+}
+
+// SetKeyExpiryWarning sets how long before they expire keys are reported
+// as expiring
+func (a *Authenticator) SetKeyExpiryWarning(within time.Duration) {
+	a.expiryWarning = within
+}
+
+// KeyExpiryWarning returns how long before they expire keys are reported
+// as expiring
+func (a *Authenticator) KeyExpiryWarning() time.Duration {
+	return a.expiryWarning
+}
+
+// ExpiringKeys returns the user and admin keys that expire within the
+// given duration, including expired ones, soonest first
+func (a *Authenticator) ExpiringKeys(within time.Duration, now time.Time) []ExpiringKey {
+	expiring := make([]ExpiringKey, 0)
+	add := func(prefix string, keys []config.OwnedKey) {
+		for _, owned := range keys {
+			key := owned.Key
+			if key.ExpiresAt == nil || key.ExpiresAt.After(now.Add(within)) {
+				continue
+			}
+			expiring = append(expiring, ExpiringKey{
+				Owner:      prefix + owned.Owner,
+				KeyID:      key.ID,
+				Label:      key.Label,
+				ExpiresAt:  *key.ExpiresAt,
+				Expired:    !now.Before(*key.ExpiresAt),
+				ReplacedBy: key.ReplacedBy,
+				Notified:   key.ExpiryNotified,
+			})
+		}
+	}
+	add("user:", a.config.AllUserAPIKeys())
+	add("admin:", a.admins.AllAdminKeys())
+
+	sort.Slice(expiring, func(i, j int) bool {
+		return expiring[i].ExpiresAt.Before(expiring[j].ExpiresAt)
+	})
+
+	return expiring
+}
+
+// expiryNotification is the body of the key expiry webhook
+type expiryNotification struct {
+	Event string      `json:"event"`
+	Key   ExpiringKey `json:"key"`
+}
+
+// NotifyKeyExpiry posts a notification to the webhook for every key that
+// expires within the warning period and was neither rotated nor notified
+// before. Keys are only marked as notified when the webhook accepted the
+// notification, so failed ones are retried.
+func (a *Authenticator) NotifyKeyExpiry(client *http.Client, webhookURL string, now time.Time) error {
+	var errs []error
+	for _, key := range a.ExpiringKeys(a.expiryWarning, now) {
+		if key.Expired || key.ReplacedBy != "" || key.Notified {
+			continue
+		}
+
+		body, err := json.Marshal(expiryNotification{Event: "key.expiring", Key: key})
+		if err != nil {
+			return err
+		}
+		resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			errs = append(errs, fmt.Errorf("webhook returned status %d for key %s", resp.StatusCode, key.KeyID))
+			continue
+		}
+
+		a.markExpiryNotified(key)
+	}
+
+	return errors.Join(errs...)
+}
+
+// WatchKeyExpiry notifies the webhook of expiring keys at every interval
+// until stop is closed
+func (a *Authenticator) WatchKeyExpiry(webhookURL string, interval time.Duration, stop <-chan struct{}) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.NotifyKeyExpiry(client, webhookURL, time.Now()); err != nil {
+			log.Printf("Error sending key expiry notifications: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// markExpiryNotified remembers that the owner of the key was warned
+func (a *Authenticator) markExpiryNotified(key ExpiringKey) {
+	mark := func(k *config.APIKey) { k.ExpiryNotified = true }
+	if owner, ok := strings.CutPrefix(key.Owner, "admin:"); ok {
+		a.admins.UpdateAdminKey(owner, key.KeyID, mark)
+		return
+	}
+	owner, _ := strings.CutPrefix(key.Owner, "user:")
+	a.config.UpdateUserAPIKey(owner, key.KeyID, mark)
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"data-cron-server/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequireUserKeyValidity(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.CreateUser("testuser")
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	validity := func(notBefore, expiresAt *time.Time) string {
+		key := issueTestKey(t, cfg, "testuser")
+		_, record, _ := cfg.FindAPIKey(mustKeyID(t, key))
+		record.NotBefore, record.ExpiresAt = notBefore, expiresAt
+		return key
+	}
+
+	auth := NewAuthenticator(cfg, "super_admin_key")
+	middleware := auth.RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name     string
+		key      string
+		expected int
+		code     string
+	}{
+		{"no limits", validity(nil, nil), http.StatusOK, ""},
+		{"within period", validity(&past, &future), http.StatusOK, ""},
+		{"expired", validity(nil, &past), http.StatusUnauthorized, CodeKeyExpired},
+		{"not yet valid", validity(&future, nil), http.StatusUnauthorized, CodeKeyNotYetValid},
+		{"invalid", "0123456789abcdef.00", http.StatusUnauthorized, ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/v1/status", nil)
+		req.Header.Set("X-API-Key", test.key)
+		rr := httptest.NewRecorder()
+		middleware.ServeHTTP(rr, req)
+
+		if rr.Code != test.expected || rr.Header().Get(HeaderAuthError) != test.code {
+			t.Errorf("%s: returned status %d and code %q, expected %d and %q", test.name, rr.Code, rr.Header().Get(HeaderAuthError), test.expected, test.code)
+		}
+	}
+
+	// Admin keys expire as well
+	admins := config.NewAdmins()
+	admins.CreateAdmin("oncall", RoleViewer)
+	plaintext, key, _ := IssueAPIKey("laptop")
+	key.ExpiresAt = &past
+	admins.AddAdminKey("oncall", key)
+	auth.SetAdmins(admins)
+	if _, _, err := auth.AuthenticateAdmin(plaintext); err != ErrKeyExpired {
+		t.Errorf("AuthenticateAdmin() returned %v for an expired key, expected %v", err, ErrKeyExpired)
+	}
+}
+
+func TestNotifyKeyExpiry(t *testing.T) {
+	now := time.Now()
+	soon := now.Add(3 * 24 * time.Hour)
+	later := now.Add(60 * 24 * time.Hour)
+	past := now.Add(-time.Hour)
+
+	cfg := config.NewConfig()
+	cfg.CreateUser("alice")
+	cfg.AddUserAPIKey("alice", &config.APIKey{ID: "soon", ExpiresAt: &soon})
+	cfg.AddUserAPIKey("alice", &config.APIKey{ID: "later", ExpiresAt: &later})
+	cfg.AddUserAPIKey("alice", &config.APIKey{ID: "expired", ExpiresAt: &past})
+	cfg.AddUserAPIKey("alice", &config.APIKey{ID: "rotated", ExpiresAt: &soon, ReplacedBy: "soon"})
+	cfg.AddUserAPIKey("alice", &config.APIKey{ID: "forever"})
+	admins := config.NewAdmins()
+	admins.CreateAdmin("oncall", RoleViewer)
+	admins.AddAdminKey("oncall", &config.APIKey{ID: "admin-soon", ExpiresAt: &soon})
+
+	auth := NewAuthenticator(cfg, "super_admin_key")
+	auth.SetAdmins(admins)
+
+	expiring := auth.ExpiringKeys(DefaultExpiryWarning, now)
+	if len(expiring) != 4 || expiring[0].KeyID != "expired" || !expiring[0].Expired {
+		t.Fatalf("ExpiringKeys() = %+v, expected the expired key first and 4 keys", expiring)
+	}
+
+	var notified []string
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification expiryNotification
+		json.NewDecoder(r.Body).Decode(&notification)
+		notified = append(notified, notification.Key.Owner+"/"+notification.Key.KeyID)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	// Failed notifications are retried
+	if err := auth.NotifyKeyExpiry(server.Client(), server.URL, now); err == nil {
+		t.Error("NotifyKeyExpiry() returned no error when the webhook failed")
+	}
+	status = http.StatusNoContent
+	notified = nil
+	if err := auth.NotifyKeyExpiry(server.Client(), server.URL, now); err != nil {
+		t.Fatalf("NotifyKeyExpiry() failed: %v", err)
+	}
+	if len(notified) != 2 {
+		t.Errorf("NotifyKeyExpiry() notified %v, expected user:alice/soon and admin:oncall/admin-soon", notified)
+	}
+
+	// Every key is notified once
+	notified = nil
+	auth.NotifyKeyExpiry(server.Client(), server.URL, now)
+	if len(notified) != 0 {
+		t.Errorf("NotifyKeyExpiry() notified %v again", notified)
+	}
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Failure describes a rejected request
+type Failure struct {
+	// User or Admin is who the credential belongs to, if it was valid
+	User  string
+	Admin string
+	// KeyID is the key ID named by the credential, if any
+	KeyID  string
+	Reason string
+	// Code is sent in the X-Auth-Error header, if set
+	Code   string
+	Status int
+}
+
+// FailureFunc is called for every request the authenticator rejects
+type FailureFunc func(r *http.Request, failure Failure)
+
+// SetFailureHook sets a function that is called for every rejected request,
+// e.g. to record it in the audit log
+func (a *Authenticator) SetFailureHook(hook FailureFunc) {
+	a.onFailure = hook
+}
+
+// reject responds with the status of the failure and reports it to the
+// failure hook
+func (a *Authenticator) reject(w http.ResponseWriter, r *http.Request, failure Failure) {
+	if a.onFailure != nil {
+		a.onFailure(r, failure)
+	}
+	if failure.Code != "" {
+		w.Header().Set(HeaderAuthError, failure.Code)
+	}
+	http.Error(w, http.StatusText(failure.Status), failure.Status)
+}
+
+// RedactPath replaces the key segment of a legacy path, so the path can be
+// logged without leaking credentials
+func RedactPath(path string) string {
+	if strings.HasPrefix(path, KeylessPrefix) || strings.HasPrefix(path, "/share/") {
+		return path
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return path
+	}
+	parts[1] = "-"
+
+	return "/" + strings.Join(parts, "/")
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// HeaderImpersonate names the user an admin request to a user route acts
+// as
+const HeaderImpersonate = "X-Impersonate-User"
+
+// impersonationRole returns the admin role needed to impersonate a user for
+// a request. Reads only need a viewer, like the admin endpoints; toggles
+// need an operator and every other write an owner.
+func impersonationRole(resource, method string) string {
+	switch {
+	case resource == ResourceCronToggle:
+		return RoleOperator
+	case method == http.MethodGet || method == http.MethodHead:
+		return RoleViewer
+	default:
+		return RoleOwner
+	}
+}
+
+// serveImpersonated authenticates an admin credential and serves the
+// request as the user named by the X-Impersonate-User header, with the
+// full access of a key without scopes. The admin is stored in the request
+// context as the impersonator.
+func (a *Authenticator) serveImpersonated(w http.ResponseWriter, r *http.Request, next http.Handler, key string, ids []string) {
+	keyID := a.credentialKeyID(key)
+
+	name, role, cidrs, err := a.authenticateAdmin(key)
+	if err != nil {
+		a.limiter.fail(ids, time.Now())
+		a.reject(w, r, Failure{KeyID: keyID, Reason: err.Error(), Code: failureCode(err), Status: http.StatusUnauthorized})
+		return
+	}
+	a.limiter.succeed(ids[1:])
+
+	// The key is valid, but maybe not from this address
+	if !addressAllowed(a.ClientIP(r), cidrs) {
+		a.reject(w, r, Failure{Admin: name, KeyID: keyID, Reason: "address not allowed", Status: http.StatusForbidden})
+		return
+	}
+
+	user := r.Header.Get(HeaderImpersonate)
+	if !a.isUser(user) {
+		a.reject(w, r, Failure{Admin: name, KeyID: keyID, Reason: "impersonated user not found", Status: http.StatusNotFound})
+		return
+	}
+
+	resource, _, _ := requestTarget(r.URL.Path, r.Method)
+	if !RoleAllows(role, impersonationRole(resource, r.Method)) {
+		a.reject(w, r, Failure{User: user, Admin: name, KeyID: keyID, Reason: "role does not allow impersonation", Status: http.StatusForbidden})
+		return
+	}
+
+	// The impersonated user's organizations and roles apply
+	namespace, status := a.resolveNamespace(r, user, resource)
+	if status != http.StatusOK {
+		a.reject(w, r, Failure{User: user, Admin: name, KeyID: keyID, Reason: "namespace not allowed", Status: status})
+		return
+	}
+
+	log.Printf("Admin %s impersonating %s: %s %s", name, user, r.Method, RedactPath(r.URL.Path))
+
+	ctx := ContextWithUser(r.Context(), namespace)
+	ctx = ContextWithActor(ctx, user)
+	ctx = ContextWithImpersonator(ctx, name)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"data-cron-server/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireUserImpersonation(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.CreateUser("alice")
+	cfg.CreateUser("bob")
+	cfg.CreateOrg("team")
+	cfg.SetOrgMember("team", "alice", OrgRoleViewer)
+	userKey := issueTestKey(t, cfg, "bob")
+
+	admins := config.NewAdmins()
+	adminKeys := map[string]string{"super": "super_admin_key"}
+	for _, role := range []string{RoleViewer, RoleOperator} {
+		admins.CreateAdmin(role, role)
+		plaintext, key, _ := IssueAPIKey("test")
+		admins.AddAdminKey(role, key)
+		adminKeys[role] = plaintext
+	}
+
+	auth := NewAuthenticator(cfg, "super_admin_key")
+	auth.SetAdmins(admins)
+
+	var ctxUser, ctxActor, ctxImpersonator string
+	middleware := auth.RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxUser, _ = UserFromContext(r.Context())
+		ctxActor, _ = ActorFromContext(r.Context())
+		ctxImpersonator, _ = ImpersonatorFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name      string
+		key       string
+		user      string
+		namespace string
+		method    string
+		path      string
+		expected  int
+	}{
+		{"viewer reads", adminKeys[RoleViewer], "alice", "", "GET", "/v1/data/keys", http.StatusOK},
+		{"viewer toggles", adminKeys[RoleViewer], "alice", "", "GET", "/v1/cron/j1/on", http.StatusForbidden},
+		{"operator toggles", adminKeys[RoleOperator], "alice", "", "GET", "/v1/cron/j1/on", http.StatusOK},
+		{"operator writes", adminKeys[RoleOperator], "alice", "", "PUT", "/v1/data/settings", http.StatusForbidden},
+		{"owner writes", adminKeys["super"], "alice", "", "PUT", "/v1/data/settings", http.StatusOK},
+		{"organization of the user", adminKeys["super"], "alice", "team", "GET", "/v1/data/keys", http.StatusOK},
+		{"role in the organization", adminKeys["super"], "alice", "team", "PUT", "/v1/data/settings", http.StatusForbidden},
+		{"organization itself", adminKeys["super"], "@team", "", "GET", "/v1/data/keys", http.StatusNotFound},
+		{"unknown user", adminKeys["super"], "mallory", "", "GET", "/v1/data/keys", http.StatusNotFound},
+		{"user key", userKey, "alice", "", "GET", "/v1/data/keys", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		ctxUser, ctxActor, ctxImpersonator = "", "", ""
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.Header.Set("X-API-Key", test.key)
+		req.Header.Set(HeaderImpersonate, test.user)
+		if test.namespace != "" {
+			req.Header.Set(HeaderNamespace, test.namespace)
+		}
+		rr := httptest.NewRecorder()
+		middleware.ServeHTTP(rr, req)
+
+		if rr.Code != test.expected {
+			t.Errorf("%s: returned status %d, expected %d", test.name, rr.Code, test.expected)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		expectedUser := test.user
+		if test.namespace != "" {
+			expectedUser = config.OrgNamespace(test.namespace)
+		}
+		if ctxUser != expectedUser || ctxActor != test.user || ctxImpersonator == "" {
+			t.Errorf("%s: set user %s, actor %s and impersonator %q", test.name, ctxUser, ctxActor, ctxImpersonator)
+		}
+	}
+
+	// Requests without the header are not impersonated
+	req := httptest.NewRequest("GET", "/v1/data/keys", nil)
+	req.Header.Set("X-API-Key", userKey)
+	ctxImpersonator = ""
+	middleware.ServeHTTP(httptest.NewRecorder(), req)
+	if ctxUser != "bob" || ctxImpersonator != "" {
+		t.Errorf("request without impersonation set user %s and impersonator %q", ctxUser, ctxImpersonator)
+	}
+
+	// Impersonation needs no signature when signatures are required
+	auth.SetSignatures(true, DefaultMaxClockSkew)
+	req = httptest.NewRequest("GET", "/v1/data/keys", nil)
+	req.Header.Set("X-API-Key", adminKeys["super"])
+	req.Header.Set(HeaderImpersonate, "alice")
+	rr := httptest.NewRecorder()
+	auth.RequireSignature(middleware).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("RequireSignature() rejected impersonation with status %d", rr.Code)
+	}
+}
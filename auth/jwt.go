@@ -0,0 +1,433 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// JWT errors
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// DefaultJWTLeeway is the allowed clock difference when checking the time
+// claims of a token
+const DefaultJWTLeeway = time.Minute
+
+// JWTVerifier validates JSON Web Tokens signed with a set of public keys
+// and maps their claims to users and admin roles
+type JWTVerifier struct {
+	// Keys by key ID; keys without ID are stored under ""
+	keys map[string][]crypto.PublicKey
+	// Issuer and Audience are checked if not empty
+	Issuer   string
+	Audience string
+	// UserClaim names the user, RoleClaim the admin role if not empty
+	UserClaim string
+	RoleClaim string
+	Leeway    time.Duration
+}
+
+// NewJWTVerifier creates a verifier without keys that takes the user from
+// the sub claim
+func NewJWTVerifier() *JWTVerifier {
+	return &JWTVerifier{
+		keys:      make(map[string][]crypto.PublicKey),
+		UserClaim: "sub",
+		Leeway:    DefaultJWTLeeway,
+	}
+}
+
+// AddKey adds a public key under a key ID, which may be empty
+func (v *JWTVerifier) AddKey(kid string, key crypto.PublicKey) {
+	v.keys[kid] = append(v.keys[kid], key)
+}
+
+// KeyCount returns the number of public keys
+func (v *JWTVerifier) KeyCount() int {
+	count := 0
+	for _, keys := range v.keys {
+		count += len(keys)
+	}
+	return count
+}
+
+// LoadJWKS adds the signing keys of a JSON Web Key Set file
+func (v *JWTVerifier) LoadJWKS(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("failed to parse JWKS %s: %w", path, err)
+	}
+
+	for i, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		var key crypto.PublicKey
+		switch jwk.Kty {
+		case "RSA":
+			n, errN := decodeBigInt(jwk.N)
+			e, errE := decodeBigInt(jwk.E)
+			if errN != nil || errE != nil || !e.IsInt64() {
+				return fmt.Errorf("invalid RSA key %d in JWKS %s", i, path)
+			}
+			key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			curve := curveByName(jwk.Crv)
+			x, errX := decodeBigInt(jwk.X)
+			y, errY := decodeBigInt(jwk.Y)
+			if curve == nil || errX != nil || errY != nil || !curve.IsOnCurve(x, y) {
+				return fmt.Errorf("invalid EC key %d in JWKS %s", i, path)
+			}
+			key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		case "OKP":
+			x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+			if jwk.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+				return fmt.Errorf("invalid OKP key %d in JWKS %s", i, path)
+			}
+			key = ed25519.PublicKey(x)
+		default:
+			// Unknown key types may be used for other purposes
+			continue
+		}
+
+		v.AddKey(jwk.Kid, key)
+	}
+
+	return nil
+}
+
+// LoadPublicKeyPEM adds the public keys or certificates of a PEM file. They
+// have no key ID and are tried for every token.
+func (v *JWTVerifier) LoadPublicKeyPEM(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key crypto.PublicKey
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse %s in %s: %w", strings.ToLower(block.Type), path, err)
+		}
+
+		v.AddKey("", key)
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("no public keys found in %s", path)
+	}
+	return nil
+}
+
+// Verify checks the signature and the exp, nbf, iss and aud claims of a
+// compact serialized token and returns its claims
+func (v *JWTVerifier) Verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// Tokens naming a known key must be signed with it, others with one of
+	// the keys without ID
+	candidates := v.keys[header.Kid]
+	if len(candidates) == 0 {
+		candidates = v.keys[""]
+	}
+	verified := false
+	for _, key := range candidates {
+		if verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrInvalidToken
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// Time claims, exp is required
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	if now.After(time.Unix(exp, 0).Add(v.Leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(v.Leeway).Before(time.Unix(nbf, 0)) {
+		return nil, ErrInvalidToken
+	}
+
+	if v.Issuer != "" && claims["iss"] != v.Issuer {
+		return nil, ErrInvalidToken
+	}
+	if v.Audience != "" && !hasAudience(claims["aud"], v.Audience) {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// User returns the user named by the user claim
+func (v *JWTVerifier) User(claims map[string]interface{}) (string, bool) {
+	user, ok := claims[v.UserClaim].(string)
+	return user, ok && user != ""
+}
+
+// Role returns the highest admin role in the role claim, which may be a
+// string or a list of strings
+func (v *JWTVerifier) Role(claims map[string]interface{}) (string, bool) {
+	if v.RoleClaim == "" {
+		return "", false
+	}
+
+	var values []string
+	switch claim := claims[v.RoleClaim].(type) {
+	case string:
+		values = []string{claim}
+	case []interface{}:
+		for _, value := range claim {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	role := ""
+	for _, value := range values {
+		if ValidRole(value) && (role == "" || RoleAllows(value, role)) {
+			role = value
+		}
+	}
+	return role, role != ""
+}
+
+// SetJWTVerifier enables authentication with JSON Web Tokens sent as bearer
+// tokens
+func (a *Authenticator) SetJWTVerifier(verifier *JWTVerifier) {
+	a.jwt = verifier
+}
+
+// authenticateJWT resolves the user a token was issued for
+func (a *Authenticator) authenticateJWT(token string) (string, error) {
+	claims, err := a.jwt.Verify(token, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	user, ok := a.jwt.User(claims)
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	if !a.isUser(user) {
+		return "", ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+// authenticateJWTAdmin resolves the admin name and role of a token. The
+// name is the user claim prefixed with "jwt:".
+func (a *Authenticator) authenticateJWTAdmin(token string) (string, string, error) {
+	claims, err := a.jwt.Verify(token, time.Now())
+	if err != nil {
+		return "", "", err
+	}
+
+	user, ok := a.jwt.User(claims)
+	if !ok {
+		return "", "", ErrInvalidToken
+	}
+	role, ok := a.jwt.Role(claims)
+	if !ok {
+		return "", "", ErrInvalidSuperAdmin
+	}
+
+	return "jwt:" + user, role, nil
+}
+
+// isJWT reports whether a credential looks like a compact serialized JWT
+// rather than an API key
+func isJWT(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+// verifyJWTSignature verifies a signature made with the algorithm, which
+// must match the type of the key
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) bool {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+		edKey, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(edKey, []byte(signed), signature)
+	default:
+		// Including "none" and HMAC algorithms
+		return false
+	}
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") {
+			return rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+		}
+		if strings.HasPrefix(alg, "PS") {
+			return rsa.VerifyPSS(key, hash, digest, signature, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || curveByAlg(alg) != key.Curve || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	}
+
+	return false
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, ErrInvalidToken
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// numericClaim returns a time claim in seconds
+func numericClaim(claims map[string]interface{}, name string) (int64, bool) {
+	value, ok := claims[name].(float64)
+	return int64(value), ok
+}
+
+// hasAudience reports whether the aud claim, a string or a list of
+// strings, contains the audience
+func hasAudience(claim interface{}, audience string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, value := range aud {
+			if value == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// curveByName returns the elliptic curve of a JWK crv value
+func curveByName(name string) elliptic.Curve {
+	switch name {
+	case "P-256":
+		return elliptic.P256()
+	case "P-384":
+		return elliptic.P384()
+	case "P-521":
+		return elliptic.P521()
+	}
+	return nil
+}
+
+// curveByAlg returns the elliptic curve an ES algorithm requires
+func curveByAlg(alg string) elliptic.Curve {
+	switch alg {
+	case "ES256":
+		return elliptic.P256()
+	case "ES384":
+		return elliptic.P384()
+	case "ES512":
+		return elliptic.P521()
+	}
+	return nil
+}
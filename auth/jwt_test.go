@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"data-cron-server/config"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signTestJWT creates a token with the given header and claims
+func signTestJWT(t *testing.T, key crypto.Signer, header, claims map[string]interface{}) string {
+	t.Helper()
+
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to marshal token segment: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+
+	var signature []byte
+	var err error
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	dir := t.TempDir()
+
+	// JWKS with the RSA and EC keys
+	jwks := map[string]interface{}{"keys": []map[string]string{
+		{
+			"kty": "RSA", "kid": "rsa-1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+		{
+			"kty": "EC", "kid": "ec-1", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+		},
+	}}
+	data, _ := json.Marshal(jwks)
+	jwksPath := filepath.Join(dir, "jwks.json")
+	os.WriteFile(jwksPath, data, 0644)
+
+	// PEM with the Ed25519 key
+	der, _ := x509.MarshalPKIXPublicKey(edKey.Public())
+	pemPath := filepath.Join(dir, "ed25519.pem")
+	os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+
+	verifier := NewJWTVerifier()
+	verifier.Issuer = "https://issuer.example"
+	verifier.Audience = "time-keypair"
+	if err := verifier.LoadJWKS(jwksPath); err != nil {
+		t.Fatalf("LoadJWKS() failed: %v", err)
+	}
+	if err := verifier.LoadPublicKeyPEM(pemPath); err != nil {
+		t.Fatalf("LoadPublicKeyPEM() failed: %v", err)
+	}
+	if verifier.KeyCount() != 3 {
+		t.Errorf("KeyCount() = %d, expected 3", verifier.KeyCount())
+	}
+
+	now := time.Now()
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "testuser",
+			"iss": "https://issuer.example",
+			"aud": []string{"other", "time-keypair"},
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		expected error
+	}{
+		{"RS256", signTestJWT(t, rsaKey, map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, claims(nil)), nil},
+		{"ES256", signTestJWT(t, ecKey, map[string]interface{}{"alg": "ES256", "kid": "ec-1"}, claims(nil)), nil},
+		{"EdDSA without kid", signTestJWT(t, edKey, map[string]interface{}{"alg": "EdDSA"}, claims(nil)), nil},
+		{"unknown key", signTestJWT(t, otherKey, map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, claims(nil)), ErrInvalidToken},
+		{"wrong kid", signTestJWT(t, rsaKey, map[string]interface{}{"alg": "RS256", "kid": "ec-1"}, claims(nil)), ErrInvalidToken},
+		{"alg mismatch", signTestJWT(t, rsaKey, map[string]interface{}{"alg": "ES256", "kid": "rsa-1"}, claims(nil)), ErrInvalidToken},
+		{"alg none", signTestJWT(t, rsaKey, map[string]interface{}{"alg": "none", "kid": "rsa-1"}, claims(nil)), ErrInvalidToken},
+		{"expired", signTestJWT(t, rsaKey, map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, claims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()})), ErrTokenExpired},
+		{"within leeway", signTestJWT(t, rsaKey, map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, claims(map[string]interface{}{"exp": now.Add(-time.Second).Unix()})), nil},
+		{"no exp", signTestJWT(t, rsaKey, map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, claims(map[string]interface{}{"exp": nil})), ErrInvalidToken},
+		{"not yet valid", signTestJWT(t, rsaKey, map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, claims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()})), ErrInvalidToken},
+		{"wrong issuer", signTestJWT(t, rsaKey, map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, claims(map[string]interface{}{"iss": "https://evil.example"})), ErrInvalidToken},
+		{"wrong audience", signTestJWT(t, rsaKey, map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, claims(map[string]interface{}{"aud": "other"})), ErrInvalidToken},
+		{"malformed", "a.b.c", ErrInvalidToken},
+	}
+
+	for _, test := range tests {
+		if _, err := verifier.Verify(test.token, now); err != test.expected {
+			t.Errorf("%s: Verify() returned %v, expected %v", test.name, err, test.expected)
+		}
+	}
+
+	// Roles, the highest known one wins
+	verifier.RoleClaim = "roles"
+	roleTests := []struct {
+		claim    interface{}
+		expected string
+	}{
+		{"operator", RoleOperator},
+		{[]interface{}{"viewer", "owner", "unknown"}, RoleOwner},
+		{"unknown", ""},
+		{nil, ""},
+	}
+	for _, test := range roleTests {
+		if role, _ := verifier.Role(map[string]interface{}{"roles": test.claim}); role != test.expected {
+			t.Errorf("Role(%v) = %q, expected %q", test.claim, role, test.expected)
+		}
+	}
+}
+
+func TestRequireJWT(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.CreateUser("testuser")
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	verifier := NewJWTVerifier()
+	verifier.AddKey("", &key.PublicKey)
+	verifier.UserClaim = "user"
+	verifier.RoleClaim = "role"
+
+	auth := NewAuthenticator(cfg, "super_admin_key")
+	auth.SetJWTVerifier(verifier)
+
+	header := map[string]interface{}{"alg": "ES256"}
+	exp := time.Now().Add(time.Hour).Unix()
+	userToken := signTestJWT(t, key, header, map[string]interface{}{"user": "testuser", "exp": exp})
+	adminToken := signTestJWT(t, key, header, map[string]interface{}{"user": "alice", "role": "viewer", "exp": exp})
+	unknownToken := signTestJWT(t, key, header, map[string]interface{}{"user": "nobody", "exp": exp})
+
+	var ctxUser, ctxAdmin, ctxRole string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxUser, _ = UserFromContext(r.Context())
+		ctxAdmin, ctxRole, _ = AdminFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serveFrom := func(middleware http.Handler, token, remoteAddr string) int {
+		req := httptest.NewRequest("GET", "/v1/data/keys", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		middleware.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	serve := func(middleware http.Handler, token string) int {
+		return serveFrom(middleware, token, "192.0.2.1:1234")
+	}
+
+	if code := serve(auth.RequireUser(handler), userToken); code != http.StatusOK || ctxUser != "testuser" {
+		t.Errorf("RequireUser() = %d, %s for user token, expected %d, testuser", code, ctxUser, http.StatusOK)
+	}
+	if code := serve(auth.RequireUser(handler), unknownToken); code != http.StatusUnauthorized {
+		t.Errorf("RequireUser() returned status %d for unknown user, expected %d", code, http.StatusUnauthorized)
+	}
+
+	if code := serve(auth.RequireSuperAdmin(handler), adminToken); code != http.StatusOK || ctxAdmin != "jwt:alice" || ctxRole != RoleViewer {
+		t.Errorf("RequireSuperAdmin() = %d, %s, %s for admin token, expected %d, jwt:alice, viewer", code, ctxAdmin, ctxRole, http.StatusOK)
+	}
+	if code := serve(auth.RequireSuperAdmin(handler), userToken); code != http.StatusUnauthorized {
+		t.Errorf("RequireSuperAdmin() returned status %d for token without role, expected %d", code, http.StatusUnauthorized)
+	}
+
+	// Failed tokens share their header with valid ones, so they only lock
+	// out the client that sent them
+	expiredToken := signTestJWT(t, key, header, map[string]interface{}{"user": "testuser", "exp": time.Now().Add(-time.Hour).Unix()})
+	for i := 0; i <= DefaultMaxFailures; i++ {
+		serveFrom(auth.RequireUser(handler), expiredToken, "198.51.100.7:1234")
+		serveFrom(auth.RequireSuperAdmin(handler), expiredToken, "198.51.100.7:1234")
+	}
+	if code := serveFrom(auth.RequireUser(handler), userToken, "198.51.100.7:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("RequireUser() returned status %d for the failing client, expected %d", code, http.StatusTooManyRequests)
+	}
+	if code := serveFrom(auth.RequireUser(handler), userToken, "192.0.2.50:1234"); code != http.StatusOK {
+		t.Errorf("RequireUser() returned status %d for another client, expected %d", code, http.StatusOK)
+	}
+	if code := serveFrom(auth.RequireSuperAdmin(handler), adminToken, "192.0.2.50:1234"); code != http.StatusOK {
+		t.Errorf("RequireSuperAdmin() returned status %d for another client, expected %d", code, http.StatusOK)
+	}
+}
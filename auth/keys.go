@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"data-cron-server/config"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// keySeparator separates the key ID from the secret part of an API key
+const keySeparator = "."
+
+// IssueAPIKey generates a new API key. It returns the plaintext key, which
+// must be handed to the client, and the record to store in the config,
+// which only contains the hash of the key.
+func IssueAPIKey(label string) (string, *config.APIKey, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	plaintext := id + keySeparator + secret
+
+	return plaintext, &config.APIKey{
+		ID:        id,
+		Label:     label,
+		Hash:      HashAPIKey(plaintext),
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// HashAPIKey returns the hash under which an API key is stored
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseKeyID extracts the key ID from a plaintext API key
+func parseKeyID(key string) (string, bool) {
+	id, secret, found := strings.Cut(key, keySeparator)
+	if !found || id == "" || secret == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// matchesHash compares a plaintext key against a stored hash in constant time
+func matchesHash(key, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(hash)) == 1
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
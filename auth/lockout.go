@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default lockout policy: after five failures a client is locked out for 30
+// seconds, doubling with every further failure up to 15 minutes
+const (
+	DefaultMaxFailures = 5
+	DefaultLockoutBase = 30 * time.Second
+	DefaultLockoutMax  = 15 * time.Minute
+)
+
+// failureWindow is how long failures are remembered without new ones
+const failureWindow = time.Hour
+
+// Lockout describes the failed attempts of a client IP or key
+type Lockout struct {
+	ID          string    `json:"id"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until,omitempty"`
+}
+
+// limiter counts failed authentication attempts per IP and per key and
+// locks them out with exponential backoff
+type limiter struct {
+	mutex       sync.Mutex
+	records     map[string]*Lockout
+	maxFailures int
+	base        time.Duration
+	max         time.Duration
+	lastPrune   time.Time
+}
+
+// newLimiter creates a limiter with the default policy
+func newLimiter() *limiter {
+	return &limiter{
+		records:     make(map[string]*Lockout),
+		maxFailures: DefaultMaxFailures,
+		base:        DefaultLockoutBase,
+		max:         DefaultLockoutMax,
+	}
+}
+
+// SetLockoutPolicy configures after how many failures clients are locked
+// out and the range of the exponential lockout duration
+func (a *Authenticator) SetLockoutPolicy(maxFailures int, base, max time.Duration) {
+	a.limiter.mutex.Lock()
+	defer a.limiter.mutex.Unlock()
+
+	a.limiter.maxFailures = maxFailures
+	a.limiter.base = base
+	a.limiter.max = max
+}
+
+// Lockouts returns all clients with recent failures, locked ones first
+func (a *Authenticator) Lockouts() []Lockout {
+	return a.limiter.list(time.Now())
+}
+
+// ClearLockout forgets the failures of one IP or key
+func (a *Authenticator) ClearLockout(id string) bool {
+	return a.limiter.clear(id)
+}
+
+// ClearAllLockouts forgets all failures
+func (a *Authenticator) ClearAllLockouts() {
+	a.limiter.clearAll()
+}
+
+// retryAfter returns how long the longest lockout of the IDs lasts
+func (l *limiter) retryAfter(ids []string, now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var wait time.Duration
+	for _, id := range ids {
+		if record, exists := l.records[id]; exists && record.LockedUntil.After(now) {
+			if remaining := record.LockedUntil.Sub(now); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+	return wait
+}
+
+// fail records a failed attempt for each of the IDs
+func (l *limiter) fail(ids []string, now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.prune(now)
+
+	for _, id := range ids {
+		record, exists := l.records[id]
+		if !exists || now.Sub(record.LastFailure) > failureWindow {
+			record = &Lockout{ID: id}
+			l.records[id] = record
+		}
+
+		record.Failures++
+		record.LastFailure = now
+
+		if record.Failures >= l.maxFailures {
+			lockout := l.base << uint(record.Failures-l.maxFailures)
+			if lockout <= 0 || lockout > l.max {
+				lockout = l.max
+			}
+			record.LockedUntil = now.Add(lockout)
+		}
+	}
+}
+
+// succeed forgets the failures of the IDs after a successful attempt
+func (l *limiter) succeed(ids []string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, id := range ids {
+		delete(l.records, id)
+	}
+}
+
+// list returns copies of all records, locked ones first
+func (l *limiter) list(now time.Time) []Lockout {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.prune(now)
+
+	lockouts := make([]Lockout, 0, len(l.records))
+	for _, record := range l.records {
+		lockouts = append(lockouts, *record)
+	}
+
+	sort.Slice(lockouts, func(i, j int) bool {
+		if !lockouts[i].LockedUntil.Equal(lockouts[j].LockedUntil) {
+			return lockouts[i].LockedUntil.After(lockouts[j].LockedUntil)
+		}
+		return lockouts[i].ID < lockouts[j].ID
+	})
+
+	return lockouts
+}
+
+// clear forgets the failures of one ID
+func (l *limiter) clear(id string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, exists := l.records[id]; exists {
+		delete(l.records, id)
+		return true
+	}
+	return false
+}
+
+// clearAll forgets all failures
+func (l *limiter) clearAll() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.records = make(map[string]*Lockout)
+}
+
+// prune drops records without recent failures, at most once a minute.
+// The caller must hold the mutex.
+func (l *limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+
+	for id, record := range l.records {
+		if now.Sub(record.LastFailure) > failureWindow && !record.LockedUntil.After(now) {
+			delete(l.records, id)
+		}
+	}
+	l.lastPrune = now
+}
+
+// limiterIDs returns the IDs failures of a request are counted under: the
+// client IP and, if the credential names one, the key ID
+func (a *Authenticator) limiterIDs(r *http.Request, keyID string) []string {
+	ids := []string{"ip:" + a.ClientIP(r)}
+	if keyID != "" {
+		ids = append(ids, "key:"+keyID)
+	}
+	return ids
+}
+
+// checkLockout responds with 429 and reports true if one of the IDs is
+// locked out
+func (a *Authenticator) checkLockout(w http.ResponseWriter, r *http.Request, ids []string, keyID string) bool {
+	wait := a.limiter.retryAfter(ids, time.Now())
+	if wait <= 0 {
+		return false
+	}
+
+	if a.onFailure != nil {
+		a.onFailure(r, Failure{KeyID: keyID, Reason: "locked out", Status: http.StatusTooManyRequests})
+	}
+
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too many failed attempts", http.StatusTooManyRequests)
+	return true
+}
+
+// secretLimiterIDs returns the IDs wrong data encryption secrets are
+// counted under: the client IP and the namespace
+func (a *Authenticator) secretLimiterIDs(r *http.Request, user string) []string {
+	return []string{"ip:" + a.ClientIP(r), "secret:" + user}
+}
+
+// CheckSecretLockout responds with 429 and reports true if the client or
+// the namespace gave a wrong data encryption secret too often
+func (a *Authenticator) CheckSecretLockout(w http.ResponseWriter, r *http.Request, user string) bool {
+	return a.checkLockout(w, r, a.secretLimiterIDs(r, user), "")
+}
+
+// SecretFailed counts a wrong data encryption secret like a failed
+// authentication and reports it to the failure hook. The caller responds.
+func (a *Authenticator) SecretFailed(r *http.Request, user string) {
+	a.limiter.fail(a.secretLimiterIDs(r, user), time.Now())
+	if a.onFailure != nil {
+		a.onFailure(r, Failure{User: user, Reason: "wrong encryption secret", Status: http.StatusForbidden})
+	}
+}
+
+// SecretSucceeded forgets the wrong secrets given for a namespace
+func (a *Authenticator) SecretSucceeded(r *http.Request, user string) {
+	a.limiter.succeed(a.secretLimiterIDs(r, user)[1:])
+}
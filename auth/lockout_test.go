@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"data-cron-server/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiterBackoff(t *testing.T) {
+	l := newLimiter()
+	l.maxFailures = 3
+	l.base = time.Second
+	l.max = 5 * time.Second
+
+	now := time.Now()
+	ids := []string{"ip:192.0.2.1"}
+
+	// No lockout below the threshold
+	l.fail(ids, now)
+	l.fail(ids, now)
+	if wait := l.retryAfter(ids, now); wait != 0 {
+		t.Errorf("retryAfter() = %v below threshold, expected 0", wait)
+	}
+
+	// The lockout doubles with every further failure up to the maximum
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, want := range expected {
+		l.fail(ids, now)
+		if wait := l.retryAfter(ids, now); wait != want {
+			t.Errorf("retryAfter() after failure %d = %v, expected %v", i+3, wait, want)
+		}
+	}
+
+	// The lockout ends
+	if wait := l.retryAfter(ids, now.Add(6*time.Second)); wait != 0 {
+		t.Errorf("retryAfter() after lockout = %v, expected 0", wait)
+	}
+
+	// Old failures are forgotten
+	l.fail(ids, now.Add(2*failureWindow))
+	if lockouts := l.list(now.Add(2 * failureWindow)); len(lockouts) != 1 || lockouts[0].Failures != 1 {
+		t.Errorf("fail() did not reset old failures: %+v", lockouts)
+	}
+}
+
+func TestRequireUserLockout(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.CreateUser("testuser")
+	key := issueTestKey(t, cfg, "testuser")
+
+	auth := NewAuthenticator(cfg, "super_admin_key")
+	auth.SetLockoutPolicy(3, time.Minute, time.Hour)
+
+	middleware := auth.RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(remoteAddr, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		middleware.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Enumerating keys from one IP locks the IP out
+	for i := 0; i < 3; i++ {
+		if rr := serve("192.0.2.1:1234", "/data/guess/keys"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("RequireUser() returned status %d for guess %d, expected %d", rr.Code, i, http.StatusUnauthorized)
+		}
+	}
+
+	rr := serve("192.0.2.1:1234", "/data/"+key+"/keys")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("RequireUser() returned status %d for locked out IP, expected %d", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("RequireUser() returned Retry-After %q, expected 60", rr.Header().Get("Retry-After"))
+	}
+
+	// Other IPs are not affected
+	if rr := serve("192.0.2.2:1234", "/data/"+key+"/keys"); rr.Code != http.StatusOK {
+		t.Errorf("RequireUser() returned status %d for other IP, expected %d", rr.Code, http.StatusOK)
+	}
+
+	// Guessing the secret of a known key ID locks the key out from any IP
+	keyID := mustKeyID(t, key)
+	for i := 0; i < 3; i++ {
+		serve("198.51.100.1:1234", "/data/"+keyID+".wrong/keys")
+	}
+	if rr := serve("192.0.2.3:1234", "/data/"+key+"/keys"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("RequireUser() returned status %d for locked out key, expected %d", rr.Code, http.StatusTooManyRequests)
+	}
+
+	// Clearing lockouts lets clients in again
+	if len(auth.Lockouts()) == 0 {
+		t.Error("Lockouts() returned no lockouts")
+	}
+	if !auth.ClearLockout("ip:192.0.2.1") {
+		t.Error("ClearLockout() returned false for locked out IP")
+	}
+	auth.ClearAllLockouts()
+	if rr := serve("192.0.2.1:1234", "/data/"+key+"/keys"); rr.Code != http.StatusOK {
+		t.Errorf("RequireUser() returned status %d after clearing lockouts, expected %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestSecretLockout(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.CreateUser("testuser")
+	auth := NewAuthenticator(cfg, "super_admin_key")
+	auth.SetLockoutPolicy(3, time.Minute, time.Hour)
+
+	request := func(remoteAddr string) *http.Request {
+		req := httptest.NewRequest("GET", "/data/-/secret", nil)
+		req.RemoteAddr = remoteAddr
+		return req
+	}
+	locked := func(remoteAddr string) bool {
+		return auth.CheckSecretLockout(httptest.NewRecorder(), request(remoteAddr), "testuser")
+	}
+
+	// Guessing the secret of a namespace from many IPs locks the namespace out
+	for i := 0; i < 3; i++ {
+		if locked("198.51.100.1:1234") {
+			t.Fatalf("CheckSecretLockout() returned true after %d wrong secrets", i)
+		}
+		auth.SecretFailed(request("198.51.100.1:1234"), "testuser")
+	}
+	if !locked("192.0.2.1:1234") {
+		t.Error("CheckSecretLockout() returned false for a namespace with too many wrong secrets")
+	}
+	if auth.CheckSecretLockout(httptest.NewRecorder(), request("192.0.2.1:1234"), "otheruser") {
+		t.Error("CheckSecretLockout() returned true for another namespace")
+	}
+
+	// A right secret forgets the wrong ones of the namespace, not of the IP
+	auth.ClearAllLockouts()
+	auth.SecretFailed(request("198.51.100.1:1234"), "testuser")
+	auth.SecretFailed(request("198.51.100.1:1234"), "testuser")
+	auth.SecretSucceeded(request("192.0.2.1:1234"), "testuser")
+	for _, lockout := range auth.Lockouts() {
+		if lockout.ID == "secret:testuser" {
+			t.Errorf("SecretSucceeded() kept the failures of the namespace: %+v", lockout)
+		}
+	}
+}
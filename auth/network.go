@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRs parses a list of CIDRs. Plain IP addresses are accepted as
+// single host networks.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address or CIDR %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or CIDR %q", cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ValidateCIDRs checks that an allowlist can be parsed
+func ValidateCIDRs(cidrs []string) error {
+	_, err := ParseCIDRs(cidrs)
+	return err
+}
+
+// SetTrustedProxies sets the reverse proxies whose X-Forwarded-For header
+// is trusted to carry the client address
+func (a *Authenticator) SetTrustedProxies(cidrs []string) error {
+	networks, err := ParseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	a.trustedProxies = networks
+	return nil
+}
+
+// SetSuperAdminAllowedCIDRs restricts the addresses the super admin key may
+// be used from
+func (a *Authenticator) SetSuperAdminAllowedCIDRs(cidrs []string) error {
+	if err := ValidateCIDRs(cidrs); err != nil {
+		return err
+	}
+	a.superAdminCIDRs = cidrs
+	return nil
+}
+
+// ClientIP returns the IP address of the client. X-Forwarded-For is only
+// honoured when the connection comes from a trusted proxy; the client is
+// the rightmost address in it that is not a trusted proxy itself.
+func (a *Authenticator) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !containsIP(a.trustedProxies, net.ParseIP(host)) {
+		return host
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				forwarded = append(forwarded, hop)
+			}
+		}
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(forwarded[i])
+		if ip == nil {
+			// Anything left of a malformed entry cannot be trusted
+			return host
+		}
+		host = ip.String()
+		if !containsIP(a.trustedProxies, ip) {
+			break
+		}
+	}
+
+	return host
+}
+
+// addressAllowed reports whether the client IP is within the allowlist. An
+// empty allowlist allows any address.
+func addressAllowed(ip string, cidrs []string) bool {
+	if len(cidrs) == 0 {
+		return true
+	}
+
+	networks, err := ParseCIDRs(cidrs)
+	if err != nil {
+		// Allowlists are validated when set, refuse if one is broken anyway
+		return false
+	}
+
+	return containsIP(networks, net.ParseIP(ip))
+}
+
+// containsIP reports whether one of the networks contains the IP
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"data-cron-server/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	auth := NewAuthenticator(config.NewConfig(), "super_admin_key")
+	if err := auth.SetTrustedProxies([]string{"10.0.0.0/8", "172.16.0.1"}); err != nil {
+		t.Fatalf("SetTrustedProxies() failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{"direct client", "203.0.113.7:1234", "", "203.0.113.7"},
+		{"untrusted proxy", "203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:1234", "198.51.100.1", "198.51.100.1"},
+		{"spoofed entry", "10.0.0.2:1234", "192.0.2.99, 198.51.100.1", "198.51.100.1"},
+		{"proxy chain", "10.0.0.2:1234", "198.51.100.1, 172.16.0.1", "198.51.100.1"},
+		{"malformed entry", "10.0.0.2:1234", "198.51.100.1, garbage", "10.0.0.2"},
+		{"no header", "10.0.0.2:1234", "", "10.0.0.2"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/v1/status", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if ip := auth.ClientIP(req); ip != test.expected {
+			t.Errorf("%s: ClientIP() = %s, expected %s", test.name, ip, test.expected)
+		}
+	}
+
+	if err := auth.SetTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("SetTrustedProxies() accepted an invalid entry")
+	}
+}
+
+func TestAllowedCIDRs(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.CreateUser("testuser")
+	key := issueTestKey(t, cfg, "testuser")
+	cfg.SetUserAllowedCIDRs("testuser", []string{"192.0.2.0/24", "2001:db8::/32"})
+
+	auth := NewAuthenticator(cfg, "super_admin_key")
+	if err := auth.SetSuperAdminAllowedCIDRs([]string{"198.51.100.1"}); err != nil {
+		t.Fatalf("SetSuperAdminAllowedCIDRs() failed: %v", err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serve := func(handler http.Handler, remoteAddr, key string) int {
+		req := httptest.NewRequest("GET", "/v1/status", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	userTests := []struct {
+		remoteAddr string
+		expected   int
+	}{
+		{"192.0.2.10:1234", http.StatusOK},
+		{"[2001:db8::1]:1234", http.StatusOK},
+		{"203.0.113.7:1234", http.StatusForbidden},
+	}
+	for _, test := range userTests {
+		if code := serve(auth.RequireUser(ok), test.remoteAddr, key); code != test.expected {
+			t.Errorf("RequireUser() from %s returned status %d, expected %d", test.remoteAddr, code, test.expected)
+		}
+	}
+
+	if code := serve(auth.RequireSuperAdmin(ok), "198.51.100.1:1234", "super_admin_key"); code != http.StatusOK {
+		t.Errorf("RequireSuperAdmin() from allowed address returned status %d, expected %d", code, http.StatusOK)
+	}
+	if code := serve(auth.RequireSuperAdmin(ok), "192.0.2.10:1234", "super_admin_key"); code != http.StatusForbidden {
+		t.Errorf("RequireSuperAdmin() from other address returned status %d, expected %d", code, http.StatusForbidden)
+	}
+}
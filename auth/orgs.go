@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"data-cron-server/config"
+	"net/http"
+)
+
+// HeaderNamespace selects the organization a user request acts in
+const HeaderNamespace = "X-Namespace"
+
+// Organization member roles, each one includes the permissions of the roles
+// before it
+const (
+	// OrgRoleViewer may read the jobs, statuses and data of the organization
+	OrgRoleViewer = "viewer"
+	// OrgRoleMember may additionally write data and toggle jobs
+	OrgRoleMember = "member"
+	// OrgRoleOwner may additionally create, change and delete jobs
+	OrgRoleOwner = "owner"
+)
+
+// orgRoleRanks orders the member roles by their permissions
+var orgRoleRanks = map[string]int{
+	OrgRoleViewer: 1,
+	OrgRoleMember: 2,
+	OrgRoleOwner:  3,
+}
+
+// ValidOrgRole reports whether the member role is known
+func ValidOrgRole(role string) bool {
+	_, ok := orgRoleRanks[role]
+	return ok
+}
+
+// orgRoleAllows reports whether a member role permits the method on the
+// resource
+func orgRoleAllows(role, resource, method string) bool {
+	required := OrgRoleOwner
+	switch {
+	case resource == ResourceCronToggle:
+		// Toggles are GET requests, but change the job
+		required = OrgRoleMember
+	case method == http.MethodGet || method == http.MethodHead:
+		required = OrgRoleViewer
+	case resource == ResourceData:
+		required = OrgRoleMember
+	}
+
+	rank, ok := orgRoleRanks[role]
+	return ok && rank >= orgRoleRanks[required]
+}
+
+// resolveNamespace returns the namespace a request of the user acts in: the
+// organization named by the X-Namespace header or the user itself. The
+// status is the HTTP status to respond with if access is denied.
+func (a *Authenticator) resolveNamespace(r *http.Request, user, resource string) (string, int) {
+	org := r.Header.Get(HeaderNamespace)
+	if org == "" || org == user {
+		return user, http.StatusOK
+	}
+
+	namespace := config.OrgNamespace(org)
+	role, isMember := a.config.GetMemberRole(namespace, user)
+	if !isMember {
+		// Do not reveal whether the organization exists
+		return "", http.StatusForbidden
+	}
+	if !orgRoleAllows(role, resource, r.Method) {
+		return "", http.StatusForbidden
+	}
+
+	return namespace, http.StatusOK
+}
+
+// isUser reports whether a name is an existing user rather than an
+// organization, which cannot authenticate
+func (a *Authenticator) isUser(name string) bool {
+	return !config.IsOrg(name) && a.config.GetUser(name) != nil
+}
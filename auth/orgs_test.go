@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"data-cron-server/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireUserNamespace(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.CreateUser("alice")
+	cfg.CreateUser("bob")
+	cfg.CreateUser("carol")
+	cfg.CreateOrg("team")
+	cfg.SetOrgMember("team", "alice", OrgRoleOwner)
+	cfg.SetOrgMember("team", "bob", OrgRoleViewer)
+	cfg.SetOrgMember("team", "carol", OrgRoleMember)
+
+	keys := map[string]string{
+		"alice": issueTestKey(t, cfg, "alice"),
+		"bob":   issueTestKey(t, cfg, "bob"),
+		"carol": issueTestKey(t, cfg, "carol"),
+	}
+
+	auth := NewAuthenticator(cfg, "super_admin_key")
+
+	var ctxUser, ctxActor string
+	middleware := auth.RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxUser, _ = UserFromContext(r.Context())
+		ctxActor, _ = ActorFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		user      string
+		namespace string
+		method    string
+		path      string
+		expected  int
+	}{
+		{"bob", "", "GET", "/v1/data/keys", http.StatusOK},
+		{"bob", "team", "GET", "/v1/data/keys", http.StatusOK},
+		{"bob", "@team", "GET", "/v1/status", http.StatusOK},
+		{"bob", "team", "PUT", "/v1/data/shared", http.StatusForbidden},
+		{"bob", "team", "GET", "/v1/cron/j1/on", http.StatusForbidden},
+		{"carol", "team", "PUT", "/v1/data/shared", http.StatusOK},
+		{"carol", "team", "GET", "/v1/cron/j1/off", http.StatusOK},
+		{"carol", "team", "POST", "/v1/cron", http.StatusForbidden},
+		{"alice", "team", "POST", "/v1/cron", http.StatusOK},
+		{"alice", "other", "GET", "/v1/data/keys", http.StatusForbidden},
+		{"alice", "bob", "GET", "/v1/data/keys", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		ctxUser, ctxActor = "", ""
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.Header.Set("X-API-Key", keys[test.user])
+		if test.namespace != "" {
+			req.Header.Set(HeaderNamespace, test.namespace)
+		}
+		rr := httptest.NewRecorder()
+		middleware.ServeHTTP(rr, req)
+
+		if rr.Code != test.expected {
+			t.Errorf("%s %s as %s in %q returned status %d, expected %d", test.method, test.path, test.user, test.namespace, rr.Code, test.expected)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		expectedUser := test.user
+		if test.namespace != "" {
+			expectedUser = config.OrgNamespace(test.namespace)
+		}
+		if ctxUser != expectedUser || ctxActor != test.user {
+			t.Errorf("%s %s as %s in %q set user %s and actor %s, expected %s and %s", test.method, test.path, test.user, test.namespace, ctxUser, ctxActor, expectedUser, test.user)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package auth
+
+// Admin roles, each one includes the permissions of the roles before it
+const (
+	// RoleViewer may read users, keys and the configuration
+	RoleViewer = "viewer"
+	// RoleOperator may additionally reload the configuration and toggle jobs
+	RoleOperator = "operator"
+	// RoleOwner may do everything, including replacing the configuration,
+	// deleting users and managing admins
+	RoleOwner = "owner"
+)
+
+// BootstrapAdmin is the name of the owner authenticated by the super admin
+// key from the environment
+const BootstrapAdmin = "super_admin"
+
+// roleRanks orders the roles by their permissions
+var roleRanks = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleOwner:    3,
+}
+
+// ValidRole reports whether the role is known
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleAllows reports whether a role has at least the permissions of the
+// required role
+func RoleAllows(role, required string) bool {
+	rank, ok := roleRanks[role]
+	return ok && rank >= roleRanks[required]
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"data-cron-server/config"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Resource types a scope can be restricted to
+const (
+	ResourceAny        = "*"
+	ResourceData       = "data"
+	ResourceCron       = "cron"
+	ResourceCronToggle = "cron:toggle"
+	ResourceStatus     = "status"
+)
+
+// scopeMethods lists the HTTP methods a scope may allow
+var scopeMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// ValidateScopes checks that scopes only reference known resources and
+// methods, and normalizes method names to upper case
+func ValidateScopes(scopes []config.Scope) error {
+	for i := range scopes {
+		switch scopes[i].Resource {
+		case ResourceAny, ResourceData, ResourceCron, ResourceCronToggle, ResourceStatus:
+		default:
+			return fmt.Errorf("unknown resource %q", scopes[i].Resource)
+		}
+
+		for j, method := range scopes[i].Methods {
+			method = strings.ToUpper(method)
+			if !scopeMethods[method] {
+				return fmt.Errorf("unknown method %q", scopes[i].Methods[j])
+			}
+			scopes[i].Methods[j] = method
+		}
+	}
+	return nil
+}
+
+// ScopesAllow reports whether the scopes permit the method on the target of
+// the given resource type. No scopes at all means full access.
+func ScopesAllow(scopes []config.Scope, resource, method, target string) bool {
+	return scopesAllow(scopes, resource, method, target, false)
+}
+
+// scopesAllow is ScopesAllow with support for listings, which are permitted
+// regardless of the prefix because handlers filter the listed IDs
+func scopesAllow(scopes []config.Scope, resource, method, target string, listing bool) bool {
+	if len(scopes) == 0 {
+		return true
+	}
+
+	for _, scope := range scopes {
+		if scope.Resource != ResourceAny && scope.Resource != resource {
+			continue
+		}
+		if !scopeAllowsMethod(scope, method) {
+			continue
+		}
+		if listing || strings.HasPrefix(target, scope.Prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scopeAllowsMethod reports whether a scope permits the HTTP method
+func scopeAllowsMethod(scope config.Scope, method string) bool {
+	if len(scope.Methods) == 0 {
+		return true
+	}
+	for _, allowed := range scope.Methods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// requestTarget determines the resource type and the data key or job ID a
+// request path refers to. Both /{endpoint}/{user_key}/... and the keyless
+// /v1/{endpoint}/... forms are understood. listing is set for GET requests
+// on collections whose handlers filter by scope.
+func requestTarget(path, method string) (resource, target string, listing bool) {
+	parts := splitPath(path)
+	if strings.HasPrefix(path, KeylessPrefix) {
+		parts = parts[1:]
+	} else if len(parts) > 1 {
+		parts = append(parts[:1:1], parts[2:]...)
+	}
+	if len(parts) == 0 {
+		return "", "", false
+	}
+
+	rest := parts[1:]
+	switch parts[0] {
+	case "status":
+		return ResourceStatus, "", method == http.MethodGet
+	case "cron":
+		switch {
+		case len(rest) == 0:
+			return ResourceCron, "", method == http.MethodGet
+		case len(rest) == 1 && (rest[0] == "on" || rest[0] == "off"):
+			return ResourceCronToggle, "", false
+		case len(rest) == 2 && (rest[1] == "on" || rest[1] == "off"):
+			return ResourceCronToggle, rest[0], false
+		default:
+			return ResourceCron, rest[0], false
+		}
+	case "data":
+		if len(rest) == 1 && rest[0] == "keys" {
+			return ResourceData, "", method == http.MethodGet
+		}
+		if len(rest) == 2 && rest[0] == "-" && rest[1] == "encryption" {
+			// Encryption covers all keys, so it needs access to all of them
+			return ResourceData, "", false
+		}
+		if len(rest) > 0 {
+			return ResourceData, rest[0], false
+		}
+		return ResourceData, "", false
+	}
+
+	return parts[0], "", false
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"data-cron-server/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestTarget(t *testing.T) {
+	tests := []struct {
+		method   string
+		path     string
+		resource string
+		target   string
+		listing  bool
+	}{
+		{"GET", "/status/key", ResourceStatus, "", true},
+		{"GET", "/v1/status", ResourceStatus, "", true},
+		{"GET", "/cron/key", ResourceCron, "", true},
+		{"POST", "/cron/key", ResourceCron, "", false},
+		{"GET", "/cron/key/on", ResourceCronToggle, "", false},
+		{"GET", "/cron/key/deploy-1", ResourceCron, "deploy-1", false},
+		{"GET", "/cron/key/deploy-1/off", ResourceCronToggle, "deploy-1", false},
+		{"GET", "/v1/cron/deploy-1/on", ResourceCronToggle, "deploy-1", false},
+		{"GET", "/data/key/keys", ResourceData, "", true},
+		{"GET", "/v1/data/keys", ResourceData, "", true},
+		{"PUT", "/v1/data/-/encryption", ResourceData, "", false},
+		{"PUT", "/data/key/-/encryption", ResourceData, "", false},
+		{"PUT", "/v1/data/encryption", ResourceData, "encryption", false},
+		{"PUT", "/data/key/dash.cpu", ResourceData, "dash.cpu", false},
+		{"GET", "/v1/data/dash.cpu", ResourceData, "dash.cpu", false},
+	}
+
+	for _, tt := range tests {
+		resource, target, listing := requestTarget(tt.path, tt.method)
+		if resource != tt.resource || target != tt.target || listing != tt.listing {
+			t.Errorf("requestTarget(%s %s) = %s, %s, %v, expected %s, %s, %v",
+				tt.method, tt.path, resource, target, listing, tt.resource, tt.target, tt.listing)
+		}
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	scopes := []config.Scope{{Resource: ResourceData, Methods: []string{"get"}}}
+	if err := ValidateScopes(scopes); err != nil {
+		t.Fatalf("ValidateScopes() returned error for valid scopes: %v", err)
+	}
+	if scopes[0].Methods[0] != http.MethodGet {
+		t.Errorf("ValidateScopes() did not normalize method: %s", scopes[0].Methods[0])
+	}
+
+	if err := ValidateScopes([]config.Scope{{Resource: "users"}}); err == nil {
+		t.Error("ValidateScopes() accepted an unknown resource")
+	}
+	if err := ValidateScopes([]config.Scope{{Resource: ResourceData, Methods: []string{"TRACE"}}}); err == nil {
+		t.Error("ValidateScopes() accepted an unknown method")
+	}
+}
+
+func TestRequireUserScopes(t *testing.T) {
+	cfg := config.NewConfig()
+	user := "testuser"
+	cfg.CreateUser(user)
+
+	dashboardKey := issueTestKey(t, cfg, user)
+	_, dashboardRecord, _ := cfg.FindAPIKey(mustKeyID(t, dashboardKey))
+	dashboardRecord.Scopes = []config.Scope{
+		{Resource: ResourceData, Methods: []string{http.MethodGet}, Prefix: "dash."},
+	}
+
+	ciKey := issueTestKey(t, cfg, user)
+	_, ciRecord, _ := cfg.FindAPIKey(mustKeyID(t, ciKey))
+	ciRecord.Scopes = []config.Scope{
+		{Resource: ResourceCronToggle, Prefix: "deploy-"},
+	}
+
+	fullKey := issueTestKey(t, cfg, user)
+
+	auth := NewAuthenticator(cfg, "super_admin_key")
+	middleware := auth.RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name     string
+		key      string
+		method   string
+		path     string
+		expected int
+	}{
+		{"dashboard reads prefixed key", dashboardKey, "GET", "/v1/data/dash.cpu", http.StatusOK},
+		{"dashboard lists keys", dashboardKey, "GET", "/v1/data/keys", http.StatusOK},
+		{"dashboard reads other key", dashboardKey, "GET", "/v1/data/secret", http.StatusForbidden},
+		{"dashboard writes prefixed key", dashboardKey, "PUT", "/v1/data/dash.cpu", http.StatusForbidden},
+		{"dashboard reads jobs", dashboardKey, "GET", "/v1/cron", http.StatusForbidden},
+		{"dashboard encrypts data", dashboardKey, "GET", "/v1/data/-/encryption", http.StatusForbidden},
+		{"ci toggles deploy job", ciKey, "GET", "/cron/" + ciKey + "/deploy-web/on", http.StatusOK},
+		{"ci toggles other job", ciKey, "GET", "/v1/cron/backup/off", http.StatusForbidden},
+		{"ci toggles all jobs", ciKey, "GET", "/v1/cron/off", http.StatusForbidden},
+		{"ci reads deploy job", ciKey, "GET", "/v1/cron/deploy-web", http.StatusForbidden},
+		{"full key writes data", fullKey, "PUT", "/v1/data/secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("X-API-Key", tt.key)
+			rr := httptest.NewRecorder()
+			middleware.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expected {
+				t.Errorf("RequireUser() returned status %d, expected %d", rr.Code, tt.expected)
+			}
+		})
+	}
+}
+
+// mustKeyID extracts the key ID from a plaintext key
+func mustKeyID(t *testing.T, key string) string {
+	t.Helper()
+
+	id, ok := parseKeyID(key)
+	if !ok {
+		t.Fatalf("parseKeyID() failed for %s", key)
+	}
+	return id
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MaxShareLinkLifetime limits how long a share link can be valid
+const MaxShareLinkLifetime = 30 * 24 * time.Hour
+
+// Share link errors
+var (
+	ErrShareLinkExpired = errors.New("share link expired")
+	ErrShareLinkInvalid = errors.New("invalid share link")
+)
+
+// SetShareSecret sets the server secret share links are signed with
+func (a *Authenticator) SetShareSecret(secret []byte) {
+	a.shareSecret = secret
+}
+
+// newShareSecret generates a random share secret, used when none is
+// configured. Links signed with it stop working on restart.
+func newShareSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("auth: failed to generate share secret: " + err.Error())
+	}
+	return secret
+}
+
+// SignShareLink returns the path and query of a link granting the method on
+// one data key of a user until the expiry
+func (a *Authenticator) SignShareLink(user, dataKey, method string, expires time.Time) (string, error) {
+	salt, err := a.shareSalt(user)
+	if err != nil {
+		return "", err
+	}
+
+	expiresStr := strconv.FormatInt(expires.Unix(), 10)
+	query := url.Values{
+		"method":  {method},
+		"expires": {expiresStr},
+		"sig":     {a.shareSignature(user, dataKey, method, expiresStr, salt)},
+	}
+
+	return "/share/" + url.PathEscape(user) + "/" + url.PathEscape(dataKey) + "?" + query.Encode(), nil
+}
+
+// VerifyShareLink checks the signature and expiry of a share link request
+// for the data key of a user
+func (a *Authenticator) VerifyShareLink(r *http.Request, user, dataKey string) error {
+	query := r.URL.Query()
+	method := query.Get("method")
+	expiresStr := query.Get("expires")
+	signature := query.Get("sig")
+
+	if method != r.Method {
+		return ErrShareLinkInvalid
+	}
+
+	salt, exists := a.config.GetUserShareSalt(user)
+	if !exists || salt == "" {
+		return ErrShareLinkInvalid
+	}
+
+	expected := a.shareSignature(user, dataKey, method, expiresStr, salt)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrShareLinkInvalid
+	}
+
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return ErrShareLinkInvalid
+	}
+	if time.Now().Unix() > expires {
+		return ErrShareLinkExpired
+	}
+
+	return nil
+}
+
+// RotateShareSalt replaces the share salt of a user, which revokes all share
+// links issued for the user so far
+func (a *Authenticator) RotateShareSalt(user string) error {
+	salt, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+
+	if !a.config.SetUserShareSalt(user, salt) {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// shareSalt returns the share salt of a user, creating one if needed
+func (a *Authenticator) shareSalt(user string) (string, error) {
+	salt, exists := a.config.GetUserShareSalt(user)
+	if !exists {
+		return "", ErrUserNotFound
+	}
+	if salt != "" {
+		return salt, nil
+	}
+
+	if err := a.RotateShareSalt(user); err != nil {
+		return "", err
+	}
+
+	salt, _ = a.config.GetUserShareSalt(user)
+	return salt, nil
+}
+
+// shareSignature computes the hex encoded HMAC-SHA256 of a share link
+func (a *Authenticator) shareSignature(user, dataKey, method, expires, salt string) string {
+	mac := hmac.New(sha256.New, a.shareSecret)
+	mac.Write([]byte(user + "\n" + dataKey + "\n" + method + "\n" + expires + "\n" + salt))
+	return hex.EncodeToString(mac.Sum(nil))
+}
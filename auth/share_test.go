@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"data-cron-server/config"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShareLink(t *testing.T) {
+	cfg := config.NewConfig()
+	user := "testuser"
+	cfg.CreateUser(user)
+
+	auth := NewAuthenticator(cfg, "super_admin_key")
+	auth.SetShareSecret([]byte("test_share_secret"))
+
+	link, err := auth.SignShareLink(user, "report", "GET", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignShareLink() failed: %v", err)
+	}
+
+	// Valid link
+	req := httptest.NewRequest("GET", link, nil)
+	if err := auth.VerifyShareLink(req, user, "report"); err != nil {
+		t.Errorf("VerifyShareLink() returned error for valid link: %v", err)
+	}
+
+	// Other method, key or user
+	req = httptest.NewRequest("PUT", link, nil)
+	if err := auth.VerifyShareLink(req, user, "report"); err != ErrShareLinkInvalid {
+		t.Errorf("VerifyShareLink() accepted another method: %v", err)
+	}
+	req = httptest.NewRequest("GET", link, nil)
+	if err := auth.VerifyShareLink(req, user, "other"); err != ErrShareLinkInvalid {
+		t.Errorf("VerifyShareLink() accepted another key: %v", err)
+	}
+	cfg.CreateUser("otheruser")
+	if err := auth.VerifyShareLink(req, "otheruser", "report"); err != ErrShareLinkInvalid {
+		t.Errorf("VerifyShareLink() accepted another user: %v", err)
+	}
+
+	// Another server secret
+	other := NewAuthenticator(cfg, "super_admin_key")
+	if err := other.VerifyShareLink(req, user, "report"); err != ErrShareLinkInvalid {
+		t.Errorf("VerifyShareLink() accepted a link signed with another secret: %v", err)
+	}
+
+	// Expired link
+	expired, _ := auth.SignShareLink(user, "report", "GET", time.Now().Add(-time.Minute))
+	req = httptest.NewRequest("GET", expired, nil)
+	if err := auth.VerifyShareLink(req, user, "report"); err != ErrShareLinkExpired {
+		t.Errorf("VerifyShareLink() did not reject an expired link: %v", err)
+	}
+
+	// Rotating the salt revokes existing links
+	if err := auth.RotateShareSalt(user); err != nil {
+		t.Fatalf("RotateShareSalt() failed: %v", err)
+	}
+	req = httptest.NewRequest("GET", link, nil)
+	if err := auth.VerifyShareLink(req, user, "report"); err != ErrShareLinkInvalid {
+		t.Errorf("VerifyShareLink() accepted a link after rotation: %v", err)
+	}
+}
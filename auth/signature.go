@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"data-cron-server/config"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of a signed request
+const (
+	HeaderKeyID     = "X-Key-Id"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderNonce     = "X-Nonce"
+	HeaderSignature = "X-Signature"
+)
+
+// DefaultMaxClockSkew is how far the timestamp of a signed request may
+// differ from the server clock
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// maxSignedBodySize limits the body that is read to verify a signature
+const maxSignedBodySize = 10 << 20
+
+// Signature errors
+var (
+	ErrInvalidSignature = errors.New("invalid request signature")
+	ErrStaleTimestamp   = errors.New("request timestamp outside allowed clock skew")
+	ErrReplayedNonce    = errors.New("request nonce already used")
+	ErrMissingSignature = errors.New("request is not signed")
+	// ErrSigningDisabled is returned for keys issued without a signing
+	// secret, which cannot sign requests until they are rotated
+	ErrSigningDisabled = errors.New("key cannot sign requests")
+)
+
+// SigningSecret returns the HMAC secret for an API key, which is the secret
+// part of the key. The server never stores it in usable form.
+func SigningSecret(key string) string {
+	_, secret, _ := strings.Cut(key, keySeparator)
+	return secret
+}
+
+// SetSigningSecret sets the server secret that the signing secrets of API
+// keys are encrypted with
+func (a *Authenticator) SetSigningSecret(secret []byte) {
+	a.signingSecret = secret
+}
+
+// signingCipher returns AES-256-GCM with a key derived from the server
+// signing secret
+func (a *Authenticator) signingCipher() (cipher.AEAD, error) {
+	key := sha256.Sum256(a.signingSecret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EnableSigning lets an API key sign requests. Its signing secret is
+// stored encrypted with the server signing secret, so neither the key
+// record nor a copy of the configuration can be used to sign requests.
+func (a *Authenticator) EnableSigning(key *config.APIKey, plaintext string) error {
+	id, ok := parseKeyID(plaintext)
+	if !ok || id != key.ID {
+		return ErrInvalidKey
+	}
+
+	aead, err := a.signingCipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	// The key ID is authenticated, so the secret cannot be moved to another
+	// key
+	sealed := aead.Seal(nonce, nonce, []byte(SigningSecret(plaintext)), []byte(key.ID))
+	key.SigningKey = base64.StdEncoding.EncodeToString(sealed)
+	return nil
+}
+
+// keySigningSecret decrypts the signing secret of an API key
+func (a *Authenticator) keySigningSecret(key *config.APIKey) (string, error) {
+	if key.SigningKey == "" {
+		return "", ErrSigningDisabled
+	}
+	sealed, err := base64.StdEncoding.DecodeString(key.SigningKey)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	aead, err := a.signingCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrInvalidSignature
+	}
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(key.ID))
+	if err != nil {
+		// Encrypted with another server signing secret
+		return "", ErrInvalidSignature
+	}
+	return string(secret), nil
+}
+
+// SignRequest signs a request with an API key. The body is read and
+// replaced, so the request can still be sent afterwards.
+func SignRequest(r *http.Request, key, nonce string, now time.Time) error {
+	keyID, ok := parseKeyID(key)
+	if !ok {
+		return ErrInvalidKey
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set(HeaderKeyID, keyID)
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set(HeaderSignature, computeSignature(SigningSecret(key), r, timestamp, nonce, body))
+
+	return nil
+}
+
+// isSigned reports whether a request carries a signature
+func isSigned(r *http.Request) bool {
+	return r.Header.Get(HeaderSignature) != ""
+}
+
+// AuthenticateSignature verifies a signed request and resolves the user and
+// API key it was signed with
+func (a *Authenticator) AuthenticateSignature(r *http.Request) (string, *config.APIKey, error) {
+	signature := r.Header.Get(HeaderSignature)
+	if signature == "" {
+		return "", nil, ErrMissingSignature
+	}
+
+	keyID := r.Header.Get(HeaderKeyID)
+	timestamp := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	if keyID == "" || timestamp == "" || nonce == "" {
+		return "", nil, ErrInvalidSignature
+	}
+
+	// Reject timestamps outside the allowed skew
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", nil, ErrInvalidSignature
+	}
+	now := time.Now()
+	skew := now.Sub(time.Unix(seconds, 0))
+	if skew > a.maxClockSkew || skew < -a.maxClockSkew {
+		return "", nil, ErrStaleTimestamp
+	}
+
+	user, apiKey, found := a.config.FindAPIKey(keyID)
+	if !found {
+		return "", nil, ErrInvalidKey
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret, err := a.keySigningSecret(apiKey)
+	if err != nil {
+		return "", nil, err
+	}
+	expected := computeSignature(secret, r, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return "", nil, ErrInvalidSignature
+	}
+
+	// Only remember nonces of valid signatures, so they cannot be burned
+	if !a.nonces.add(keyID+":"+nonce, now.Add(2*a.maxClockSkew), now) {
+		return "", nil, ErrReplayedNonce
+	}
+
+	if err := checkKeyValidity(apiKey, now); err != nil {
+		return "", nil, err
+	}
+
+	return user, apiKey, nil
+}
+
+// RequireSignature is a middleware that only accepts signed requests,
+// requests with a verified client certificate or impersonation by an admin
+// and otherwise behaves like RequireUser
+func (a *Authenticator) RequireSignature(next http.Handler) http.Handler {
+	requireUser := a.RequireUser(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Client certificates prove possession of a secret as well. Admin
+		// credentials are never signed.
+		_, hasCert := a.certUser(r)
+		if !isSigned(r) && !hasCert && r.Header.Get(HeaderImpersonate) == "" {
+			a.reject(w, r, Failure{Reason: "request not signed", Status: http.StatusUnauthorized})
+			return
+		}
+
+		requireUser.ServeHTTP(w, r)
+	})
+}
+
+// computeSignature computes the hex encoded HMAC-SHA256 over the method,
+// path with query, timestamp, nonce and body hash of a request
+func computeSignature(secret string, r *http.Request, timestamp, nonce string, body []byte) string {
+	path := r.URL.EscapedPath()
+	if r.URL.RawQuery != "" {
+		path += "?" + r.URL.RawQuery
+	}
+
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(r.Method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(bodyHash[:])))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// readBody reads the request body and replaces it with a copy
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSignedBodySize {
+		return nil, ErrInvalidSignature
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// nonceCache remembers recently used nonces until they expire
+type nonceCache struct {
+	mutex     sync.Mutex
+	entries   map[string]time.Time
+	lastPrune time.Time
+}
+
+// newNonceCache creates an empty nonce cache
+func newNonceCache() *nonceCache {
+	return &nonceCache{
+		entries: make(map[string]time.Time),
+	}
+}
+
+// add records a nonce and reports false if it was already seen
+func (c *nonceCache) add(nonce string, expires, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if expiry, exists := c.entries[nonce]; exists && expiry.After(now) {
+		return false
+	}
+
+	// Drop expired nonces once a minute to keep the cache small
+	if now.Sub(c.lastPrune) > time.Minute {
+		for key, expiry := range c.entries {
+			if !expiry.After(now) {
+				delete(c.entries, key)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	c.entries[nonce] = expires
+	return true
+}
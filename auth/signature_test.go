@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"data-cron-server/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticateSignature(t *testing.T) {
+	cfg := config.NewConfig()
+	user := "testuser"
+	cfg.CreateUser(user)
+	auth := NewAuthenticator(cfg, "super_admin_key")
+	key := issueSigningKey(t, auth, cfg, user)
+
+	newSigned := func(body, nonce string, now time.Time) *http.Request {
+		req := httptest.NewRequest("PUT", "/v1/data/settings?x=1", strings.NewReader(body))
+		if err := SignRequest(req, key, nonce, now); err != nil {
+			t.Fatalf("SignRequest() failed: %v", err)
+		}
+		return req
+	}
+
+	// Valid signature, body stays readable for the handler
+	req := newSigned(`{"theme":"dark"}`, "nonce-1", time.Now())
+	if owner, _, err := auth.AuthenticateSignature(req); err != nil || owner != user {
+		t.Fatalf("AuthenticateSignature() = %s, %v, expected %s", owner, err, user)
+	}
+	if body, _ := io.ReadAll(req.Body); string(body) != `{"theme":"dark"}` {
+		t.Errorf("AuthenticateSignature() did not restore the body: %s", body)
+	}
+
+	// Replayed nonce
+	req = newSigned(`{"theme":"dark"}`, "nonce-1", time.Now())
+	if _, _, err := auth.AuthenticateSignature(req); err != ErrReplayedNonce {
+		t.Errorf("AuthenticateSignature() did not reject a replayed nonce: %v", err)
+	}
+
+	// Tampered body
+	req = newSigned(`{"theme":"dark"}`, "nonce-2", time.Now())
+	req.Body = io.NopCloser(strings.NewReader(`{"theme":"light"}`))
+	if _, _, err := auth.AuthenticateSignature(req); err != ErrInvalidSignature {
+		t.Errorf("AuthenticateSignature() did not reject a tampered body: %v", err)
+	}
+
+	// Tampered path
+	req = newSigned("", "nonce-3", time.Now())
+	req.URL.Path = "/v1/data/other"
+	if _, _, err := auth.AuthenticateSignature(req); err != ErrInvalidSignature {
+		t.Errorf("AuthenticateSignature() did not reject a tampered path: %v", err)
+	}
+
+	// Skewed timestamps
+	for _, offset := range []time.Duration{-10 * time.Minute, 10 * time.Minute} {
+		req = newSigned("", "nonce-4", time.Now().Add(offset))
+		if _, _, err := auth.AuthenticateSignature(req); err != ErrStaleTimestamp {
+			t.Errorf("AuthenticateSignature() did not reject timestamp offset %v: %v", offset, err)
+		}
+	}
+
+	// Signed with another secret
+	req = newSigned("", "nonce-5", time.Now())
+	req.Header.Set(HeaderSignature, strings.Repeat("0", 64))
+	if _, _, err := auth.AuthenticateSignature(req); err != ErrInvalidSignature {
+		t.Errorf("AuthenticateSignature() did not reject a wrong signature: %v", err)
+	}
+
+	// The stored key record cannot sign requests
+	_, record, _ := cfg.FindAPIKey(mustKeyID(t, key))
+	if strings.Contains(record.SigningKey, SigningSecret(key)) || record.Hash == SigningSecret(key) {
+		t.Error("EnableSigning() stored the signing secret in plaintext")
+	}
+	req = newSigned("", "nonce-6", time.Now())
+	req.Header.Set(HeaderSignature, computeSignature(record.Hash, req, req.Header.Get(HeaderTimestamp), "nonce-6", nil))
+	if _, _, err := auth.AuthenticateSignature(req); err != ErrInvalidSignature {
+		t.Errorf("AuthenticateSignature() accepted a request signed with the key hash: %v", err)
+	}
+
+	// Another server signing secret cannot decrypt the signing secret
+	other := NewAuthenticator(cfg, "super_admin_key")
+	other.SetSigningSecret([]byte("another signing secret"))
+	if _, _, err := other.AuthenticateSignature(newSigned("", "nonce-7", time.Now())); err != ErrInvalidSignature {
+		t.Errorf("AuthenticateSignature() accepted a key encrypted with another secret: %v", err)
+	}
+
+	// Keys issued without a signing secret cannot sign
+	unsigned := issueTestKey(t, cfg, user)
+	req = httptest.NewRequest("GET", "/v1/data/keys", nil)
+	SignRequest(req, unsigned, "nonce-8", time.Now())
+	if _, _, err := auth.AuthenticateSignature(req); err != ErrSigningDisabled {
+		t.Errorf("AuthenticateSignature() returned %v for a key without signing secret, expected %v", err, ErrSigningDisabled)
+	}
+}
+
+// issueSigningKey issues an API key that can sign requests for the given
+// user and returns the plaintext
+func issueSigningKey(t *testing.T, auth *Authenticator, cfg *config.Config, user string) string {
+	t.Helper()
+
+	plaintext, key, err := IssueAPIKey("signing")
+	if err != nil {
+		t.Fatalf("IssueAPIKey() failed: %v", err)
+	}
+	if err := auth.EnableSigning(key, plaintext); err != nil {
+		t.Fatalf("EnableSigning() failed: %v", err)
+	}
+	if !cfg.AddUserAPIKey(user, key) {
+		t.Fatalf("AddUserAPIKey() failed for user %s", user)
+	}
+
+	return plaintext
+}
+
+func TestRequireSignature(t *testing.T) {
+	cfg := config.NewConfig()
+	user := "testuser"
+	cfg.CreateUser(user)
+	auth := NewAuthenticator(cfg, "super_admin_key")
+	auth.SetSignatures(true, time.Minute)
+	key := issueSigningKey(t, auth, cfg, user)
+
+	middleware := auth.RequireSignature(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ctxUser, _ := UserFromContext(r.Context()); ctxUser != user {
+			t.Errorf("RequireSignature() did not set user in context: %s", ctxUser)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// Bearer keys are not enough
+	req := httptest.NewRequest("GET", "/v1/data/keys", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	rr := httptest.NewRecorder()
+	middleware.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("RequireSignature() returned status %d for unsigned request, expected %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	// Signed request
+	req = httptest.NewRequest("GET", "/v1/data/keys", nil)
+	SignRequest(req, key, "nonce", time.Now())
+	rr = httptest.NewRecorder()
+	middleware.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("RequireSignature() returned status %d for signed request, expected %d", rr.Code, http.StatusOK)
+	}
+
+	// The same request again is a replay
+	rr = httptest.NewRecorder()
+	middleware.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("RequireSignature() returned status %d for replayed request, expected %d", rr.Code, http.StatusUnauthorized)
+	}
+}
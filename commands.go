@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"data-cron-server/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// commandUsage describes the offline commands
+const commandUsage = `Usage:
+  server                 Run the server
+  server decrypt <file>  Write the decrypted content of a configuration, user, revision object or journal file to stdout
+  server encrypt <file>  Write the content of a file encrypted with the current key to stdout
+
+The keys are read from CONFIG_ENCRYPTION_KEY or CONFIG_ENCRYPTION_KEY_FILE and CONFIG_ENCRYPTION_OLD_KEYS.
+`
+
+// runCommand runs an offline command, e.g. to recover an encrypted
+// configuration without the server, and returns the exit code
+func runCommand(args []string) int {
+	if len(args) != 2 || (args[0] != "decrypt" && args[0] != "encrypt") {
+		fmt.Fprint(os.Stderr, commandUsage)
+		return 2
+	}
+
+	cipher, err := encryptionFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid encryption key: %v\n", err)
+		return 1
+	}
+	data, err := os.ReadFile(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", args[1], err)
+		return 1
+	}
+
+	var out []byte
+	if args[0] == "decrypt" {
+		out, err = decryptFile(cipher, args[1], data)
+	} else {
+		out, err = encryptFile(cipher, data)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to %s %s: %v\n", args[0], args[1], err)
+		return 1
+	}
+
+	if _, err := os.Stdout.Write(out); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write output: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// decryptFile decrypts a sealed file. A journal is decrypted line by line,
+// plaintext JSON, YAML and TOML files are returned as is.
+func decryptFile(cipher *config.Cipher, path string, data []byte) ([]byte, error) {
+	if config.IsSealed(data) {
+		plain, _, err := cipher.Open(data)
+		return plain, err
+	}
+	if json.Valid(data) || config.FormatOf(path) != config.FormatJSON {
+		return data, nil
+	}
+
+	var out bytes.Buffer
+	for i, line := range bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n")) {
+		plain, err := cipher.OpenLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		out.Write(plain)
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), nil
+}
+
+// encryptFile seals a file with the current key. A sealed file is opened
+// first, so this also seals it again with a new key.
+func encryptFile(cipher *config.Cipher, data []byte) ([]byte, error) {
+	if cipher == nil {
+		return nil, errors.New("CONFIG_ENCRYPTION_KEY or CONFIG_ENCRYPTION_KEY_FILE must be set")
+	}
+	plain, _, err := cipher.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.Seal(plain)
+}
+
+// encryptionFromEnv returns the cipher for the keys in CONFIG_ENCRYPTION_KEY
+// or CONFIG_ENCRYPTION_KEY_FILE and CONFIG_ENCRYPTION_OLD_KEYS, nil if no key
+// is set
+func encryptionFromEnv() (*config.Cipher, error) {
+	keyStr := getEnvOrDefault("CONFIG_ENCRYPTION_KEY", "")
+	keyFile := getEnvOrDefault("CONFIG_ENCRYPTION_KEY_FILE", "")
+	oldKeyStrs := splitList(getEnvOrDefault("CONFIG_ENCRYPTION_OLD_KEYS", ""))
+
+	var current []byte
+	var old [][]byte
+	switch {
+	case keyStr != "" && keyFile != "":
+		return nil, errors.New("CONFIG_ENCRYPTION_KEY and CONFIG_ENCRYPTION_KEY_FILE cannot both be set")
+	case keyStr != "":
+		key, err := config.ParseKey(keyStr)
+		if err != nil {
+			return nil, err
+		}
+		current = key
+	case keyFile != "":
+		key, fileOld, err := config.ReadKeyFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		current, old = key, fileOld
+	}
+
+	for _, oldKeyStr := range oldKeyStrs {
+		key, err := config.ParseKey(oldKeyStr)
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG_ENCRYPTION_OLD_KEYS: %w", err)
+		}
+		old = append(old, key)
+	}
+
+	if current == nil && len(old) == 0 {
+		return nil, nil
+	}
+	// With old keys only, the next saves write plaintext
+	return config.NewCipher(current, old...)
+}
@@ -0,0 +1,322 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Admin represents a named administrator account with a role
+type Admin struct {
+	Role      string    `json:"role"`
+	Keys      []*APIKey `json:"keys,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// AllowedCIDRs restricts the client addresses the admin may connect
+	// from; empty allows any address
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"`
+}
+
+// Admins holds all admin accounts. They are persisted in their own file
+// next to the configuration, keyed by admin name.
+type Admins struct {
+	mutex    sync.RWMutex
+	Accounts map[string]*Admin `json:"admins"`
+	Changed  bool              // Track if admins have changed since last save
+	// path is the file Save writes, saving orders the saves
+	path   string
+	saving sync.Mutex
+}
+
+// NewAdmins creates a new empty set of admin accounts
+func NewAdmins() *Admins {
+	return &Admins{
+		Accounts: make(map[string]*Admin),
+	}
+}
+
+// LoadAdmins loads the admin accounts from the given file path
+func LoadAdmins(filePath string) (*Admins, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	admins := NewAdmins()
+	if err := json.Unmarshal(data, &admins.Accounts); err != nil {
+		return nil, err
+	}
+	if admins.Accounts == nil {
+		admins.Accounts = make(map[string]*Admin)
+	}
+
+	return admins, nil
+}
+
+// SaveAdmins saves the admin accounts to the given file path
+func SaveAdmins(admins *Admins, filePath string) error {
+	// Saves run one after the other, so older accounts never overwrite
+	// newer ones
+	admins.saving.Lock()
+	defer admins.saving.Unlock()
+
+	admins.mutex.Lock()
+	// Only proceed with saving if admins have changed
+	if !admins.Changed {
+		admins.mutex.Unlock()
+		return nil
+	}
+
+	data, err := json.MarshalIndent(admins.Accounts, "", "  ")
+	if err != nil {
+		admins.mutex.Unlock()
+		return fmt.Errorf("failed to marshal admins: %w", err)
+	}
+	// Changes made while the file is written are saved with the next save
+	admins.Changed = false
+	admins.mutex.Unlock()
+
+	// The file holds credentials, so only the owner may read it
+	err = writeFileAtomic(filePath, data, 0600, 0)
+	if err != nil {
+		admins.mutex.Lock()
+		admins.Changed = true
+		admins.mutex.Unlock()
+		return fmt.Errorf("failed to write admins file %s: %w", filePath, err)
+	}
+
+	return nil
+}
+
+// SetFilePath sets the file Save writes the admin accounts to
+func (a *Admins) SetFilePath(path string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.path = path
+}
+
+// Save saves the admin accounts to their file if they changed. Changes of
+// accounts are saved right away, as a key is only handed out once.
+func (a *Admins) Save() error {
+	a.mutex.RLock()
+	path := a.path
+	a.mutex.RUnlock()
+
+	if path == "" {
+		return ErrNoStore
+	}
+	return SaveAdmins(a, path)
+}
+
+// GetAdmin returns the admin account with the given name
+func (a *Admins) GetAdmin(name string) *Admin {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.Accounts[name]
+}
+
+// GetAllAdmins returns all admin names in sorted order
+func (a *Admins) GetAllAdmins() []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	names := make([]string, 0, len(a.Accounts))
+	for name := range a.Accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// CreateAdmin creates a new admin account, returning false if it exists
+func (a *Admins) CreateAdmin(name, role string) (*Admin, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if existing, exists := a.Accounts[name]; exists {
+		return existing, false
+	}
+
+	admin := &Admin{
+		Role:      role,
+		Keys:      make([]*APIKey, 0),
+		CreatedAt: time.Now().UTC(),
+	}
+	a.Accounts[name] = admin
+	a.Changed = true
+
+	return admin, true
+}
+
+// SetAdminRole changes the role of an admin account
+func (a *Admins) SetAdminRole(name, role string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	admin, exists := a.Accounts[name]
+	if !exists {
+		return false
+	}
+
+	if admin.Role != role {
+		admin.Role = role
+		a.Changed = true
+	}
+
+	return true
+}
+
+// SetAdminAllowedCIDRs replaces the address allowlist of an admin account
+func (a *Admins) SetAdminAllowedCIDRs(name string, cidrs []string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	admin, exists := a.Accounts[name]
+	if !exists {
+		return false
+	}
+
+	admin.AllowedCIDRs = cidrs
+	a.Changed = true
+
+	return true
+}
+
+// DeleteAdmin deletes an admin account together with its keys
+func (a *Admins) DeleteAdmin(name string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, exists := a.Accounts[name]; exists {
+		delete(a.Accounts, name)
+		a.Changed = true
+		return true
+	}
+
+	return false
+}
+
+// AddAdminKey adds an API key to an admin account
+func (a *Admins) AddAdminKey(name string, key *APIKey) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	admin, exists := a.Accounts[name]
+	if !exists {
+		return false
+	}
+
+	admin.Keys = append(admin.Keys, key)
+	a.Changed = true
+
+	return true
+}
+
+// UpdateAdminKey calls fn with an API key of an admin account to change it
+func (a *Admins) UpdateAdminKey(name, keyID string, fn func(key *APIKey)) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	admin, exists := a.Accounts[name]
+	if !exists {
+		return false
+	}
+
+	for _, key := range admin.Keys {
+		if key.ID == keyID {
+			fn(key)
+			a.Changed = true
+			return true
+		}
+	}
+
+	return false
+}
+
+// RotateAdminKey adds a new API key for an admin account and lets the old
+// one expire at graceEnd, unless it expires earlier anyway
+func (a *Admins) RotateAdminKey(name, keyID string, newKey *APIKey, graceEnd time.Time) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	admin, exists := a.Accounts[name]
+	if !exists {
+		return false
+	}
+
+	keys, rotated := rotateKey(admin.Keys, keyID, newKey, graceEnd)
+	if !rotated {
+		return false
+	}
+	admin.Keys = keys
+	a.Changed = true
+
+	return true
+}
+
+// AllAdminKeys returns copies of the API keys of all admin accounts
+func (a *Admins) AllAdminKeys() []OwnedKey {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	var keys []OwnedKey
+	for name, admin := range a.Accounts {
+		for _, key := range admin.Keys {
+			keys = append(keys, OwnedKey{Owner: name, Key: *key})
+		}
+	}
+
+	return keys
+}
+
+// DeleteAdminKey revokes an API key of an admin account
+func (a *Admins) DeleteAdminKey(name, keyID string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	admin, exists := a.Accounts[name]
+	if !exists {
+		return false
+	}
+
+	for i, key := range admin.Keys {
+		if key.ID == keyID {
+			admin.Keys = append(admin.Keys[:i], admin.Keys[i+1:]...)
+			a.Changed = true
+			return true
+		}
+	}
+
+	return false
+}
+
+// Snapshot returns the JSON encoding of all admin accounts
+func (a *Admins) Snapshot() []byte {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	data, _ := json.Marshal(a.Accounts)
+	return data
+}
+
+// FindAdminKey looks up an API key by its ID across all admin accounts and
+// returns the owning admin name, the account and the key
+func (a *Admins) FindAdminKey(keyID string) (string, *Admin, *APIKey, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	for name, admin := range a.Accounts {
+		for _, key := range admin.Keys {
+			if key.ID == keyID {
+				return name, admin, key, true
+			}
+		}
+	}
+
+	return "", nil, nil, false
+}
@@ -0,0 +1,249 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxBackups is how many previous generations of the configuration
+// file are kept
+const DefaultMaxBackups = 5
+
+var (
+	// ErrBackupNotFound is returned for backup generations that do not exist
+	ErrBackupNotFound = errors.New("backup not found")
+	// ErrNoBackups is returned for the backups of a store that keeps none
+	ErrNoBackups = errors.New("the store keeps no backups")
+)
+
+// BackupStore is implemented by stores that keep previous generations of
+// their data, like the file store keeps config.json.1 to config.json.N
+type BackupStore interface {
+	// Backups returns the generations kept, newest first
+	Backups() ([]Backup, error)
+	// LoadBackup loads a generation, returning ErrBackupNotFound if it is
+	// not kept
+	LoadBackup(generation int) (*Config, error)
+}
+
+// maxBackups is how many previous generations SaveConfig keeps
+var maxBackups = DefaultMaxBackups
+
+// SetMaxBackups sets how many previous generations of the configuration
+// file SaveConfig keeps as config.json.1 (the newest) to config.json.N. 0
+// disables backups.
+func SetMaxBackups(n int) {
+	maxBackups = n
+}
+
+// Backup describes a previous generation of a file
+type Backup struct {
+	Generation int       `json:"generation"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"modified_at"`
+}
+
+// writeFileAtomic replaces the file at path with data. The data is
+// written to a temporary file in the same directory, synced and renamed
+// over the file, so a crash or a full disk leaves either the old or the new
+// content, never a truncated file. The previous content is kept in up to
+// backups generations first.
+func writeFileAtomic(path string, data []byte, perm os.FileMode, backups int) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	if backups > 0 {
+		if err := rotateBackups(path, perm, backups); err != nil {
+			return err
+		}
+	}
+
+	return replaceFile(path, data, perm)
+}
+
+// replaceFile writes data to a temporary file and renames it over path
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file in %s: %w", dir, err)
+	}
+	// Remove the temporary file unless it was renamed
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions of %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", tmp.Name(), err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	// Persist the rename itself
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// rotateBackups shifts path.1 to path.N one generation up, dropping the
+// oldest, and copies the current file to path.1. The current file is
+// copied rather than renamed, so it exists at all times.
+func rotateBackups(path string, perm os.FileMode, backups int) error {
+	current, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s for backup: %w", path, err)
+	}
+
+	for generation := backups - 1; generation >= 1; generation-- {
+		err := os.Rename(backupPath(path, generation), backupPath(path, generation+1))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate backups of %s: %w", path, err)
+		}
+	}
+
+	return replaceFile(backupPath(path, 1), current, perm)
+}
+
+// backupPath returns the path of a backup generation
+func backupPath(path string, generation int) string {
+	return path + "." + strconv.Itoa(generation)
+}
+
+// ListBackups returns the backups of a file, newest first
+func ListBackups(path string) ([]Backup, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]Backup, 0, len(matches))
+	for _, match := range matches {
+		generation, err := strconv.Atoi(strings.TrimPrefix(match, path+"."))
+		if err != nil || generation < 1 {
+			continue
+		}
+		info, err := os.Stat(match)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		backups = append(backups, Backup{
+			Generation: generation,
+			Path:       match,
+			Size:       info.Size(),
+			ModTime:    info.ModTime().UTC(),
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Generation < backups[j].Generation
+	})
+
+	return backups, nil
+}
+
+// LoadBackup loads a backup generation of the configuration file
+func LoadBackup(path string, generation int) (*Config, error) {
+	if generation < 1 {
+		return nil, ErrBackupNotFound
+	}
+
+	backup, err := LoadConfig(backupPath(path, generation))
+	if os.IsNotExist(err) {
+		return nil, ErrBackupNotFound
+	}
+	return backup, err
+}
+
+// RestoreBackup replaces the users of the configuration by those of a
+// backup generation and saves it. The configuration that was replaced
+// becomes the newest backup, so a restore can be undone. A read-only
+// configuration becomes writable again. The principal is named in the
+// revision of the restore.
+func RestoreBackup(config *Config, path string, generation int, principal string) error {
+	backup, err := LoadBackup(path, generation)
+	if err != nil {
+		return err
+	}
+
+	config.restore(backup.Users, principal, fmt.Sprintf("restored from backup %d", generation))
+
+	return SaveConfig(config, path)
+}
+
+// Backups returns the backups of the file, newest first
+func (s *FileStore) Backups() ([]Backup, error) {
+	return ListBackups(s.path)
+}
+
+// LoadBackup loads a backup generation of the file
+func (s *FileStore) LoadBackup(generation int) (*Config, error) {
+	return LoadBackup(s.path, generation)
+}
+
+// backupStore returns the store of the configuration if it keeps backups
+func (c *Config) backupStore() (BackupStore, error) {
+	store, ok := c.Store().(BackupStore)
+	if !ok {
+		return nil, ErrNoBackups
+	}
+	return store, nil
+}
+
+// Backups returns the backups kept by the store, newest first. Stores that
+// keep none return ErrNoBackups.
+func (c *Config) Backups() ([]Backup, error) {
+	store, err := c.backupStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.Backups()
+}
+
+// LoadBackup loads a backup generation kept by the store
+func (c *Config) LoadBackup(generation int) (*Config, error) {
+	store, err := c.backupStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.LoadBackup(generation)
+}
+
+// RestoreBackup replaces the users by those of a backup generation kept by
+// the store and saves them, like the package function RestoreBackup
+func (c *Config) RestoreBackup(generation int, principal string) error {
+	backup, err := c.LoadBackup(generation)
+	if err != nil {
+		return err
+	}
+
+	c.restore(backup.Users, principal, fmt.Sprintf("restored from backup %d", generation))
+
+	return c.Save()
+}
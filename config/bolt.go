@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// usersBucket holds the JSON encoding of every user, keyed by user name
+var usersBucket = []byte("users")
+
+// BoltStore keeps every user as a separate record of an embedded bbolt
+// database, so a save only writes the users that changed
+type BoltStore struct {
+	path string
+	db   *bolt.DB
+	// stale holds the users last loaded in plaintext or sealed with an old
+	// key
+	stale []string
+}
+
+// OpenBoltStore opens or creates the database at path. Only one process
+// can open it at a time.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to open database %s: %w", ErrUnreadableConfig, path, err)
+	}
+	return &BoltStore{path: path, db: db}, nil
+}
+
+// Load reads all users. A database without the users bucket was never
+// saved to.
+func (s *BoltStore) Load() (map[string]*UserData, error) {
+	users := make(map[string]*UserData)
+	var stale []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		if bucket == nil {
+			return fmt.Errorf("no users in database %s: %w", s.path, os.ErrNotExist)
+		}
+		return bucket.ForEach(func(key, value []byte) error {
+			plain, restale, err := unseal(value)
+			if err != nil {
+				return fmt.Errorf("user %s: %w", key, err)
+			}
+			userData, err := decodeUser(string(key), plain)
+			if err != nil {
+				return err
+			}
+			users[string(key)] = userData
+			if restale {
+				stale = append(stale, string(key))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.stale = stale
+
+	return users, nil
+}
+
+// Save writes the changes in one transaction
+func (s *BoltStore) Save(changes map[string][]byte) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(usersBucket)
+		if err != nil {
+			return err
+		}
+		for user, data := range changes {
+			if data == nil {
+				err = bucket.Delete([]byte(user))
+			} else if data, err = encryption.Seal(data); err == nil {
+				err = bucket.Put([]byte(user), data)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write database %s: %w", s.path, err)
+	}
+
+	return nil
+}
+
+// Stale returns the users last loaded in plaintext or sealed with an old
+// key
+func (s *BoltStore) Stale() []string {
+	return s.stale
+}
+
+// Close closes the database
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -3,9 +3,10 @@ package config
 import (
 	"encoding/json"
 	"fmt"
-	"os"
+	"log"
 	"strings"
 	"sync"
+	"time"
 )
 
 // CronJob represents a scheduled job configuration
@@ -36,10 +37,61 @@ func (c *CronJob) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// Scope restricts an API key to one resource type, a set of HTTP methods
+// and optionally to data keys or job IDs starting with a prefix
+type Scope struct {
+	Resource string   `json:"resource"`
+	Methods  []string `json:"methods,omitempty"`
+	Prefix   string   `json:"prefix,omitempty"`
+}
+
+// APIKey represents a credential issued to a user. Only the hash of the
+// key is stored; the plaintext is shown once when the key is issued.
+// A key without scopes grants full access to the user's resources.
+type APIKey struct {
+	ID        string    `json:"id"`
+	Label     string    `json:"label,omitempty"`
+	Hash      string    `json:"hash,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// SigningKey is the signing secret of the key, encrypted with the server
+	// signing secret; empty if the key cannot sign requests
+	SigningKey string `json:"signing_key,omitempty"`
+	Scopes    []Scope   `json:"scopes,omitempty"`
+	// NotBefore and ExpiresAt limit when the key is accepted, nil means no
+	// limit
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// ReplacedBy is the ID of the key that replaced this one when it was
+	// rotated
+	ReplacedBy string `json:"replaced_by,omitempty"`
+	// ExpiryNotified is set once the owner was warned that the key expires
+	ExpiryNotified bool `json:"expiry_notified,omitempty"`
+}
+
+// OwnedKey is a copy of an API key together with the user or admin that
+// owns it
+type OwnedKey struct {
+	Owner string
+	Key   APIKey
+}
+
 // UserData represents a user's configuration and data
 type UserData struct {
 	Cron []*CronJob              `json:"cron"`
 	Data map[string]interface{} `json:"data"`
+	Keys []*APIKey              `json:"keys,omitempty"`
+	// ShareSalt is mixed into share link signatures; rotating it revokes
+	// all share links of the user
+	ShareSalt string `json:"share_salt,omitempty"`
+	// AllowedCIDRs restricts the client addresses the keys of the user may
+	// be used from; empty allows any address
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"`
+	// Encryption is set if the data values are encrypted with a key only
+	// the user's secret unwraps
+	Encryption *DataEncryption `json:"encryption,omitempty"`
+	// Members maps the users of an organization to their roles; only set
+	// for organization namespaces
+	Members map[string]string `json:"members,omitempty"`
 }
 
 // Config represents the entire server configuration
@@ -47,6 +99,22 @@ type Config struct {
 	mutex sync.RWMutex
 	Users map[string]*UserData `json:"users"`
 	Changed bool // Track if config has changed since last save
+	// readOnly is why the configuration must not be saved, empty if it may
+	readOnly string
+	// journal records changes between saves, nil if there is none
+	journal *Journal
+	// store is where the configuration is saved, dirty holds the users
+	// changed since the last save
+	store  Store
+	dirty  map[string]bool
+	saving sync.Mutex
+	// history records a revision with every save, nil if there is none;
+	// principals made the changes not saved yet and note describes them
+	history    *History
+	principals map[string]bool
+	note       string
+	// dataKeys holds the data keys unwrapped recently
+	dataKeys dataKeyCache
 }
 
 // NewConfig creates a new empty configuration
@@ -57,69 +125,206 @@ func NewConfig() *Config {
 	}
 }
 
-// LoadConfig loads the configuration from the given file path
+// LoadConfig loads the configuration from the JSON file at the given path.
+// A missing file returns the error of os.ReadFile; files that cannot be read
+// or are not a valid configuration return ErrUnreadableConfig or
+// ErrInvalidConfig.
 func LoadConfig(filePath string) (*Config, error) {
-	data, err := os.ReadFile(filePath)
+	return LoadStore(NewFileStore(filePath))
+}
+
+// LoadStore loads the configuration from a store, which it is saved to
+// from then on
+func LoadStore(store Store) (*Config, error) {
+	users, err := store.Load()
 	if err != nil {
 		return nil, err
 	}
 
-	var config Config
-	if err := json.Unmarshal(data, &config.Users); err != nil {
-		return nil, err
+	config := &Config{
+		Users: users,
+		// Initialize the Changed flag to false since we just loaded it
+		Changed: false,
+		store:   store,
 	}
+	// Seal what was loaded with a stale encryption with the next save
+	config.markStale(store)
+
+	return config, nil
+}
+
+// SetStore makes the configuration be saved to a store. All users are
+// saved with the next save, as the store may not have them.
+func (c *Config) SetStore(store Store) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.store = store
+	for user := range c.Users {
+		c.markDirty(user)
+	}
+	c.Changed = true
+}
 
-	// Initialize the Changed flag to false since we just loaded it
-	config.Changed = false
+// Store returns the store the configuration is saved to
+func (c *Config) Store() Store {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 
-	return &config, nil
+	return c.store
 }
 
-// SaveConfig saves the configuration to the given file path
+// SaveConfig saves the configuration to its store. A configuration without
+// a store is saved to the JSON file at filePath from then on.
 func SaveConfig(config *Config, filePath string) error {
-	config.mutex.RLock()
+	if config.Store() == nil {
+		config.SetStore(NewFileStore(filePath))
+	}
+	return config.Save()
+}
+
+// Save saves the users changed since the last save to the store and
+// records a revision in the history. The journal entries contained in the
+// store are dropped.
+func (c *Config) Save() error {
+	_, err := c.SaveChanged()
+	return err
+}
+
+// SaveChanged saves like Save and reports whether anything was saved, which
+// is false if nothing changed since the last save
+func (c *Config) SaveChanged() (bool, error) {
+	// Saves run one after the other, so older changes never overwrite newer
+	// ones
+	c.saving.Lock()
+	defer c.saving.Unlock()
+
+	c.mutex.Lock()
+	// Never overwrite the store with a configuration that must not be saved
+	if c.readOnly != "" {
+		c.mutex.Unlock()
+		return false, ErrReadOnly
+	}
 	// Only proceed with saving if config has changed
-	if !config.Changed {
-		config.mutex.RUnlock()
-		return nil
+	if !c.Changed {
+		c.mutex.Unlock()
+		return false, nil
+	}
+	if c.store == nil {
+		c.mutex.Unlock()
+		return false, ErrNoStore
 	}
 
-	data, err := json.MarshalIndent(config.Users, "", "  ")
-	config.mutex.RUnlock()
+	changes := make(map[string][]byte, len(c.dirty))
+	for user := range c.dirty {
+		userData, exists := c.Users[user]
+		if !exists {
+			changes[user] = nil
+			continue
+		}
+		data, err := json.Marshal(userData)
+		if err != nil {
+			c.mutex.Unlock()
+			return false, fmt.Errorf("failed to marshal user %s: %w", user, err)
+		}
+		changes[user] = data
+	}
+	dirty := c.dirty
+	c.dirty = nil
+	c.Changed = false
+	principals, note := c.takePrincipals(), c.note
+	c.note = ""
+
+	// Changes are journaled under the lock, so the journal up to here is
+	// contained in changes
+	store, journal, history := c.store, c.journal, c.history
+	var journaled int64
+	if journal != nil {
+		journaled = journal.Size()
+	}
+	c.mutex.Unlock()
 
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+	if err := store.Save(changes); err != nil {
+		// Try again with the next save
+		c.mutex.Lock()
+		for user := range dirty {
+			c.markDirty(user)
+		}
+		c.Changed = true
+		for _, principal := range principals {
+			c.attribute(principal)
+		}
+		if c.note == "" {
+			c.note = note
+		}
+		c.mutex.Unlock()
+		return false, err
 	}
 
-	// Create directory if it doesn't exist
-	dir := extractDirectory(filePath)
-	if dir != "" {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	if history != nil {
+		if err := history.commit(changes, principals, note); err != nil {
+			// The configuration is saved, only its revision is missing
+			log.Printf("Error recording configuration revision: %v", err)
 		}
 	}
 
-	err = os.WriteFile(filePath, data, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write config file %s: %w", filePath, err)
+	if journal != nil {
+		if err := journal.Compact(journaled); err != nil {
+			// Replaying the entries again on startup does no harm
+			log.Printf("Error compacting configuration journal: %v", err)
+		}
 	}
 
-	// Reset the Changed flag after successful save
-	config.mutex.Lock()
-	config.Changed = false
-	config.mutex.Unlock()
+	return true, nil
+}
 
-	return nil
+// markDirty remembers that a user must be saved. The mutex must be held
+// for writing.
+func (c *Config) markDirty(user string) {
+	if c.dirty == nil {
+		c.dirty = make(map[string]bool)
+	}
+	c.dirty[user] = true
 }
 
-// extractDirectory extracts the directory part from a file path
-func extractDirectory(filePath string) string {
-	for i := len(filePath) - 1; i >= 0; i-- {
-		if filePath[i] == '/' || filePath[i] == '\\' {
-			return filePath[:i]
+// ReplaceUsers replaces all users, e.g. with those of a backup. Keys
+// without a hash keep the credentials of the key with the same ID.
+func (c *Config) ReplaceUsers(users map[string]*UserData) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Redacted keys keep their credentials, so a configuration read from
+	// the API can be written back
+	for user, userData := range users {
+		current, exists := c.Users[user]
+		if userData == nil || !exists {
+			continue
+		}
+		for _, key := range userData.Keys {
+			if key == nil || key.Hash != "" {
+				continue
+			}
+			for _, currentKey := range current.Keys {
+				if currentKey.ID == key.ID {
+					key.Hash, key.SigningKey = currentKey.Hash, currentKey.SigningKey
+				}
+			}
 		}
 	}
-	return ""
+	c.replaceUsers(users)
+	c.changed(JournalEntry{Op: OpReplaceAll, Users: users})
+}
+
+// replaceUsers replaces all users. The mutex must be held for writing.
+func (c *Config) replaceUsers(users map[string]*UserData) {
+	// Users that are not replaced are deleted from the store
+	for user := range c.Users {
+		c.markDirty(user)
+	}
+	if users == nil {
+		users = make(map[string]*UserData)
+	}
+	c.Users = users
 }
 
 // GetUser returns the user data for the given user
@@ -140,7 +345,7 @@ func (c *Config) CreateUser(user string) *UserData {
 			Cron: make([]*CronJob, 0),
 			Data: make(map[string]interface{}),
 		}
-		c.Changed = true
+		c.userChanged(user)
 	}
 
 	return c.Users[user]
@@ -152,14 +357,28 @@ func (c *Config) DeleteUser(user string) bool {
 	defer c.mutex.Unlock()
 
 	if _, exists := c.Users[user]; exists {
-		delete(c.Users, user)
-		c.Changed = true
+		c.deleteUser(user)
+		c.changed(JournalEntry{Op: OpDeleteUser, User: user})
 		return true
 	}
 
 	return false
 }
 
+// deleteUser deletes a user and its organization memberships. The mutex
+// must be held for writing.
+func (c *Config) deleteUser(user string) {
+	delete(c.Users, user)
+
+	// The user is no longer a member of any organization
+	for namespace, userData := range c.Users {
+		if _, isMember := userData.Members[user]; isMember {
+			delete(userData.Members, user)
+			c.markDirty(namespace)
+		}
+	}
+}
+
 // GetAllUsers returns all user IDs
 func (c *Config) GetAllUsers() []string {
 	c.mutex.RLock()
@@ -210,6 +429,13 @@ func (c *Config) SetUserData(user, key string, value interface{}) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	c.userData(user).Data[key] = value
+	c.changed(JournalEntry{Op: OpSetData, User: user, Key: key, Value: value})
+}
+
+// userData returns the data of a user, creating the user if it does not
+// exist. The mutex must be held for writing.
+func (c *Config) userData(user string) *UserData {
 	userData, exists := c.Users[user]
 	if !exists {
 		userData = &UserData{
@@ -218,9 +444,11 @@ func (c *Config) SetUserData(user, key string, value interface{}) {
 		}
 		c.Users[user] = userData
 	}
+	if userData.Data == nil {
+		userData.Data = make(map[string]interface{})
+	}
 
-	userData.Data[key] = value
-	c.Changed = true
+	return userData
 }
 
 // DeleteUserData deletes data for a given user and key
@@ -235,7 +463,7 @@ func (c *Config) DeleteUserData(user, key string) bool {
 
 	if _, exists := userData.Data[key]; exists {
 		delete(userData.Data, key)
-		c.Changed = true
+		c.changed(JournalEntry{Op: OpDeleteData, User: user, Key: key})
 		return true
 	}
 
@@ -260,28 +488,33 @@ func (c *Config) AddUserJob(user string, job *CronJob) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	userData, exists := c.Users[user]
-	if !exists {
-		userData = &UserData{
-			Cron: make([]*CronJob, 0),
-			Data: make(map[string]interface{}),
-		}
-		c.Users[user] = userData
-	}
+	userData := c.userData(user)
 
 	// Check if job with this ID already exists
 	for i, existingJob := range userData.Cron {
 		if existingJob.ID == job.ID {
 			// Replace existing job
 			userData.Cron[i] = job
-			c.Changed = true
+			c.userChanged(user)
 			return
 		}
 	}
 
 	// Add new job
 	userData.Cron = append(userData.Cron, job)
-	c.Changed = true
+	c.userChanged(user)
+}
+
+// SetUserCron replaces all cron jobs of a user
+func (c *Config) SetUserCron(user string, jobs []*CronJob) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if jobs == nil {
+		jobs = make([]*CronJob, 0)
+	}
+	c.userData(user).Cron = jobs
+	c.userChanged(user)
 }
 
 // DeleteUserJob deletes a cron job for a given user
@@ -298,7 +531,7 @@ func (c *Config) DeleteUserJob(user, jobID string) bool {
 		if job.ID == jobID {
 			// Remove job
 			userData.Cron = append(userData.Cron[:i], userData.Cron[i+1:]...)
-			c.Changed = true
+			c.userChanged(user)
 			return true
 		}
 	}
@@ -306,6 +539,21 @@ func (c *Config) DeleteUserJob(user, jobID string) bool {
 	return false
 }
 
+// AllUserJobs returns copies of the cron jobs of all users
+func (c *Config) AllUserJobs() map[string][]CronJob {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	jobs := make(map[string][]CronJob, len(c.Users))
+	for user, userData := range c.Users {
+		for _, job := range userData.Cron {
+			jobs[user] = append(jobs[user], *job)
+		}
+	}
+
+	return jobs
+}
+
 // GetUserJob returns a specific cron job for a given user
 func (c *Config) GetUserJob(user, jobID string) (*CronJob, bool) {
 	c.mutex.RLock()
@@ -339,7 +587,7 @@ func (c *Config) SetUserJobActive(user, jobID string, active bool) bool {
 		if job.ID == jobID {
 			if job.Active != active {
 				job.Active = active
-				c.Changed = true
+				c.userChanged(user)
 			}
 			return true
 		}
@@ -367,8 +615,336 @@ func (c *Config) SetAllUserJobsActive(user string, active bool) bool {
 	}
 
 	if changed {
-		c.Changed = true
+		c.userChanged(user)
+	}
+
+	return true
+}
+
+
+// GetUserAPIKeys returns all API keys for a given user
+func (c *Config) GetUserAPIKeys(user string) []*APIKey {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	userData, exists := c.Users[user]
+	if !exists {
+		return nil
+	}
+
+	keys := make([]*APIKey, len(userData.Keys))
+	copy(keys, userData.Keys)
+
+	return keys
+}
+
+// AddUserAPIKey adds an API key for an existing user
+func (c *Config) AddUserAPIKey(user string, key *APIKey) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	userData, exists := c.Users[user]
+	if !exists {
+		return false
+	}
+
+	userData.Keys = append(userData.Keys, key)
+	c.userChanged(user)
+
+	return true
+}
+
+// SetUserAPIKeyLabel changes the label of an API key
+func (c *Config) SetUserAPIKeyLabel(user, keyID, label string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	userData, exists := c.Users[user]
+	if !exists {
+		return false
+	}
+
+	for _, key := range userData.Keys {
+		if key.ID == keyID {
+			if key.Label != label {
+				key.Label = label
+				c.userChanged(user)
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// UpdateUserAPIKey calls fn with an API key of a user to change it
+func (c *Config) UpdateUserAPIKey(user, keyID string, fn func(key *APIKey)) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	userData, exists := c.Users[user]
+	if !exists {
+		return false
+	}
+
+	for _, key := range userData.Keys {
+		if key.ID == keyID {
+			fn(key)
+			c.userChanged(user)
+			return true
+		}
+	}
+
+	return false
+}
+
+// RotateUserAPIKey adds a new API key for a user and lets the old one
+// expire at graceEnd, unless it expires earlier anyway
+func (c *Config) RotateUserAPIKey(user, keyID string, newKey *APIKey, graceEnd time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	userData, exists := c.Users[user]
+	if !exists {
+		return false
+	}
+
+	keys, rotated := rotateKey(userData.Keys, keyID, newKey, graceEnd)
+	if !rotated {
+		return false
+	}
+	userData.Keys = keys
+	c.userChanged(user)
+
+	return true
+}
+
+// rotateKey replaces the key with the ID by newKey in keys. The old key
+// stays valid until graceEnd, so clients can switch without downtime.
+func rotateKey(keys []*APIKey, keyID string, newKey *APIKey, graceEnd time.Time) ([]*APIKey, bool) {
+	for _, key := range keys {
+		if key.ID != keyID {
+			continue
+		}
+		if key.ExpiresAt == nil || graceEnd.Before(*key.ExpiresAt) {
+			key.ExpiresAt = &graceEnd
+		}
+		key.ReplacedBy = newKey.ID
+		return append(keys, newKey), true
+	}
+
+	return keys, false
+}
+
+// AllUserAPIKeys returns copies of the API keys of all users
+func (c *Config) AllUserAPIKeys() []OwnedKey {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var keys []OwnedKey
+	for user, userData := range c.Users {
+		for _, key := range userData.Keys {
+			keys = append(keys, OwnedKey{Owner: user, Key: *key})
+		}
+	}
+
+	return keys
+}
+
+// DeleteUserAPIKey revokes an API key
+func (c *Config) DeleteUserAPIKey(user, keyID string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	userData, exists := c.Users[user]
+	if !exists {
+		return false
+	}
+
+	for i, key := range userData.Keys {
+		if key.ID == keyID {
+			userData.Keys = append(userData.Keys[:i], userData.Keys[i+1:]...)
+			c.userChanged(user)
+			return true
+		}
+	}
+
+	return false
+}
+
+// FindAPIKey looks up an API key by its ID across all users and returns
+// the owning user together with the key
+func (c *Config) FindAPIKey(keyID string) (string, *APIKey, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for user, userData := range c.Users {
+		for _, key := range userData.Keys {
+			if key.ID == keyID {
+				return user, key, true
+			}
+		}
+	}
+
+	return "", nil, false
+}
+
+// GetUserShareSalt returns the share link salt of a user
+func (c *Config) GetUserShareSalt(user string) (string, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	userData, exists := c.Users[user]
+	if !exists {
+		return "", false
 	}
 
+	return userData.ShareSalt, true
+}
+
+// SetUserShareSalt sets the share link salt of a user
+func (c *Config) SetUserShareSalt(user, salt string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	userData, exists := c.Users[user]
+	if !exists {
+		return false
+	}
+
+	userData.ShareSalt = salt
+	c.userChanged(user)
+
 	return true
 }
+
+// GetUserAllowedCIDRs returns the address allowlist of a user
+func (c *Config) GetUserAllowedCIDRs(user string) ([]string, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	userData, exists := c.Users[user]
+	if !exists {
+		return nil, false
+	}
+
+	return append([]string(nil), userData.AllowedCIDRs...), true
+}
+
+// SetUserAllowedCIDRs replaces the address allowlist of a user
+func (c *Config) SetUserAllowedCIDRs(user string, cidrs []string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	userData, exists := c.Users[user]
+	if !exists {
+		return false
+	}
+
+	userData.AllowedCIDRs = cidrs
+	c.userChanged(user)
+
+	return true
+}
+
+// SnapshotUser returns the JSON encoding of a user, nil if it does not
+// exist. It is taken under the lock, so it can be hashed safely.
+func (c *Config) SnapshotUser(user string) []byte {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	userData, exists := c.Users[user]
+	if !exists {
+		return nil
+	}
+
+	data, _ := json.Marshal(userData)
+	return data
+}
+
+// SnapshotUserJob returns the JSON encoding of a cron job, nil if it does
+// not exist
+func (c *Config) SnapshotUserJob(user, jobID string) []byte {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	userData, exists := c.Users[user]
+	if !exists {
+		return nil
+	}
+
+	for _, job := range userData.Cron {
+		if job.ID == jobID {
+			data, _ := json.Marshal(job)
+			return data
+		}
+	}
+
+	return nil
+}
+
+// SnapshotUserData returns the JSON encoding of a data value, nil if it
+// does not exist
+func (c *Config) SnapshotUserData(user, key string) []byte {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	userData, exists := c.Users[user]
+	if !exists {
+		return nil
+	}
+
+	value, exists := userData.Data[key]
+	if !exists {
+		return nil
+	}
+
+	data, _ := json.Marshal(value)
+	return data
+}
+
+// SnapshotUsers returns the JSON encoding of all users
+func (c *Config) SnapshotUsers() []byte {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	data, _ := json.Marshal(c.Users)
+	return data
+}
+
+// RedactedUsers returns a copy of all users without the credentials of
+// their keys, see RedactUsers
+func (c *Config) RedactedUsers() map[string]*UserData {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return RedactUsers(c.Users)
+}
+
+// RedactUsers returns a copy of users without the hashes and signing keys
+// of their API keys, for responses. Anyone holding them could authenticate
+// or sign requests as the users.
+func RedactUsers(users map[string]*UserData) map[string]*UserData {
+	redacted := make(map[string]*UserData, len(users))
+	for user, userData := range users {
+		if userData == nil {
+			redacted[user] = nil
+			continue
+		}
+		copied := *userData
+		copied.Keys = make([]*APIKey, len(userData.Keys))
+		for i, key := range userData.Keys {
+			if key == nil {
+				continue
+			}
+			copiedKey := *key
+			copiedKey.Hash, copiedKey.SigningKey = "", ""
+			copied.Keys[i] = &copiedKey
+		}
+		if userData.Keys == nil {
+			copied.Keys = nil
+		}
+		redacted[user] = &copied
+	}
+	return redacted
+}
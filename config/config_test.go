@@ -1,8 +1,13 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewConfig(t *testing.T) {
@@ -148,3 +153,756 @@ func TestSaveAndLoadConfig(t *testing.T) {
 		t.Error("LoadConfig() did not load nested data value correctly")
 	}
 }
+
+func TestSaveConfigBackups(t *testing.T) {
+	SetMaxBackups(2)
+	defer SetMaxBackups(DefaultMaxBackups)
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg := NewConfig()
+
+	// Each save keeps the previous generation
+	for _, user := range []string{"a", "b", "c", "d"} {
+		cfg.CreateUser(user)
+		if err := SaveConfig(cfg, path); err != nil {
+			t.Fatalf("SaveConfig() failed: %v", err)
+		}
+	}
+
+	backups, err := ListBackups(path)
+	if err != nil {
+		t.Fatalf("ListBackups() failed: %v", err)
+	}
+	if len(backups) != 2 || backups[0].Generation != 1 || backups[1].Generation != 2 {
+		t.Fatalf("ListBackups() = %+v, expected generations 1 and 2", backups)
+	}
+
+	backup, err := LoadBackup(path, 2)
+	if err != nil || len(backup.Users) != 2 {
+		t.Fatalf("LoadBackup() returned %v users and error %v, expected 2 users", backup, err)
+	}
+	if _, err := LoadBackup(path, 3); err != ErrBackupNotFound {
+		t.Errorf("LoadBackup() returned %v for a dropped generation, expected %v", err, ErrBackupNotFound)
+	}
+
+	// No temporary files are left behind
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 3 {
+		t.Errorf("SaveConfig() left %d files, expected the config and 2 backups", len(entries))
+	}
+
+	// Restoring makes the replaced configuration the newest backup
+	if err := RestoreBackup(cfg, path, 2, SystemPrincipal); err != nil {
+		t.Fatalf("RestoreBackup() failed: %v", err)
+	}
+	if len(cfg.Users) != 2 || cfg.GetUser("c") != nil {
+		t.Errorf("RestoreBackup() left users %v", cfg.GetAllUsers())
+	}
+	loaded, err := LoadConfig(path)
+	if err != nil || len(loaded.Users) != 2 {
+		t.Errorf("RestoreBackup() did not save the restored configuration")
+	}
+	if undo, _ := LoadBackup(path, 1); undo == nil || len(undo.Users) != 4 {
+		t.Errorf("RestoreBackup() did not keep the replaced configuration as backup 1")
+	}
+}
+
+func TestLoadCorruptConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	// A missing file is not corrupt
+	if _, err := LoadConfig(path); !os.IsNotExist(err) {
+		t.Errorf("LoadConfig() returned %v for a missing file, expected a not exist error", err)
+	}
+
+	for _, content := range []string{"", `{"alice": {"data": {"k": "v"`, "null", `{"alice": null}`} {
+		os.WriteFile(path, []byte(content), 0644)
+		if _, err := LoadConfig(path); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("LoadConfig() returned %v for %q, expected %v", err, content, ErrInvalidConfig)
+		}
+	}
+
+	// The broken file is moved aside
+	quarantined, err := Quarantine(path, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Quarantine() failed: %v", err)
+	}
+	if quarantined != path+".corrupt-20240102T030405Z" {
+		t.Errorf("Quarantine() moved the file to %s", quarantined)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Quarantine() left the file in place")
+	}
+	if list, _ := ListQuarantined(path); len(list) != 1 || list[0] != quarantined {
+		t.Errorf("ListQuarantined() = %v, expected [%s]", list, quarantined)
+	}
+
+	// A read-only configuration is never saved
+	cfg := NewConfig()
+	cfg.CreateUser("alice")
+	cfg.SetReadOnly("corrupt")
+	if err := SaveConfig(cfg, path); err != ErrReadOnly {
+		t.Errorf("SaveConfig() returned %v for a read-only configuration, expected %v", err, ErrReadOnly)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("SaveConfig() wrote a read-only configuration")
+	}
+
+	// Restoring a backup makes it writable again
+	os.WriteFile(path+".1", []byte(`{"bob": {}}`), 0644)
+	if err := RestoreBackup(cfg, path, 1, SystemPrincipal); err != nil {
+		t.Fatalf("RestoreBackup() failed: %v", err)
+	}
+	if _, readOnly := cfg.ReadOnly(); readOnly || cfg.GetUser("bob") == nil {
+		t.Error("RestoreBackup() did not restore a writable configuration")
+	}
+}
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	journalPath := path + ".journal"
+
+	journal, err := OpenJournal(journalPath)
+	if err != nil {
+		t.Fatalf("OpenJournal() failed: %v", err)
+	}
+	cfg := NewConfig()
+	cfg.SetJournal(journal)
+
+	cfg.CreateUser("alice")
+	cfg.CreateUser("bob")
+	cfg.SetUserData("alice", "counter", float64(1))
+	cfg.SetUserData("alice", "temp", "x")
+	cfg.DeleteUserData("alice", "temp")
+	cfg.AddUserJob("alice", &CronJob{ID: "job1", Cron: "* * * * *", URL: "http://example.com", Active: true})
+	cfg.SetUserJobActive("alice", "job1", false)
+	cfg.SetUserCron("bob", []*CronJob{{ID: "job2", Cron: "* * * * *", URL: "http://example.com"}})
+	cfg.CreateOrg("team")
+	cfg.SetOrgMember("team", "bob", "member")
+	cfg.DeleteUser("bob")
+	journal.Close()
+
+	// A crash before the next save loses nothing
+	journal, err = OpenJournal(journalPath)
+	if err != nil {
+		t.Fatalf("OpenJournal() failed: %v", err)
+	}
+	replayed := NewConfig()
+	if n, err := journal.Replay(replayed); err != nil || n != 11 {
+		t.Fatalf("Replay() returned %d, %v, expected 11 entries", n, err)
+	}
+	if string(replayed.SnapshotUsers()) != string(cfg.SnapshotUsers()) {
+		t.Errorf("Replay() = %s, expected %s", replayed.SnapshotUsers(), cfg.SnapshotUsers())
+	}
+
+	// An entry torn by a crash is discarded
+	size := journal.Size()
+	f, _ := os.OpenFile(journalPath, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"op":"set_data","user":"alice","key":"torn"`)
+	f.Close()
+	journal.Close()
+	journal, _ = OpenJournal(journalPath)
+	if n, err := journal.Replay(NewConfig()); err != nil || n != 11 || journal.Size() != size {
+		t.Errorf("Replay() returned %d, %v with a torn entry, expected 11 entries", n, err)
+	}
+
+	// Saving drops the journaled changes
+	replayed.SetJournal(journal)
+	if err := SaveConfig(replayed, path); err != nil {
+		t.Fatalf("SaveConfig() failed: %v", err)
+	}
+	if journal.Size() != 0 {
+		t.Errorf("SaveConfig() left %d bytes in the journal", journal.Size())
+	}
+	replayed.SetUserData("alice", "counter", float64(2))
+	journal.Close()
+
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	journal, _ = OpenJournal(journalPath)
+	defer journal.Close()
+	if n, err := journal.Replay(loaded); err != nil || n != 1 {
+		t.Errorf("Replay() returned %d, %v after a save, expected 1 entry", n, err)
+	}
+	if value, _ := loaded.GetUserData("alice", "counter"); value != float64(2) {
+		t.Errorf("Replay() restored counter %v, expected 2", value)
+	}
+}
+
+func TestJournalSyncFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	journal, err := OpenJournal(path + ".journal")
+	if err != nil {
+		t.Fatalf("OpenJournal() failed: %v", err)
+	}
+	cfg := NewConfig()
+	cfg.SetStore(NewFileStore(path))
+	cfg.SetJournal(journal)
+
+	// Changes are only written by SyncJournal
+	cfg.SetUserData("alice", "counter", float64(1))
+	if data, _ := os.ReadFile(path + ".journal"); len(data) != 0 {
+		t.Errorf("SetUserData() wrote %q to the journal before SyncJournal()", data)
+	}
+	if err := cfg.SyncJournal(); err != nil {
+		t.Fatalf("SyncJournal() failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path + ".journal"); len(data) == 0 {
+		t.Error("SyncJournal() wrote nothing to the journal")
+	}
+
+	// A change that cannot be written is reported, and kept for the next save
+	journal.file.Close()
+	cfg.SetUserData("alice", "counter", float64(2))
+	if err := cfg.SyncJournal(); err == nil {
+		t.Error("SyncJournal() succeeded with a closed journal")
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	if value, _ := loaded.GetUserData("alice", "counter"); value != float64(2) {
+		t.Errorf("Save() stored counter %v, expected 2", value)
+	}
+	if err := cfg.SyncJournal(); err != nil {
+		t.Errorf("SyncJournal() failed after the save: %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	cfg := NewConfig()
+	cfg.CreateUser("alice")
+	cfg.CreateUser("bob")
+	if err := SaveConfig(cfg, path); err != nil {
+		t.Fatalf("SaveConfig() failed: %v", err)
+	}
+
+	// Saving is not a modification
+	if modified, err := cfg.StoreModified(); err != nil || modified {
+		t.Errorf("StoreModified() returned %v, %v after saving, expected false", modified, err)
+	}
+
+	// Edit the file behind the back of the configuration
+	os.WriteFile(path, []byte(`{"alice": {"cron": [], "data": {"k": "v"}}, "carol": {"cron": [], "data": {}}}`), 0644)
+	if modified, err := cfg.StoreModified(); err != nil || !modified {
+		t.Errorf("StoreModified() returned %v, %v after editing, expected true", modified, err)
+	}
+
+	diff, err := cfg.Reload(SystemPrincipal)
+	if err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	if len(diff.Added) != 1 || diff.Added[0] != "carol" || len(diff.Removed) != 1 || diff.Removed[0] != "bob" || len(diff.Changed) != 1 || diff.Changed[0] != "alice" {
+		t.Errorf("Reload() = %+v, expected carol added, bob removed and alice changed", diff)
+	}
+	if value, _ := cfg.GetUserData("alice", "k"); value != "v" || cfg.Changed {
+		t.Errorf("Reload() did not replace the users")
+	}
+	if modified, _ := cfg.StoreModified(); modified {
+		t.Error("StoreModified() returned true after reloading")
+	}
+
+	// Unsaved changes survive a reload and are saved over the edited file
+	journal, err := OpenJournal(path + ".journal")
+	if err != nil {
+		t.Fatalf("OpenJournal() failed: %v", err)
+	}
+	defer journal.Close()
+	cfg.SetJournal(journal)
+	cfg.SetUserData("carol", "unsaved", "yes")
+	os.WriteFile(path, []byte(`{"version": 1, "users": {"alice": {"cron": [], "data": {"k": "edited"}}, "carol": {"cron": [], "data": {}}}}`), 0644)
+	if _, err := cfg.Reload(SystemPrincipal); err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	if value, _ := cfg.GetUserData("carol", "unsaved"); value != "yes" || !cfg.Changed {
+		t.Error("Reload() discarded an unsaved change")
+	}
+	if journal.Size() == 0 {
+		t.Error("Reload() dropped the journal entry of an unsaved change")
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	edited, _ := loaded.GetUserData("alice", "k")
+	unsaved, _ := loaded.GetUserData("carol", "unsaved")
+	if edited != "edited" || unsaved != "yes" {
+		t.Errorf("Save() after Reload() stored k %v and unsaved %v, expected edited and yes", edited, unsaved)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	dir := t.TempDir()
+	history, err := OpenHistory(filepath.Join(dir, "history"), 3)
+	if err != nil {
+		t.Fatalf("OpenHistory() failed: %v", err)
+	}
+
+	cfg := NewConfig()
+	cfg.SetStore(NewFileStore(filepath.Join(dir, "config.json")))
+	cfg.CreateUser("alice")
+	cfg.AddUserJob("alice", &CronJob{ID: "job1", Cron: "* * * * *", URL: "http://example.com"})
+	if err := cfg.SetHistory(history); err != nil {
+		t.Fatalf("SetHistory() failed: %v", err)
+	}
+	cfg.Attribute("admin:root")
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	// The startup revision has the users already, so the save adds none
+	revisions := history.Revisions()
+	if len(revisions) != 1 || revisions[0].Number != 1 || revisions[0].Principals[0] != SystemPrincipal {
+		t.Fatalf("Revisions() = %+v, expected revision 1 by %s", revisions, SystemPrincipal)
+	}
+
+	// A bad replacement removes every job
+	cfg.ReplaceUsers(map[string]*UserData{"bob": {Cron: []*CronJob{}, Data: map[string]interface{}{"k": "v"}}})
+	cfg.Attribute("admin:root")
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	revisions = history.Revisions()
+	if len(revisions) != 2 || revisions[0].Principals[0] != "admin:root" || revisions[0].Summary != "users: 1 added, 1 removed; jobs: 1 removed; data: 1 added" {
+		t.Fatalf("Revisions() = %+v, expected revision 2 by admin:root", revisions)
+	}
+
+	diff, err := history.Diff(1, 2)
+	if err != nil {
+		t.Fatalf("Diff() failed: %v", err)
+	}
+	if len(diff.Jobs.Removed) != 1 || diff.Jobs.Removed[0] != "alice/job1" || len(diff.Data.Added) != 1 || diff.Data.Added[0] != "bob/k" {
+		t.Errorf("Diff() = %+v, expected alice/job1 removed and bob/k added", diff)
+	}
+	if _, err := history.Diff(1, 9); !errors.Is(err, ErrRevisionNotFound) {
+		t.Errorf("Diff() returned %v for a missing revision, expected %v", err, ErrRevisionNotFound)
+	}
+
+	// Rolling back is a revision of its own
+	if err := cfg.Rollback(1, "admin:root"); err != nil {
+		t.Fatalf("Rollback() failed: %v", err)
+	}
+	if jobs := cfg.GetUserJobs("alice"); len(jobs) != 1 || cfg.GetUser("bob") != nil {
+		t.Errorf("Rollback() left users %v", cfg.GetAllUsers())
+	}
+	if latest := history.Revisions()[0]; latest.Number != 3 || latest.Summary != "rollback to revision 1: users: 1 added, 1 removed; jobs: 1 added; data: 1 removed" {
+		t.Errorf("Rollback() recorded %+v, expected revision 3", latest)
+	}
+
+	// Old revisions and the objects only they refer to are dropped
+	cfg.SetUserData("alice", "counter", float64(1))
+	cfg.Save()
+	if revisions := history.Revisions(); len(revisions) != 3 || revisions[2].Number != 2 {
+		t.Errorf("Revisions() = %+v, expected revisions 2 to 4", revisions)
+	}
+	objects, _ := os.ReadDir(filepath.Join(dir, "history", "objects"))
+	if len(objects) != 3 {
+		t.Errorf("Found %d objects, expected 3 referred to by revisions 2 to 4", len(objects))
+	}
+
+	// Revisions survive reopening the history
+	reopened, err := OpenHistory(filepath.Join(dir, "history"), 3)
+	if err != nil {
+		t.Fatalf("OpenHistory() failed: %v", err)
+	}
+	users, err := reopened.Users(4)
+	if err != nil || users["alice"] == nil || users["alice"].Data["counter"] != float64(1) {
+		t.Errorf("Users() returned %v, %v for revision 4, expected alice with counter 1", users, err)
+	}
+}
+
+func TestUserAPIKeys(t *testing.T) {
+	cfg := NewConfig()
+	user := "testuser"
+	key := &APIKey{ID: "abc123", Label: "ci", Hash: "hash"}
+
+	// Unknown user
+	if cfg.AddUserAPIKey(user, key) {
+		t.Error("AddUserAPIKey() returned true for non-existent user")
+	}
+
+	cfg.CreateUser(user)
+	if !cfg.AddUserAPIKey(user, key) {
+		t.Fatal("AddUserAPIKey() returned false for existing user")
+	}
+
+	if owner, found, ok := cfg.FindAPIKey("abc123"); !ok || owner != user || found != key {
+		t.Error("FindAPIKey() did not find the key")
+	}
+
+	if !cfg.SetUserAPIKeyLabel(user, "abc123", "deploy") || cfg.GetUserAPIKeys(user)[0].Label != "deploy" {
+		t.Error("SetUserAPIKeyLabel() did not change the label")
+	}
+
+	if !cfg.DeleteUserAPIKey(user, "abc123") {
+		t.Error("DeleteUserAPIKey() returned false for existing key")
+	}
+	if _, _, ok := cfg.FindAPIKey("abc123"); ok {
+		t.Error("DeleteUserAPIKey() did not remove the key")
+	}
+}
+
+func TestRedactUsers(t *testing.T) {
+	cfg := NewConfig()
+	cfg.CreateUser("alice")
+	cfg.AddUserAPIKey("alice", &APIKey{ID: "abc123", Label: "ci", Hash: "hash", SigningKey: "sealed"})
+
+	// Responses hold neither hashes nor signing keys
+	redacted := cfg.RedactedUsers()
+	if key := redacted["alice"].Keys[0]; key.ID != "abc123" || key.Hash != "" || key.SigningKey != "" {
+		t.Errorf("RedactedUsers() returned key %+v, expected no credentials", key)
+	}
+	if key := cfg.GetUserAPIKeys("alice")[0]; key.Hash != "hash" || key.SigningKey != "sealed" {
+		t.Error("RedactedUsers() changed the stored key")
+	}
+	export, err := cfg.Export(FormatJSON)
+	if err != nil || strings.Contains(string(export), "hash") || strings.Contains(string(export), "sealed") {
+		t.Errorf("Export() = %s, %v, expected no credentials", export, err)
+	}
+
+	// Writing a redacted configuration back keeps the credentials
+	cfg.ReplaceUsers(redacted)
+	if key := cfg.GetUserAPIKeys("alice")[0]; key.Hash != "hash" || key.SigningKey != "sealed" {
+		t.Errorf("ReplaceUsers() left key %+v, expected the credentials of the replaced key", key)
+	}
+}
+
+func TestRotateUserAPIKey(t *testing.T) {
+	cfg := NewConfig()
+	cfg.CreateUser("testuser")
+	now := time.Now()
+	later := now.Add(48 * time.Hour)
+	cfg.AddUserAPIKey("testuser", &APIKey{ID: "old", Hash: "hash", ExpiresAt: &later})
+
+	if cfg.RotateUserAPIKey("testuser", "missing", &APIKey{ID: "new"}, now) {
+		t.Error("RotateUserAPIKey() returned true for non-existent key")
+	}
+
+	graceEnd := now.Add(time.Hour)
+	if !cfg.RotateUserAPIKey("testuser", "old", &APIKey{ID: "new", Hash: "hash2"}, graceEnd) {
+		t.Fatal("RotateUserAPIKey() returned false for existing key")
+	}
+
+	keys := cfg.GetUserAPIKeys("testuser")
+	if len(keys) != 2 || keys[1].ID != "new" {
+		t.Fatalf("RotateUserAPIKey() did not add the new key")
+	}
+	if keys[0].ReplacedBy != "new" || !keys[0].ExpiresAt.Equal(graceEnd) {
+		t.Errorf("RotateUserAPIKey() set replaced_by %q and expires_at %v on the old key", keys[0].ReplacedBy, keys[0].ExpiresAt)
+	}
+
+	// A grace period beyond the expiry does not extend the key
+	cfg.AddUserAPIKey("testuser", &APIKey{ID: "short", Hash: "hash3", ExpiresAt: &graceEnd})
+	cfg.RotateUserAPIKey("testuser", "short", &APIKey{ID: "next"}, later)
+	if _, key, _ := cfg.FindAPIKey("short"); !key.ExpiresAt.Equal(graceEnd) {
+		t.Errorf("RotateUserAPIKey() extended the old key to %v", key.ExpiresAt)
+	}
+}
+
+func TestSaveAndLoadAdmins(t *testing.T) {
+	filePath := t.TempDir() + "/admins.json"
+
+	admins := NewAdmins()
+	if _, created := admins.CreateAdmin("alice", "owner"); !created {
+		t.Fatal("CreateAdmin() returned false for new admin")
+	}
+	if _, created := admins.CreateAdmin("alice", "viewer"); created {
+		t.Error("CreateAdmin() returned true for existing admin")
+	}
+	admins.AddAdminKey("alice", &APIKey{ID: "abc123", Hash: "hash"})
+
+	if err := SaveAdmins(admins, filePath); err != nil {
+		t.Fatalf("SaveAdmins() failed: %v", err)
+	}
+
+	loaded, err := LoadAdmins(filePath)
+	if err != nil {
+		t.Fatalf("LoadAdmins() failed: %v", err)
+	}
+
+	name, admin, key, found := loaded.FindAdminKey("abc123")
+	if !found || name != "alice" || admin.Role != "owner" || key.Hash != "hash" {
+		t.Error("LoadAdmins() did not load the admin and its key")
+	}
+
+	// Save writes to the file path set
+	if err := loaded.Save(); !errors.Is(err, ErrNoStore) {
+		t.Errorf("Save() error = %v without a file path, expected ErrNoStore", err)
+	}
+	loaded.SetFilePath(filePath)
+	loaded.SetAdminRole("alice", "viewer")
+	if err := loaded.Save(); err != nil || loaded.Changed {
+		t.Fatalf("Save() returned %v, Changed = %v", err, loaded.Changed)
+	}
+	if reloaded, err := LoadAdmins(filePath); err != nil || reloaded.GetAdmin("alice").Role != "viewer" {
+		t.Errorf("Save() did not write the changed role: %v", err)
+	}
+}
+
+func TestOrgs(t *testing.T) {
+	filePath := t.TempDir() + "/config.json"
+
+	cfg := NewConfig()
+	cfg.CreateUser("alice")
+	if !cfg.CreateOrg("team") {
+		t.Fatal("CreateOrg() returned false for new org")
+	}
+	if cfg.CreateOrg("@team") {
+		t.Error("CreateOrg() returned true for existing org")
+	}
+	if !cfg.SetOrgMember("team", "alice", "owner") {
+		t.Fatal("SetOrgMember() returned false for existing org")
+	}
+	cfg.SetUserData("@team", "shared", "value")
+
+	if orgs := cfg.GetAllOrgs(); len(orgs) != 1 || orgs[0] != "team" {
+		t.Errorf("GetAllOrgs() = %v, expected [team]", orgs)
+	}
+
+	// Organizations are persisted with their members
+	if err := SaveConfig(cfg, filePath); err != nil {
+		t.Fatalf("SaveConfig() failed: %v", err)
+	}
+	loaded, err := LoadConfig(filePath)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	if role, isMember := loaded.GetMemberRole("team", "alice"); !isMember || role != "owner" {
+		t.Errorf("GetMemberRole() = %s, %v after load, expected owner, true", role, isMember)
+	}
+	if value, exists := loaded.GetUserData("@team", "shared"); !exists || value != "value" {
+		t.Error("LoadConfig() did not load the org data")
+	}
+
+	// Deleting a user ends their memberships
+	loaded.DeleteUser("alice")
+	if _, isMember := loaded.GetMemberRole("team", "alice"); isMember {
+		t.Error("DeleteUser() did not remove the membership")
+	}
+	if loaded.DeleteOrgMember("team", "alice") {
+		t.Error("DeleteOrgMember() returned true for removed member")
+	}
+}
+
+func TestDataEncryption(t *testing.T) {
+	filePath := t.TempDir() + "/config.json"
+
+	cfg := NewConfig()
+	cfg.CreateUser("alice")
+	cfg.SetUserData("alice", "existing", "plain value")
+
+	if err := cfg.SetDataSecret("alice", "", "short"); !errors.Is(err, ErrWeakSecret) {
+		t.Errorf("SetDataSecret() error = %v, expected ErrWeakSecret", err)
+	}
+	if err := cfg.SetDataSecret("alice", "", "first secret"); err != nil {
+		t.Fatalf("SetDataSecret() failed: %v", err)
+	}
+	if !cfg.DataEncrypted("alice") {
+		t.Fatal("DataEncrypted() returned false after SetDataSecret()")
+	}
+
+	// Existing values are encrypted, new values are written encrypted
+	key, err := cfg.UnlockData("alice", "first secret")
+	if err != nil {
+		t.Fatalf("UnlockData() failed: %v", err)
+	}
+	if err := cfg.WriteUserData("alice", "new", map[string]interface{}{"n": 1.0}, key); err != nil {
+		t.Fatalf("WriteUserData() failed: %v", err)
+	}
+	// Writes without a data key, like those of share links, cannot put a
+	// plaintext value into the encrypted data
+	if err := cfg.WriteUserData("alice", "shared", "plain", nil); !errors.Is(err, ErrEncryptionChanged) {
+		t.Errorf("WriteUserData() error = %v without a data key, expected ErrEncryptionChanged", err)
+	}
+	if err := SaveConfig(cfg, filePath); err != nil {
+		t.Fatalf("SaveConfig() failed: %v", err)
+	}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Failed to read config file: %v", err)
+	}
+	if strings.Contains(string(content), "plain value") {
+		t.Error("Config file contains a plaintext data value")
+	}
+
+	loaded, err := LoadConfig(filePath)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	if _, err := loaded.UnlockData("alice", ""); !errors.Is(err, ErrSecretRequired) {
+		t.Errorf("UnlockData() error = %v, expected ErrSecretRequired", err)
+	}
+	if _, err := loaded.UnlockData("alice", "wrong secret"); !errors.Is(err, ErrWrongSecret) {
+		t.Errorf("UnlockData() error = %v, expected ErrWrongSecret", err)
+	}
+	key, err = loaded.UnlockData("alice", "first secret")
+	if err != nil {
+		t.Fatalf("UnlockData() failed after load: %v", err)
+	}
+	if value, exists, err := loaded.ReadUserData("alice", "existing", key); err != nil || !exists || value != "plain value" {
+		t.Errorf("ReadUserData() = %v, %v, %v, expected plain value", value, exists, err)
+	}
+
+	// Unwrapped keys are cached for the same secret only
+	if cached := loaded.dataKeys.get("alice", "first secret", loaded.GetUser("alice").Encryption, time.Now()); cached == nil {
+		t.Error("UnlockData() did not cache the data key")
+	}
+	if cached := loaded.dataKeys.get("alice", "wrong secret", loaded.GetUser("alice").Encryption, time.Now()); cached != nil {
+		t.Error("dataKeys.get() returned the data key for a wrong secret")
+	}
+	if cached := loaded.dataKeys.get("alice", "first secret", loaded.GetUser("alice").Encryption, time.Now().Add(DataKeyCacheTTL)); cached != nil {
+		t.Error("dataKeys.get() returned an expired data key")
+	}
+	if _, err := loaded.UnlockData("alice", "wrong secret"); !errors.Is(err, ErrWrongSecret) {
+		t.Errorf("UnlockData() error = %v with a cached key, expected ErrWrongSecret", err)
+	}
+
+	// Changing the secret keeps the data key, but invalidates unlocked keys
+	if err := loaded.SetDataSecret("alice", "wrong secret", "second secret"); !errors.Is(err, ErrWrongSecret) {
+		t.Errorf("SetDataSecret() error = %v, expected ErrWrongSecret", err)
+	}
+	if err := loaded.SetDataSecret("alice", "first secret", "second secret"); err != nil {
+		t.Fatalf("SetDataSecret() failed to change the secret: %v", err)
+	}
+	if _, _, err := loaded.ReadUserData("alice", "existing", key); !errors.Is(err, ErrEncryptionChanged) {
+		t.Errorf("ReadUserData() error = %v, expected ErrEncryptionChanged", err)
+	}
+	if _, err := loaded.UnlockData("alice", "first secret"); !errors.Is(err, ErrWrongSecret) {
+		t.Errorf("UnlockData() error = %v with the old secret, expected ErrWrongSecret", err)
+	}
+	key, err = loaded.UnlockData("alice", "second secret")
+	if err != nil {
+		t.Fatalf("UnlockData() failed with the new secret: %v", err)
+	}
+	if value, _, err := loaded.ReadUserData("alice", "new", key); err != nil || value.(map[string]interface{})["n"] != 1.0 {
+		t.Errorf("ReadUserData() = %v, %v, expected the written value", value, err)
+	}
+
+	// Disabling stores the values in plaintext again
+	if err := loaded.DisableDataEncryption("alice", "second secret"); err != nil {
+		t.Fatalf("DisableDataEncryption() failed: %v", err)
+	}
+	if value, exists := loaded.GetUserData("alice", "existing"); !exists || value != "plain value" {
+		t.Errorf("GetUserData() = %v, %v after disabling, expected plain value", value, exists)
+	}
+	if err := loaded.DisableDataEncryption("alice", "second secret"); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("DisableDataEncryption() error = %v, expected ErrNotEncrypted", err)
+	}
+}
+
+func TestSchemaMigration(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	// Version 0 is the bare map of users, which may have a user named version
+	bare := `{"alice": {"cron": [], "data": {"k": "v"}}, "version": {"cron": [], "data": {}}}`
+	os.WriteFile(path, []byte(bare), 0644)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed for version 0: %v", err)
+	}
+	if value, _ := cfg.GetUserData("alice", "k"); value != "v" || len(cfg.Users) != 2 {
+		t.Fatalf("LoadConfig() returned users %v for version 0, expected alice and version", cfg.Users)
+	}
+
+	// The first save writes the current version and keeps the old file
+	backup := path + ".v0"
+	if _, err := os.Stat(backup); !os.IsNotExist(err) {
+		t.Errorf("LoadConfig() kept %s before saving", backup)
+	}
+	cfg.SetUserData("alice", "k", "v2")
+	if err := SaveConfig(cfg, path); err != nil {
+		t.Fatalf("SaveConfig() failed: %v", err)
+	}
+	if content, _ := os.ReadFile(backup); string(content) != bare {
+		t.Errorf("SaveConfig() kept %q as %s, expected the file of version 0", content, backup)
+	}
+	var saved struct {
+		Version int                    `json:"version"`
+		Users   map[string]interface{} `json:"users"`
+	}
+	content, _ := os.ReadFile(path)
+	if err := json.Unmarshal(content, &saved); err != nil || saved.Version != SchemaVersion || len(saved.Users) != 2 {
+		t.Errorf("SaveConfig() wrote %s, expected version %d with 2 users", content, SchemaVersion)
+	}
+
+	// Files of the current version are neither migrated nor kept again
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed for version %d: %v", SchemaVersion, err)
+	}
+	loaded.SetUserData("alice", "k", "v3")
+	if err := SaveConfig(loaded, path); err != nil {
+		t.Fatalf("SaveConfig() failed: %v", err)
+	}
+	if content, _ := os.ReadFile(backup); string(content) != bare {
+		t.Errorf("SaveConfig() replaced %s", backup)
+	}
+
+	// Newer versions are not read, but not invalid either
+	os.WriteFile(path, []byte(`{"version": 99, "users": {}}`), 0644)
+	if _, err := LoadConfig(path); !errors.Is(err, ErrNewerSchema) || errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("LoadConfig() error = %v for a newer version, expected ErrNewerSchema", err)
+	}
+	os.WriteFile(path, []byte(`{"version": 1}`), 0644)
+	if _, err := LoadConfig(path); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("LoadConfig() error = %v without users, expected ErrInvalidConfig", err)
+	}
+}
+
+func TestSetUserCron(t *testing.T) {
+	filePath := t.TempDir() + "/config.json"
+
+	cfg := NewConfig()
+	cfg.CreateUser("alice")
+	if err := SaveConfig(cfg, filePath); err != nil {
+		t.Fatalf("SaveConfig() failed: %v", err)
+	}
+
+	// Replacing the jobs is saved
+	cfg.SetUserCron("alice", []*CronJob{{ID: "job1", Cron: "* * * * *", URL: "http://example.com"}})
+	if !cfg.Changed {
+		t.Error("SetUserCron() did not mark the configuration as changed")
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	loaded, err := LoadConfig(filePath)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+	if jobs := loaded.GetUserJobs("alice"); len(jobs) != 1 || jobs[0].ID != "job1" {
+		t.Errorf("LoadConfig() returned jobs %v after SetUserCron(), expected job1", jobs)
+	}
+}
+
+func TestSaveChanged(t *testing.T) {
+	cfg := NewConfig()
+	cfg.SetStore(NewFileStore(filepath.Join(t.TempDir(), "config.json")))
+	cfg.CreateUser("alice")
+
+	if saved, err := cfg.SaveChanged(); err != nil || !saved {
+		t.Errorf("SaveChanged() = %v, %v with changes, expected true", saved, err)
+	}
+	if saved, err := cfg.SaveChanged(); err != nil || saved {
+		t.Errorf("SaveChanged() = %v, %v without changes, expected false", saved, err)
+	}
+	cfg.SetReadOnly("test")
+	cfg.CreateUser("bob")
+	if saved, err := cfg.SaveChanged(); !errors.Is(err, ErrReadOnly) || saved {
+		t.Errorf("SaveChanged() = %v, %v while read-only, expected ErrReadOnly", saved, err)
+	}
+}
@@ -0,0 +1,433 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// DataKeyIterations is how many PBKDF2-SHA256 iterations derive the key
+// wrapping a data key from a user's secret
+const DataKeyIterations = 210000
+
+// DataKeyCacheTTL is how long an unwrapped data key is kept, so requests
+// giving the same secret do not derive the wrapping key again
+const DataKeyCacheTTL = 5 * time.Minute
+
+// MinDataSecretLength is the minimum length of a user's encryption secret
+const MinDataSecretLength = 8
+
+// sealedValuePrefix starts every encrypted data value
+const sealedValuePrefix = "enc:v1:"
+
+var (
+	// ErrSecretRequired is returned for encrypted data without a secret
+	ErrSecretRequired = errors.New("data is encrypted, the encryption secret is required")
+	// ErrWrongSecret is returned for a secret that does not unwrap the data key
+	ErrWrongSecret = errors.New("wrong encryption secret")
+	// ErrWeakSecret is returned for secrets shorter than MinDataSecretLength
+	ErrWeakSecret = fmt.Errorf("encryption secret must have at least %d characters", MinDataSecretLength)
+	// ErrNotEncrypted is returned when disabling encryption that is not enabled
+	ErrNotEncrypted = errors.New("data is not encrypted")
+	// ErrEncryptionChanged is returned when the encryption of a namespace
+	// changed while a request used it
+	ErrEncryptionChanged = errors.New("encryption of the data changed, try again")
+)
+
+// DataEncryption is how the data values of a user are encrypted: with a
+// random data key, stored wrapped by a key derived from the user's secret,
+// so neither the configuration nor its operators can read them
+type DataEncryption struct {
+	Salt       []byte `json:"salt"`
+	Iterations int    `json:"iterations"`
+	// WrappedKey is the data key sealed with the derived key
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+// DataKey encrypts and decrypts the data values of one user. A nil DataKey
+// stands for a namespace without encryption.
+type DataKey struct {
+	aead cipher.AEAD
+	// encryption is the state the key was unwrapped from, which must still
+	// be current when the key is used
+	encryption *DataEncryption
+}
+
+// newAEAD returns AES-256-GCM with the key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealWith encrypts data with a random nonce, returning the nonce followed
+// by the ciphertext
+func sealWith(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, data, additional), nil
+}
+
+// openWith decrypts data sealed by sealWith
+func openWith(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is truncated")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+}
+
+// wrappingKey derives the key wrapping the data key from a secret
+func wrappingKey(secret string, salt []byte, iterations int) (cipher.AEAD, error) {
+	return newAEAD(pbkdf2.Key([]byte(secret), salt, iterations, KeySize, sha256.New))
+}
+
+// wrapDataKey wraps a data key with a secret. The wrapped key is bound to
+// the user, so it cannot be copied to another namespace.
+func wrapDataKey(user, secret string, key []byte) (*DataEncryption, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	kek, err := wrappingKey(secret, salt, DataKeyIterations)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := sealWith(kek, key, []byte(user))
+	if err != nil {
+		return nil, err
+	}
+	return &DataEncryption{Salt: salt, Iterations: DataKeyIterations, WrappedKey: wrapped}, nil
+}
+
+// unwrapDataKey unwraps the data key of a user with a secret
+func unwrapDataKey(user, secret string, encryption *DataEncryption) ([]byte, error) {
+	if secret == "" {
+		return nil, ErrSecretRequired
+	}
+	kek, err := wrappingKey(secret, encryption.Salt, encryption.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	key, err := openWith(kek, encryption.WrappedKey, []byte(user))
+	if err != nil {
+		return nil, ErrWrongSecret
+	}
+	return key, nil
+}
+
+// Seal encrypts a data value stored under key. The ciphertext is bound to
+// the key, so it cannot be moved to another key.
+func (k *DataKey) Seal(key string, value interface{}) (interface{}, error) {
+	if k == nil {
+		return value, nil
+	}
+	plain, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealWith(k.aead, plain, []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	return sealedValuePrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a data value stored under key
+func (k *DataKey) Open(key string, value interface{}) (interface{}, error) {
+	if k == nil {
+		return value, nil
+	}
+	s, ok := value.(string)
+	if !ok || !strings.HasPrefix(s, sealedValuePrefix) {
+		return nil, fmt.Errorf("data %s is not encrypted", key)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, sealedValuePrefix))
+	if err != nil {
+		return nil, fmt.Errorf("data %s: %w", key, err)
+	}
+	plain, err := openWith(k.aead, sealed, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data %s: %w", key, err)
+	}
+
+	var opened interface{}
+	if err := json.Unmarshal(plain, &opened); err != nil {
+		return nil, fmt.Errorf("data %s: %w", key, err)
+	}
+	return opened, nil
+}
+
+// DataEncrypted reports whether the data values of a user are encrypted
+func (c *Config) DataEncrypted(user string) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	userData, exists := c.Users[user]
+	return exists && userData.Encryption != nil
+}
+
+// UnlockData returns the data key of a user, unwrapped with the secret, or
+// nil if the user's data is not encrypted
+func (c *Config) UnlockData(user, secret string) (*DataKey, error) {
+	key, encryption, err := c.unwrapUserDataKey(user, secret)
+	if err != nil || encryption == nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{aead: aead, encryption: encryption}, nil
+}
+
+// unwrapUserDataKey returns the data key of a user and the encryption it
+// was unwrapped from, nil for both if the user's data is not encrypted
+func (c *Config) unwrapUserDataKey(user, secret string) ([]byte, *DataEncryption, error) {
+	c.mutex.RLock()
+	var encryption *DataEncryption
+	if userData, exists := c.Users[user]; exists {
+		encryption = userData.Encryption
+	}
+	c.mutex.RUnlock()
+
+	if encryption == nil {
+		return nil, nil, nil
+	}
+	if key := c.dataKeys.get(user, secret, encryption, time.Now()); key != nil {
+		return key, encryption, nil
+	}
+
+	// Deriving the key is slow on purpose, so it runs without the lock
+	key, err := unwrapDataKey(user, secret, encryption)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.dataKeys.put(user, secret, encryption, key, time.Now())
+	return key, encryption, nil
+}
+
+// dataKeyCache holds the data keys unwrapped recently, each with a
+// verifier of the secret that unwrapped it. The verifier is keyed with a
+// random key that never leaves the process, so it is cheap to check but
+// reveals nothing about the secret.
+type dataKeyCache struct {
+	mutex       sync.Mutex
+	verifierKey []byte
+	keys        map[string]*cachedDataKey
+}
+
+// cachedDataKey is a data key unwrapped from an encryption with a secret
+type cachedDataKey struct {
+	encryption *DataEncryption
+	verifier   []byte
+	key        []byte
+	expires    time.Time
+}
+
+// verifier returns the verifier of a secret. The mutex must be held.
+func (d *dataKeyCache) verifier(secret string) []byte {
+	if d.verifierKey == nil {
+		d.verifierKey = make([]byte, KeySize)
+		if _, err := rand.Read(d.verifierKey); err != nil {
+			panic("config: failed to generate data key verifier key: " + err.Error())
+		}
+	}
+	mac := hmac.New(sha256.New, d.verifierKey)
+	mac.Write([]byte(secret))
+	return mac.Sum(nil)
+}
+
+// get returns the cached data key of a user if it was unwrapped from the
+// current encryption with the same secret, nil otherwise
+func (d *dataKeyCache) get(user, secret string, encryption *DataEncryption, now time.Time) []byte {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	cached, exists := d.keys[user]
+	if !exists || cached.encryption != encryption || !now.Before(cached.expires) {
+		return nil
+	}
+	if !hmac.Equal(cached.verifier, d.verifier(secret)) {
+		return nil
+	}
+	return cached.key
+}
+
+// put caches the data key of a user unwrapped with a secret and drops the
+// keys that expired
+func (d *dataKeyCache) put(user, secret string, encryption *DataEncryption, key []byte, now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.keys == nil {
+		d.keys = make(map[string]*cachedDataKey)
+	}
+	for name, cached := range d.keys {
+		if !now.Before(cached.expires) {
+			delete(d.keys, name)
+		}
+	}
+	d.keys[user] = &cachedDataKey{
+		encryption: encryption,
+		verifier:   d.verifier(secret),
+		key:        key,
+		expires:    now.Add(DataKeyCacheTTL),
+	}
+}
+
+// checkEncryption returns ErrEncryptionChanged unless the user's data is
+// still encrypted as expected, nil for not at all. The mutex must be held.
+func (c *Config) checkEncryption(user string, expected *DataEncryption) error {
+	var current *DataEncryption
+	if userData, exists := c.Users[user]; exists {
+		current = userData.Encryption
+	}
+	if current != expected {
+		return ErrEncryptionChanged
+	}
+	return nil
+}
+
+// unwrappedFrom returns the encryption the key was unwrapped from, nil for
+// a namespace without encryption
+func (k *DataKey) unwrappedFrom() *DataEncryption {
+	if k == nil {
+		return nil
+	}
+	return k.encryption
+}
+
+// ReadUserData returns a data value of a user, decrypted with the data key
+func (c *Config) ReadUserData(user, key string, dataKey *DataKey) (interface{}, bool, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if err := c.checkEncryption(user, dataKey.unwrappedFrom()); err != nil {
+		return nil, false, err
+	}
+	userData, exists := c.Users[user]
+	if !exists {
+		return nil, false, nil
+	}
+	value, exists := userData.Data[key]
+	if !exists {
+		return nil, false, nil
+	}
+	value, err := dataKey.Open(key, value)
+	return value, true, err
+}
+
+// WriteUserData sets a data value of a user, encrypted with the data key
+func (c *Config) WriteUserData(user, key string, value interface{}, dataKey *DataKey) error {
+	sealed, err := dataKey.Seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.checkEncryption(user, dataKey.unwrappedFrom()); err != nil {
+		return err
+	}
+	c.userData(user).Data[key] = sealed
+	c.changed(JournalEntry{Op: OpSetData, User: user, Key: key, Value: sealed})
+	return nil
+}
+
+// SetDataSecret encrypts the data values of a user with a secret. If they
+// are encrypted already, the data key is unwrapped with the current secret
+// and wrapped with the new one, so the values are not encrypted again.
+func (c *Config) SetDataSecret(user, currentSecret, newSecret string) error {
+	if len(newSecret) < MinDataSecretLength {
+		return ErrWeakSecret
+	}
+
+	key, current, err := c.unwrapUserDataKey(user, currentSecret)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		key = make([]byte, KeySize)
+		if _, err := rand.Read(key); err != nil {
+			return fmt.Errorf("failed to generate data key: %w", err)
+		}
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	encryption, err := wrapDataKey(user, newSecret, key)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.checkEncryption(user, current); err != nil {
+		return err
+	}
+	userData := c.userData(user)
+	if current == nil {
+		// Encrypt the values stored so far
+		sealer := &DataKey{aead: aead}
+		sealed := make(map[string]interface{}, len(userData.Data))
+		for name, value := range userData.Data {
+			if sealed[name], err = sealer.Seal(name, value); err != nil {
+				return err
+			}
+		}
+		userData.Data = sealed
+	}
+	userData.Encryption = encryption
+	c.userChanged(user)
+
+	return nil
+}
+
+// DisableDataEncryption decrypts the data values of a user with the secret
+// and stores them in plaintext again
+func (c *Config) DisableDataEncryption(user, secret string) error {
+	dataKey, err := c.UnlockData(user, secret)
+	if err != nil {
+		return err
+	}
+	if dataKey == nil {
+		return ErrNotEncrypted
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.checkEncryption(user, dataKey.unwrappedFrom()); err != nil {
+		return err
+	}
+	userData := c.userData(user)
+	opened := make(map[string]interface{}, len(userData.Data))
+	for name, value := range userData.Data {
+		if opened[name], err = dataKey.Open(name, value); err != nil {
+			return err
+		}
+	}
+	userData.Data = opened
+	userData.Encryption = nil
+	c.userChanged(user)
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// userFileSuffix ends the name of every user file of a DirStore
+const userFileSuffix = ".json"
+
+// DirStore keeps every user in its own JSON file in a directory, so a save
+// only rewrites the files of the users that changed
+type DirStore struct {
+	mutex sync.Mutex
+	path  string
+	// stamps identify the user files as last loaded or saved
+	stamps map[string]fileStamp
+	// stale holds the users last loaded in plaintext or sealed with an old
+	// key
+	stale []string
+}
+
+// NewDirStore returns a store for the directory at path
+func NewDirStore(path string) *DirStore {
+	return &DirStore{path: path, stamps: make(map[string]fileStamp)}
+}
+
+// userFile returns the path of the file of a user. The name is escaped, so
+// it cannot leave the directory.
+func (s *DirStore) userFile(user string) string {
+	return filepath.Join(s.path, url.PathEscape(user)+userFileSuffix)
+}
+
+// Load reads the files of all users. A missing directory returns the error
+// of os.ReadDir.
+func (s *DirStore) Load() (map[string]*UserData, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	files, err := s.userFiles()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", ErrUnreadableConfig, err)
+	}
+
+	users := make(map[string]*UserData, len(files))
+	var stale []string
+	for name := range files {
+		user, err := url.PathUnescape(strings.TrimSuffix(name, userFileSuffix))
+		if err != nil {
+			return nil, fmt.Errorf("%w: file name %s: %w", ErrInvalidConfig, name, err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.path, name))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnreadableConfig, err)
+		}
+		plain, restale, err := unseal(data)
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %w", name, err)
+		}
+		userData, err := decodeUser(user, plain)
+		if err != nil {
+			return nil, err
+		}
+		users[user] = userData
+		if restale {
+			stale = append(stale, user)
+		}
+	}
+	s.stamps = files
+	s.stale = stale
+
+	return users, nil
+}
+
+// userFiles returns the stamps of the user files in the directory,
+// skipping temporary files left by a crash
+func (s *DirStore) userFiles() (map[string]fileStamp, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]fileStamp, len(entries))
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), userFileSuffix) || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since it was listed
+			continue
+		}
+		files[entry.Name()] = stampOf(info)
+	}
+
+	return files, nil
+}
+
+// Save rewrites the files of the changed users and removes those of
+// deleted users. Every file is replaced atomically, but a failed save may
+// have stored some of the changes.
+func (s *DirStore) Save(changes map[string][]byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.MkdirAll(s.path, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", s.path, err)
+	}
+
+	for user, data := range changes {
+		path := s.userFile(user)
+		name := filepath.Base(path)
+		if data == nil {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+			delete(s.stamps, name)
+			continue
+		}
+		// Indented like the configuration file, to stay easy to edit
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, data, "", "  "); err != nil {
+			return fmt.Errorf("failed to marshal user %s: %w", user, err)
+		}
+		sealed, err := encryption.Seal(indented.Bytes())
+		if err != nil {
+			return fmt.Errorf("failed to encrypt user %s: %w", user, err)
+		}
+		if err := replaceFile(path, sealed, 0644); err != nil {
+			return err
+		}
+		if info, err := os.Stat(path); err == nil {
+			s.stamps[name] = stampOf(info)
+		}
+	}
+
+	return nil
+}
+
+// Modified reports whether user files were written, added or removed by
+// someone else since they were last loaded or saved. A missing directory is
+// not modified, as there is nothing to load.
+func (s *DirStore) Modified() (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	files, err := s.userFiles()
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(files) != len(s.stamps) {
+		return true, nil
+	}
+	for name, stamp := range files {
+		if known, exists := s.stamps[name]; !exists || known != stamp {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Stale returns the users last loaded in plaintext or sealed with an old
+// key
+func (s *DirStore) Stale() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.stale
+}
+
+// Close does nothing, files are only open while they are read or written
+func (s *DirStore) Close() error {
+	return nil
+}
@@ -0,0 +1,259 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the length of encryption keys, which select AES-256
+const KeySize = 32
+
+// sealedMagic starts all sealed data. It starts with a NUL byte, which no
+// JSON document does, so sealed and plaintext data cannot be confused.
+var sealedMagic = []byte("\x00DCSENC1")
+
+// keyIDSize is the length of the key ID following the magic
+const keyIDSize = 8
+
+// ErrNoEncryptionKey is returned for sealed data without keys to open it
+var ErrNoEncryptionKey = errors.New("data is encrypted, but no encryption key is set")
+
+// ErrUnknownEncryptionKey is returned for data sealed with none of the keys
+var ErrUnknownEncryptionKey = errors.New("data is encrypted with an unknown key")
+
+// Cipher seals persisted configuration data with AES-256-GCM. Data is
+// sealed with the current key and opened with any key, so the key can be
+// rotated while data sealed with the previous keys is still read.
+type Cipher struct {
+	// current seals new data, nil to write plaintext
+	current *cipherKey
+	// keys are all keys by ID, the current one included
+	keys map[string]*cipherKey
+}
+
+// cipherKey is a key and the ID identifying it in sealed data
+type cipherKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// NewCipher returns a cipher sealing with the current key and opening with
+// the current and the old keys. Without a current key data is written in
+// plaintext, which decrypts everything sealed with the old keys.
+func NewCipher(current []byte, old ...[]byte) (*Cipher, error) {
+	c := &Cipher{keys: make(map[string]*cipherKey)}
+	for i, key := range append([][]byte{current}, old...) {
+		if key == nil {
+			continue
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("encryption key has %d bytes, expected %d", len(key), KeySize)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		k := &cipherKey{id: sum[:keyIDSize], aead: aead}
+		if i == 0 {
+			c.current = k
+		}
+		if _, exists := c.keys[string(k.id)]; !exists {
+			c.keys[string(k.id)] = k
+		}
+	}
+
+	return c, nil
+}
+
+// ParseKey decodes a key given as 64 hex digits or in base64
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == hex.EncodedLen(KeySize) {
+		if key, err := hex.DecodeString(s); err == nil {
+			return key, nil
+		}
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("invalid encryption key, expected %d bytes as 64 hex digits or base64", KeySize)
+	}
+	return key, nil
+}
+
+// ReadKeyFile reads the keys in a file, one per line. The first key is the
+// current one, the others are old keys that are still read.
+func ReadKeyFile(path string) (current []byte, old [][]byte, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read key file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := ParseKey(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("key file %s: %w", path, err)
+		}
+		if current == nil {
+			current = key
+		} else {
+			old = append(old, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read key file %s: %w", path, err)
+	}
+	if current == nil {
+		return nil, nil, fmt.Errorf("key file %s holds no key", path)
+	}
+
+	return current, old, nil
+}
+
+// IsSealed reports whether data was sealed by a cipher
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedMagic)
+}
+
+// Seal encrypts data with the current key. Without a cipher or a current
+// key the data is returned as is.
+func (c *Cipher) Seal(data []byte) ([]byte, error) {
+	if c == nil || c.current == nil {
+		return data, nil
+	}
+
+	// The header is authenticated, so the key ID cannot be swapped
+	header := append(append([]byte{}, sealedMagic...), c.current.id...)
+	nonce := make([]byte, c.current.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := make([]byte, 0, len(header)+len(nonce)+len(data)+c.current.aead.Overhead())
+	sealed = append(append(sealed, header...), nonce...)
+	return c.current.aead.Seal(sealed, nonce, data, header), nil
+}
+
+// Open decrypts sealed data and returns plaintext data as is. Stale reports
+// whether the data should be sealed again: it is plaintext or sealed with
+// an old key while there is a current key, or sealed while there is none.
+func (c *Cipher) Open(data []byte) (plain []byte, stale bool, err error) {
+	current := c != nil && c.current != nil
+	if !IsSealed(data) {
+		return data, current, nil
+	}
+	if c == nil || len(c.keys) == 0 {
+		return nil, false, ErrNoEncryptionKey
+	}
+
+	headerSize := len(sealedMagic) + keyIDSize
+	if len(data) < headerSize {
+		return nil, false, errors.New("encrypted data is truncated")
+	}
+	header, id := data[:headerSize], data[len(sealedMagic):headerSize]
+	key, exists := c.keys[string(id)]
+	if !exists {
+		return nil, false, ErrUnknownEncryptionKey
+	}
+
+	rest := data[headerSize:]
+	if len(rest) < key.aead.NonceSize() {
+		return nil, false, errors.New("encrypted data is truncated")
+	}
+	nonce, ciphertext := rest[:key.aead.NonceSize()], rest[key.aead.NonceSize():]
+	plain, err = key.aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	return plain, !current || key != c.current, nil
+}
+
+// SealLine seals a line of a text file, such as a journal entry, as base64
+func (c *Cipher) SealLine(line []byte) ([]byte, error) {
+	if c == nil || c.current == nil {
+		return line, nil
+	}
+	sealed, err := c.Seal(line)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// OpenLine opens a line sealed by SealLine. JSON lines are plaintext and
+// returned as is.
+func (c *Cipher) OpenLine(line []byte) ([]byte, error) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] == '{' {
+		return line, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(line))
+	if err != nil || !IsSealed(sealed) {
+		return nil, errors.New("line is neither JSON nor encrypted")
+	}
+	plain, _, err := c.Open(sealed)
+	return plain, err
+}
+
+// encryption seals everything the stores, the journal and the history
+// write, nil to write plaintext
+var encryption *Cipher
+
+// SetEncryption makes everything persisted from now on be sealed with the
+// cipher, and sealed data be opened with it. nil disables encryption.
+func SetEncryption(c *Cipher) {
+	encryption = c
+}
+
+// unseal opens data read from disk, returning errors that wrap
+// ErrUnreadableConfig
+func unseal(data []byte) (plain []byte, stale bool, err error) {
+	plain, stale, err = encryption.Open(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %w", ErrUnreadableConfig, err)
+	}
+	return plain, stale, nil
+}
+
+// StaleStore is implemented by stores that can tell which users were
+// loaded in plaintext or sealed with an old key, so they are sealed with
+// the current key by the next save
+type StaleStore interface {
+	Stale() []string
+}
+
+// markStale marks the users the store loaded with a stale encryption as
+// dirty. The mutex must be held for writing.
+func (c *Config) markStale(store Store) {
+	stale, ok := store.(StaleStore)
+	if !ok {
+		return
+	}
+	for _, user := range stale.Stale() {
+		if _, exists := c.Users[user]; exists {
+			c.markDirty(user)
+			c.Changed = true
+		}
+	}
+}
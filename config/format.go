@@ -0,0 +1,186 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of the configuration file
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// FormatOf returns the format of a configuration file by its extension:
+// .yaml and .yml are YAML, .toml is TOML and everything else is JSON. The
+// number of a backup generation is ignored, so config.yaml.2 is YAML.
+func FormatOf(path string) string {
+	ext := filepath.Ext(path)
+	if generation := strings.TrimPrefix(ext, "."); generation != "" && strings.Trim(generation, "0123456789") == "" {
+		ext = filepath.Ext(strings.TrimSuffix(path, ext))
+	}
+	format, err := ParseFormat(strings.TrimPrefix(ext, "."))
+	if err != nil {
+		return FormatJSON
+	}
+	return format
+}
+
+// ParseFormat returns the format with the given name or file extension
+func ParseFormat(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "json":
+		return FormatJSON, nil
+	case "yaml", "yml":
+		return FormatYAML, nil
+	case "toml":
+		return FormatTOML, nil
+	}
+	return "", fmt.Errorf("unknown format %q, expected %s, %s or %s", name, FormatJSON, FormatYAML, FormatTOML)
+}
+
+// ContentType returns the media type of a format
+func ContentType(format string) string {
+	switch format {
+	case FormatYAML:
+		return "application/yaml"
+	case FormatTOML:
+		return "application/toml"
+	}
+	return "application/json"
+}
+
+// FromJSON converts a JSON document to a format. The document keeps its
+// structure, so every format has the same fields as the JSON encoding.
+func FromJSON(format string, data []byte) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, data, "", "  "); err != nil {
+			return nil, err
+		}
+		return indented.Bytes(), nil
+
+	case FormatYAML:
+		// JSON is YAML, so parsing it as a node keeps the order of the fields
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+		blockStyle(&node)
+		var out bytes.Buffer
+		encoder := yaml.NewEncoder(&out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&node); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+
+	case FormatTOML:
+		var document map[string]interface{}
+		if err := json.Unmarshal(data, &document); err != nil {
+			return nil, err
+		}
+		if _, err := tomlValues(document, ""); err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		encoder := toml.NewEncoder(&out)
+		encoder.Indent = ""
+		if err := encoder.Encode(document); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// ToJSON converts a document in a format to JSON
+func ToJSON(format string, data []byte) ([]byte, error) {
+	var document interface{}
+	switch format {
+	case FormatJSON:
+		return data, nil
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		if _, err := toml.Decode(string(data), &document); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	// Times of YAML and TOML become RFC 3339 strings, like in JSON
+	return json.Marshal(document)
+}
+
+// blockStyle drops the flow style and quotes of a node parsed from JSON, so
+// it is written like hand-written YAML. Strings that need quotes get them.
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// tomlValues prepares a document decoded from JSON for TOML: null fields
+// are removed, as TOML has no null, and whole numbers become integers, so
+// they are not written as 1.0. Null elements of arrays cannot be removed
+// without moving the others.
+func tomlValues(value interface{}, path string) (interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if field == nil {
+				delete(value, key)
+				continue
+			}
+			converted, err := tomlValues(field, path+"."+key)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = converted
+		}
+	case []interface{}:
+		for i, element := range value {
+			if element == nil {
+				return nil, fmt.Errorf("%s[%d] is null, which TOML cannot represent", strings.TrimPrefix(path, "."), i)
+			}
+			converted, err := tomlValues(element, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			value[i] = converted
+		}
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+			return int64(value), nil
+		}
+	}
+	return value, nil
+}
+
+// Export returns all users in a format, as a configuration file in that
+// format would hold them, but without the credentials of their keys
+func (c *Config) Export(format string) ([]byte, error) {
+	users, err := json.Marshal(c.RedactedUsers())
+	if err != nil {
+		return nil, err
+	}
+	document := fmt.Sprintf(`{"version":%d,"users":%s}`, SchemaVersion, users)
+	return FromJSON(format, []byte(document))
+}
@@ -0,0 +1,585 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRevisions is how many revisions of the configuration are kept
+const DefaultMaxRevisions = 100
+
+// SystemPrincipal names changes no request made, e.g. replaying the journal
+const SystemPrincipal = "system"
+
+// ErrRevisionNotFound is returned for revisions that do not exist
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// ErrNoHistory is returned when the configuration keeps no revisions
+var ErrNoHistory = errors.New("revision history disabled")
+
+// Revision describes a saved state of the configuration
+type Revision struct {
+	Number int       `json:"number"`
+	Time   time.Time `json:"time"`
+	// Principals made the changes saved with the revision
+	Principals []string `json:"principals"`
+	Summary    string   `json:"summary"`
+}
+
+// revision is a revision as kept on disk, with the object holding the
+// state of every user
+type revision struct {
+	Revision
+	Users map[string]string `json:"users"`
+}
+
+// RevisionDiff lists the users, jobs and data keys changed between two
+// revisions. Jobs are named user/job and data keys user/key.
+type RevisionDiff struct {
+	From  int     `json:"from"`
+	To    int     `json:"to"`
+	Users Changes `json:"users"`
+	Jobs  Changes `json:"jobs"`
+	Data  Changes `json:"data"`
+}
+
+// Summary describes the changes in one line, e.g.
+// "users: 1 changed; jobs: 3 removed"
+func (d RevisionDiff) Summary() string {
+	var parts []string
+	for _, area := range []struct {
+		name    string
+		changes Changes
+	}{{"users", d.Users}, {"jobs", d.Jobs}, {"data", d.Data}} {
+		var counts []string
+		for _, count := range []struct {
+			verb  string
+			names []string
+		}{{"added", area.changes.Added}, {"removed", area.changes.Removed}, {"changed", area.changes.Changed}} {
+			if len(count.names) > 0 {
+				counts = append(counts, fmt.Sprintf("%d %s", len(count.names), count.verb))
+			}
+		}
+		if len(counts) > 0 {
+			parts = append(parts, area.name+": "+strings.Join(counts, ", "))
+		}
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// History keeps numbered revisions of the configuration in a directory.
+// The state of every user is stored once per distinct content in
+// objects/, named by its hash, and every revision in revisions/ maps the
+// users to their objects, so a revision only costs the users it changed.
+type History struct {
+	mutex sync.Mutex
+	path  string
+	// max is how many revisions are kept, 0 keeps all
+	max int
+	// revisions are sorted by number, oldest first
+	revisions []*revision
+}
+
+// OpenHistory opens or creates the history in the directory at path,
+// keeping up to max revisions
+func OpenHistory(path string, max int) (*History, error) {
+	h := &History{path: path, max: max}
+	for _, dir := range []string{h.revisionsDir(), h.objectsDir()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+
+	entries, err := os.ReadDir(h.revisionsDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history %s: %w", path, err)
+	}
+	for _, entry := range entries {
+		// Temporary files left by a crash are not revisions
+		if !strings.HasSuffix(entry.Name(), ".json") || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(h.revisionsDir(), entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read revision %s: %w", entry.Name(), err)
+		}
+		var rev revision
+		if err := json.Unmarshal(data, &rev); err != nil {
+			return nil, fmt.Errorf("invalid revision %s: %w", entry.Name(), err)
+		}
+		h.revisions = append(h.revisions, &rev)
+	}
+	sort.Slice(h.revisions, func(i, j int) bool {
+		return h.revisions[i].Number < h.revisions[j].Number
+	})
+
+	return h, nil
+}
+
+func (h *History) revisionsDir() string {
+	return filepath.Join(h.path, "revisions")
+}
+
+func (h *History) objectsDir() string {
+	return filepath.Join(h.path, "objects")
+}
+
+func (h *History) revisionFile(number int) string {
+	return filepath.Join(h.revisionsDir(), strconv.Itoa(number)+".json")
+}
+
+func (h *History) objectFile(hash string) string {
+	return filepath.Join(h.objectsDir(), hash+".json")
+}
+
+// Revisions returns the revisions, newest first
+func (h *History) Revisions() []Revision {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	revisions := make([]Revision, 0, len(h.revisions))
+	for i := len(h.revisions) - 1; i >= 0; i-- {
+		revisions = append(revisions, h.revisions[i].Revision)
+	}
+	return revisions
+}
+
+// find returns a revision. The mutex must be held.
+func (h *History) find(number int) (*revision, error) {
+	i := sort.Search(len(h.revisions), func(i int) bool {
+		return h.revisions[i].Number >= number
+	})
+	if i == len(h.revisions) || h.revisions[i].Number != number {
+		return nil, ErrRevisionNotFound
+	}
+	return h.revisions[i], nil
+}
+
+// latest returns the newest revision, nil if there is none. The mutex must
+// be held.
+func (h *History) latest() *revision {
+	if len(h.revisions) == 0 {
+		return nil
+	}
+	return h.revisions[len(h.revisions)-1]
+}
+
+// Users returns the users as they were in a revision
+func (h *History) Users(number int) (map[string]*UserData, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	rev, err := h.find(number)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]*UserData, len(rev.Users))
+	for user, hash := range rev.Users {
+		userData, err := h.readObject(user, hash)
+		if err != nil {
+			return nil, err
+		}
+		users[user] = userData
+	}
+	return users, nil
+}
+
+// Diff returns what changed from one revision to another
+func (h *History) Diff(from, to int) (RevisionDiff, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	before, err := h.find(from)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+	after, err := h.find(to)
+	if err != nil {
+		return RevisionDiff{}, err
+	}
+
+	diff, err := h.diff(before.Users, after.Users)
+	diff.From, diff.To = from, to
+	return diff, err
+}
+
+// diff compares two maps of users to objects. Only the objects of users
+// that differ are read. The mutex must be held.
+func (h *History) diff(before, after map[string]string) (RevisionDiff, error) {
+	diff := RevisionDiff{Users: newChanges(), Jobs: newChanges(), Data: newChanges()}
+
+	empty := &UserData{}
+	for user := range unionKeys(before, after) {
+		oldHash, existed := before[user]
+		newHash, exists := after[user]
+		if oldHash == newHash {
+			continue
+		}
+
+		oldData, newData := empty, empty
+		var err error
+		if existed {
+			if oldData, err = h.readObject(user, oldHash); err != nil {
+				return diff, err
+			}
+		}
+		if exists {
+			if newData, err = h.readObject(user, newHash); err != nil {
+				return diff, err
+			}
+		}
+
+		switch {
+		case !existed:
+			diff.Users.Added = append(diff.Users.Added, user)
+		case !exists:
+			diff.Users.Removed = append(diff.Users.Removed, user)
+		default:
+			diff.Users.Changed = append(diff.Users.Changed, user)
+		}
+		diffValues(&diff.Jobs, user, jobsByID(oldData.Cron), jobsByID(newData.Cron))
+		diffValues(&diff.Data, user, oldData.Data, newData.Data)
+	}
+
+	diff.Users.sort()
+	diff.Jobs.sort()
+	diff.Data.sort()
+	return diff, nil
+}
+
+// jobsByID maps the jobs to their IDs
+func jobsByID(jobs []*CronJob) map[string]interface{} {
+	byID := make(map[string]interface{}, len(jobs))
+	for _, job := range jobs {
+		byID[job.ID] = job
+	}
+	return byID
+}
+
+// diffValues adds the values added, removed or changed between two maps to
+// changes as user/name, comparing them by their JSON encoding
+func diffValues[V any](changes *Changes, user string, before, after map[string]V) {
+	for name := range unionKeys(before, after) {
+		oldValue, existed := before[name]
+		newValue, exists := after[name]
+		switch {
+		case !existed:
+			changes.Added = append(changes.Added, user+"/"+name)
+		case !exists:
+			changes.Removed = append(changes.Removed, user+"/"+name)
+		default:
+			oldJSON, _ := json.Marshal(oldValue)
+			newJSON, _ := json.Marshal(newValue)
+			if string(oldJSON) != string(newJSON) {
+				changes.Changed = append(changes.Changed, user+"/"+name)
+			}
+		}
+	}
+}
+
+// unionKeys returns the keys of both maps
+func unionKeys[V any](a, b map[string]V) map[string]bool {
+	keys := make(map[string]bool, len(a)+len(b))
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+	return keys
+}
+
+// readObject reads the state of a user from its object
+func (h *History) readObject(user, hash string) (*UserData, error) {
+	data, err := os.ReadFile(h.objectFile(hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object of user %s: %w", user, err)
+	}
+	plain, _, err := unseal(data)
+	if err != nil {
+		return nil, fmt.Errorf("object of user %s: %w", user, err)
+	}
+	return decodeUser(user, plain)
+}
+
+// writeObject stores the encoded state of a user and returns its hash.
+// Objects are never changed, so one that exists is not written again, even
+// if it is sealed with an old key.
+func (h *History) writeObject(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := h.objectFile(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+	sealed, err := encryption.Seal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt object: %w", err)
+	}
+	return hash, replaceFile(path, sealed, 0644)
+}
+
+// commit records the changes of a save on top of the newest revision.
+// Changes map users to their encoded state, nil for deleted users.
+func (h *History) commit(changes map[string][]byte, principals []string, note string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	users := make(map[string]string)
+	if latest := h.latest(); latest != nil {
+		for user, hash := range latest.Users {
+			users[user] = hash
+		}
+	}
+	for user, data := range changes {
+		if data == nil {
+			delete(users, user)
+			continue
+		}
+		hash, err := h.writeObject(data)
+		if err != nil {
+			return err
+		}
+		users[user] = hash
+	}
+
+	return h.record(users, principals, note)
+}
+
+// sync records the complete state of the configuration unless the newest
+// revision has it already, e.g. when it was changed outside the server.
+// Users maps every user to its encoded state.
+func (h *History) sync(users map[string][]byte, principals []string, note string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	hashes := make(map[string]string, len(users))
+	for user, data := range users {
+		hash, err := h.writeObject(data)
+		if err != nil {
+			return err
+		}
+		hashes[user] = hash
+	}
+
+	return h.record(hashes, principals, note)
+}
+
+// record adds a revision with the users unless they are the same as in the
+// newest revision, then drops the oldest revisions beyond the maximum. The
+// mutex must be held.
+func (h *History) record(users map[string]string, principals []string, note string) error {
+	number := 1
+	var previous map[string]string
+	if latest := h.latest(); latest != nil {
+		number = latest.Number + 1
+		previous = latest.Users
+		if sameObjects(previous, users) {
+			return nil
+		}
+	}
+
+	diff, err := h.diff(previous, users)
+	if err != nil {
+		return err
+	}
+	summary := diff.Summary()
+	if note != "" {
+		summary = note + ": " + summary
+	}
+	if len(principals) == 0 {
+		principals = []string{SystemPrincipal}
+	}
+
+	rev := &revision{
+		Revision: Revision{
+			Number:     number,
+			Time:       time.Now().UTC(),
+			Principals: principals,
+			Summary:    summary,
+		},
+		Users: users,
+	}
+	data, err := json.MarshalIndent(rev, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal revision %d: %w", number, err)
+	}
+	if err := replaceFile(h.revisionFile(number), data, 0644); err != nil {
+		return err
+	}
+	h.revisions = append(h.revisions, rev)
+
+	return h.prune()
+}
+
+// sameObjects reports whether two maps of users to objects are equal
+func sameObjects(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for user, hash := range a {
+		if b[user] != hash {
+			return false
+		}
+	}
+	return true
+}
+
+// prune removes the oldest revisions beyond the maximum and the objects no
+// remaining revision refers to. The mutex must be held.
+func (h *History) prune() error {
+	if h.max <= 0 || len(h.revisions) <= h.max {
+		return nil
+	}
+
+	for _, rev := range h.revisions[:len(h.revisions)-h.max] {
+		if err := os.Remove(h.revisionFile(rev.Number)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove revision %d: %w", rev.Number, err)
+		}
+	}
+	h.revisions = append([]*revision(nil), h.revisions[len(h.revisions)-h.max:]...)
+
+	referenced := make(map[string]bool)
+	for _, rev := range h.revisions {
+		for _, hash := range rev.Users {
+			referenced[hash+".json"] = true
+		}
+	}
+	entries, err := os.ReadDir(h.objectsDir())
+	if err != nil {
+		return fmt.Errorf("failed to read objects: %w", err)
+	}
+	for _, entry := range entries {
+		if referenced[entry.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(h.objectsDir(), entry.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove object %s: %w", entry.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// SetHistory makes every save record a revision in the history. The
+// current users are recorded first unless the newest revision has them, so
+// changes made while the server was stopped get a revision of their own. A
+// read-only configuration is not recorded, as it is not what the store
+// holds.
+func (c *Config) SetHistory(history *History) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.history = history
+	if c.readOnly != "" {
+		return nil
+	}
+	return c.syncHistory([]string{SystemPrincipal}, "loaded on startup")
+}
+
+// History returns the history of the configuration, nil if it keeps none
+func (c *Config) History() *History {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.history
+}
+
+// syncHistory records the current users as a revision unless the newest
+// revision has them. The mutex must be held.
+func (c *Config) syncHistory(principals []string, note string) error {
+	if c.history == nil {
+		return nil
+	}
+
+	users := make(map[string][]byte, len(c.Users))
+	for user, userData := range c.Users {
+		data, err := json.Marshal(userData)
+		if err != nil {
+			return fmt.Errorf("failed to marshal user %s: %w", user, err)
+		}
+		users[user] = data
+	}
+	return c.history.sync(users, principals, note)
+}
+
+// Attribute names a principal in the next revision, as having made changes
+// that are not saved yet. Without unsaved changes there is nothing to
+// attribute, e.g. because the change was saved right away.
+func (c *Config) Attribute(principal string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.Changed {
+		c.attribute(principal)
+	}
+}
+
+// attribute names a principal in the next revision. The mutex must be held
+// for writing.
+func (c *Config) attribute(principal string) {
+	if principal == "" {
+		return
+	}
+	if c.principals == nil {
+		c.principals = make(map[string]bool)
+	}
+	c.principals[principal] = true
+}
+
+// takePrincipals returns the principals named by Attribute, sorted, and
+// forgets them. The mutex must be held for writing.
+func (c *Config) takePrincipals() []string {
+	principals := make([]string, 0, len(c.principals))
+	for principal := range c.principals {
+		principals = append(principals, principal)
+	}
+	sort.Strings(principals)
+	c.principals = nil
+	return principals
+}
+
+// Rollback replaces the users by those of a revision and saves them, which
+// records a new revision, so a rollback can be undone. The principal is
+// named in it. A read-only configuration becomes writable again.
+func (c *Config) Rollback(number int, principal string) error {
+	history := c.History()
+	if history == nil {
+		return ErrNoHistory
+	}
+	users, err := history.Users(number)
+	if err != nil {
+		return err
+	}
+
+	c.restore(users, principal, fmt.Sprintf("rollback to revision %d", number))
+	return c.Save()
+}
+
+// restore replaces all users and makes a read-only configuration writable
+// again, naming the principal and the note in the next revision. It is one
+// change, so no save in between records it without them.
+func (c *Config) restore(users map[string]*UserData, principal, note string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.replaceUsers(users)
+	c.changed(JournalEntry{Op: OpReplaceAll, Users: users})
+	c.readOnly = ""
+	c.attribute(principal)
+	c.note = note
+}
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// Journal operations
+const (
+	// OpPutUser stores the complete state of a user or organization
+	OpPutUser = "put_user"
+	// OpDeleteUser deletes a user and removes it from all organizations
+	OpDeleteUser = "delete_user"
+	// OpSetData sets one data key of a user
+	OpSetData = "set_data"
+	// OpDeleteData deletes one data key of a user
+	OpDeleteData = "delete_data"
+	// OpReplaceAll replaces all users, e.g. when a backup is restored
+	OpReplaceAll = "replace_all"
+)
+
+// JournalEntry is one change of the configuration. Entries carry the new
+// state rather than a difference, so replaying an entry that is already
+// part of the snapshot changes nothing.
+type JournalEntry struct {
+	Op    string               `json:"op"`
+	User  string               `json:"user,omitempty"`
+	Key   string               `json:"key,omitempty"`
+	Value interface{}          `json:"value,omitempty"`
+	Data  *UserData            `json:"data,omitempty"`
+	Users map[string]*UserData `json:"users,omitempty"`
+}
+
+// Journal is an append-only file of the changes made since the
+// configuration was last saved. Changes append their entries while the
+// configuration is locked, and Sync writes them to disk before the change
+// is acknowledged, so a crash between two saves loses nothing.
+type Journal struct {
+	// mutex guards the entries not written yet and the size
+	mutex   sync.Mutex
+	pending []byte
+	// err is why an entry could not be appended, returned by the next Sync
+	err error
+	// fileMutex serializes writing, syncing and compacting the file, which
+	// happens without the configuration locked
+	fileMutex sync.Mutex
+	path      string
+	file      *os.File
+	// size is the length of the valid entries in the file
+	size int64
+}
+
+// OpenJournal opens or creates the journal at path
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal %s: %w", path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat journal %s: %w", path, err)
+	}
+
+	return &Journal{path: path, file: file, size: info.Size()}, nil
+}
+
+// Append encodes an entry and queues it for the next Sync. With encryption
+// the entry is sealed, one line per entry. It does not touch the file, so
+// it is cheap enough to call with the configuration locked, which keeps
+// the entries in the order of the changes.
+func (j *Journal) Append(entry JournalEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		err = fmt.Errorf("failed to marshal journal entry: %w", err)
+	} else if line, err = encryption.SealLine(line); err != nil {
+		err = fmt.Errorf("failed to encrypt journal entry: %w", err)
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if err != nil {
+		if j.err == nil {
+			j.err = err
+		}
+		return
+	}
+	j.pending = append(j.pending, line...)
+	j.pending = append(j.pending, '\n')
+}
+
+// Sync writes the appended entries to the file and syncs it to disk. It
+// returns an error if an entry appended since the last Sync is not on
+// disk; such a change is still saved with the next snapshot. Entries that
+// failed to be written are retried by the next Sync.
+func (j *Journal) Sync() error {
+	j.fileMutex.Lock()
+	defer j.fileMutex.Unlock()
+
+	j.mutex.Lock()
+	pending, appendErr := j.pending, j.err
+	j.pending, j.err = nil, nil
+	j.mutex.Unlock()
+
+	if len(pending) > 0 {
+		if err := j.write(pending); err != nil {
+			j.mutex.Lock()
+			j.pending = append(pending, j.pending...)
+			j.mutex.Unlock()
+			return err
+		}
+	}
+	return appendErr
+}
+
+// write writes and syncs entries at the end of the file. A partly written
+// entry is cut off again, so it does not corrupt the entries after it. The
+// file mutex must be held.
+func (j *Journal) write(entries []byte) error {
+	if _, err := j.file.Write(entries); err != nil {
+		j.file.Truncate(j.size)
+		return fmt.Errorf("failed to write journal %s: %w", j.path, err)
+	}
+	if err := j.file.Sync(); err != nil {
+		j.file.Truncate(j.size)
+		return fmt.Errorf("failed to sync journal %s: %w", j.path, err)
+	}
+
+	j.mutex.Lock()
+	j.size += int64(len(entries))
+	j.mutex.Unlock()
+	return nil
+}
+
+// Size returns the length of the journal in bytes, including the entries
+// not written yet
+func (j *Journal) Size() int64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.size + int64(len(j.pending))
+}
+
+// Replay applies the entries of the journal to the configuration and
+// returns how many were applied. An incomplete last entry, left by a crash
+// while it was written, is discarded.
+func (j *Journal) Replay(c *Config) (int, error) {
+	j.fileMutex.Lock()
+	defer j.fileMutex.Unlock()
+
+	j.mutex.Lock()
+	size := j.size
+	j.mutex.Unlock()
+
+	reader := bufio.NewReader(io.NewSectionReader(j.file, 0, size))
+	var offset int64
+	count := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("Discarding incomplete entry at the end of journal %s", j.path)
+				if err := j.file.Truncate(offset); err != nil {
+					return count, fmt.Errorf("failed to truncate journal %s: %w", j.path, err)
+				}
+				j.mutex.Lock()
+				j.size = offset
+				j.mutex.Unlock()
+			}
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to read journal %s: %w", j.path, err)
+		}
+
+		plain, err := encryption.OpenLine(line)
+		if err != nil {
+			return count, fmt.Errorf("invalid entry at offset %d of journal %s: %w", offset, j.path, err)
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(plain, &entry); err != nil {
+			return count, fmt.Errorf("invalid entry at offset %d of journal %s: %w", offset, j.path, err)
+		}
+		if err := c.apply(entry); err != nil {
+			return count, fmt.Errorf("invalid entry at offset %d of journal %s: %w", offset, j.path, err)
+		}
+		offset += int64(len(line))
+		count++
+	}
+
+	return count, nil
+}
+
+// Compact drops the first offset bytes of the journal, which hold the
+// entries contained in the configuration saved last. Entries
+// appended since are kept and written with the rest.
+func (j *Journal) Compact(offset int64) error {
+	j.fileMutex.Lock()
+	defer j.fileMutex.Unlock()
+
+	if offset == 0 {
+		return nil
+	}
+
+	j.mutex.Lock()
+	size, pending := j.size, j.pending
+	j.pending = nil
+	j.mutex.Unlock()
+
+	var rest []byte
+	if offset <= size {
+		rest = make([]byte, size-offset, size-offset+int64(len(pending)))
+		if _, err := j.file.ReadAt(rest, offset); err != nil && err != io.EOF {
+			j.requeue(pending)
+			return fmt.Errorf("failed to read journal %s: %w", j.path, err)
+		}
+		rest = append(rest, pending...)
+	} else {
+		rest = append([]byte(nil), pending[offset-size:]...)
+	}
+	if err := replaceFile(j.path, rest, 0600); err != nil {
+		j.requeue(pending)
+		return err
+	}
+
+	file, err := os.OpenFile(j.path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		// The entries are on disk, but cannot be appended to
+		j.mutex.Lock()
+		j.err = fmt.Errorf("failed to reopen journal %s: %w", j.path, err)
+		j.mutex.Unlock()
+		return j.err
+	}
+	j.file.Close()
+	j.file = file
+	j.mutex.Lock()
+	j.size = int64(len(rest))
+	j.mutex.Unlock()
+
+	return nil
+}
+
+// requeue puts entries that could not be written back in front of the
+// entries appended since
+func (j *Journal) requeue(entries []byte) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.pending = append(entries, j.pending...)
+}
+
+// Close writes the appended entries and closes the journal file
+func (j *Journal) Close() error {
+	syncErr := j.Sync()
+
+	j.fileMutex.Lock()
+	defer j.fileMutex.Unlock()
+
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	return syncErr
+}
+
+// SetJournal makes every change of the configuration be appended to the
+// journal, and every save compact it
+func (c *Config) SetJournal(journal *Journal) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.journal = journal
+}
+
+// SyncJournal writes the changes made so far to the journal on disk. It is
+// called before a change is acknowledged, without the mutex held, so
+// concurrent changes share one sync. An error means the change is only in
+// memory until the next save.
+func (c *Config) SyncJournal() error {
+	c.mutex.RLock()
+	journal := c.journal
+	c.mutex.RUnlock()
+
+	if journal == nil {
+		return nil
+	}
+	return journal.Sync()
+}
+
+// changed marks the configuration as changed and appends the change to the
+// journal, to be written by the next SyncJournal. The mutex must be held
+// for writing.
+func (c *Config) changed(entry JournalEntry) {
+	c.Changed = true
+	c.entryDirty(entry)
+	if c.journal != nil {
+		c.journal.Append(entry)
+	}
+}
+
+// userChanged journals the complete state of a user or organization. The
+// mutex must be held for writing.
+func (c *Config) userChanged(user string) {
+	c.changed(JournalEntry{Op: OpPutUser, User: user, Data: c.Users[user]})
+}
+
+// apply applies a journal entry without journaling it again
+func (c *Config) apply(entry JournalEntry) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch entry.Op {
+	case OpPutUser:
+		if entry.Data == nil {
+			return errors.New("put_user without data")
+		}
+		c.Users[entry.User] = entry.Data
+	case OpDeleteUser:
+		c.deleteUser(entry.User)
+	case OpSetData:
+		c.userData(entry.User).Data[entry.Key] = entry.Value
+	case OpDeleteData:
+		if userData, exists := c.Users[entry.User]; exists {
+			delete(userData.Data, entry.Key)
+		}
+	case OpReplaceAll:
+		c.replaceUsers(entry.Users)
+	default:
+		return fmt.Errorf("unknown operation %q", entry.Op)
+	}
+
+	c.Changed = true
+	c.entryDirty(entry)
+	return nil
+}
+
+// entryDirty marks the users changed by a journal entry as dirty. The mutex
+// must be held for writing.
+func (c *Config) entryDirty(entry JournalEntry) {
+	if entry.Op == OpReplaceAll {
+		for user := range entry.Users {
+			c.markDirty(user)
+		}
+		return
+	}
+	c.markDirty(entry.User)
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"sort"
+	"strings"
+)
+
+// OrgPrefix marks the namespaces of organizations in the Users map. An
+// organization owns jobs and data like a user, but has members instead of
+// keys.
+const OrgPrefix = "@"
+
+// IsOrg reports whether a namespace belongs to an organization
+func IsOrg(namespace string) bool {
+	return strings.HasPrefix(namespace, OrgPrefix)
+}
+
+// OrgNamespace returns the namespace of an organization name, with or
+// without the prefix
+func OrgNamespace(name string) string {
+	if IsOrg(name) {
+		return name
+	}
+	return OrgPrefix + name
+}
+
+// CreateOrg creates an organization without members, returning false if
+// the namespace is taken
+func (c *Config) CreateOrg(org string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	namespace := OrgNamespace(org)
+	if _, exists := c.Users[namespace]; exists {
+		return false
+	}
+
+	c.Users[namespace] = &UserData{
+		Cron:    make([]*CronJob, 0),
+		Data:    make(map[string]interface{}),
+		Members: make(map[string]string),
+	}
+	c.userChanged(namespace)
+
+	return true
+}
+
+// GetAllOrgs returns the names of all organizations in sorted order
+func (c *Config) GetAllOrgs() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	orgs := make([]string, 0)
+	for namespace := range c.Users {
+		if IsOrg(namespace) {
+			orgs = append(orgs, strings.TrimPrefix(namespace, OrgPrefix))
+		}
+	}
+	sort.Strings(orgs)
+
+	return orgs
+}
+
+// GetOrgMembers returns a copy of the members of an organization and their
+// roles
+func (c *Config) GetOrgMembers(org string) (map[string]string, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	orgData, exists := c.Users[OrgNamespace(org)]
+	if !exists {
+		return nil, false
+	}
+
+	members := make(map[string]string, len(orgData.Members))
+	for user, role := range orgData.Members {
+		members[user] = role
+	}
+
+	return members, true
+}
+
+// GetMemberRole returns the role of a user in an organization
+func (c *Config) GetMemberRole(org, user string) (string, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	orgData, exists := c.Users[OrgNamespace(org)]
+	if !exists {
+		return "", false
+	}
+
+	role, isMember := orgData.Members[user]
+	return role, isMember
+}
+
+// SetOrgMember adds a user to an organization or changes their role
+func (c *Config) SetOrgMember(org, user, role string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	orgData, exists := c.Users[OrgNamespace(org)]
+	if !exists {
+		return false
+	}
+
+	if orgData.Members == nil {
+		orgData.Members = make(map[string]string)
+	}
+	orgData.Members[user] = role
+	c.userChanged(OrgNamespace(org))
+
+	return true
+}
+
+// DeleteOrgMember removes a user from an organization
+func (c *Config) DeleteOrgMember(org, user string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	orgData, exists := c.Users[OrgNamespace(org)]
+	if !exists {
+		return false
+	}
+
+	if _, isMember := orgData.Members[user]; !isMember {
+		return false
+	}
+	delete(orgData.Members, user)
+	c.userChanged(OrgNamespace(org))
+
+	return true
+}
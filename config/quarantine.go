@@ -0,0 +1,63 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Load and save errors
+var (
+	// ErrUnreadableConfig is returned when the configuration file exists but
+	// cannot be read
+	ErrUnreadableConfig = errors.New("configuration file unreadable")
+	// ErrInvalidConfig is returned when the configuration file is not a
+	// valid configuration, e.g. because it was truncated
+	ErrInvalidConfig = errors.New("configuration file invalid")
+	// ErrReadOnly is returned when saving a read-only configuration
+	ErrReadOnly = errors.New("configuration is read-only")
+)
+
+// quarantineSuffix is inserted between the file name and the timestamp of
+// a quarantined file
+const quarantineSuffix = ".corrupt-"
+
+// Quarantine moves a broken file aside to a timestamped name, so it is
+// neither loaded nor overwritten, and returns the new path
+func Quarantine(path string, now time.Time) (string, error) {
+	quarantined := path + quarantineSuffix + now.UTC().Format("20060102T150405Z")
+	if err := os.Rename(path, quarantined); err != nil {
+		return "", fmt.Errorf("failed to quarantine %s: %w", path, err)
+	}
+	return quarantined, nil
+}
+
+// ListQuarantined returns the quarantined versions of a file, oldest first
+func ListQuarantined(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + quarantineSuffix + "*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// SetReadOnly makes the configuration read-only for the given reason, so
+// it is never saved. An empty reason makes it writable again.
+func (c *Config) SetReadOnly(reason string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.readOnly = reason
+}
+
+// ReadOnly returns why the configuration is read-only, if it is
+func (c *Config) ReadOnly() (string, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.readOnly, c.readOnly != ""
+}
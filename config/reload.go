@@ -0,0 +1,129 @@
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+)
+
+// Changes lists what was added, removed or changed, e.g. the users changed
+// by a reload
+type Changes struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// WatchedStore is implemented by stores that can tell whether their data
+// was changed by someone else since it was last loaded or saved, e.g. by
+// editing the configuration file
+type WatchedStore interface {
+	Modified() (bool, error)
+}
+
+// StoreModified reports whether the store was changed by someone else
+// since it was last loaded or saved. Stores that cannot tell are never
+// modified.
+func (c *Config) StoreModified() (bool, error) {
+	watched, ok := c.Store().(WatchedStore)
+	if !ok {
+		return false, nil
+	}
+	return watched.Modified()
+}
+
+// Reload replaces the users by those in the store and returns what changed.
+// Users changed since the last save keep their changes, which the next
+// save writes over the store, and their journal entries are kept. The
+// users of the store are recorded as a revision by the principal if they
+// differ from the newest one. A read-only configuration becomes writable
+// again, as the store could be loaded; having been read-only it has no
+// changes to keep.
+func (c *Config) Reload(principal string) (Changes, error) {
+	// No save may run between loading and replacing the users
+	c.saving.Lock()
+	defer c.saving.Unlock()
+
+	store := c.Store()
+	if store == nil {
+		return Changes{}, ErrNoStore
+	}
+	users, err := store.Load()
+	if err != nil {
+		return Changes{}, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	before := c.Users
+	if c.readOnly != "" {
+		c.dirty = nil
+		c.Changed = false
+		c.principals = nil
+		c.note = ""
+		c.readOnly = ""
+	}
+	c.Users = users
+
+	if err := c.syncHistory([]string{principal}, "reloaded from the store"); err != nil {
+		log.Printf("Error recording configuration revision: %v", err)
+	}
+
+	// Unsaved changes are newer than the store
+	for user := range c.dirty {
+		if userData, exists := before[user]; exists {
+			c.Users[user] = userData
+		} else {
+			delete(c.Users, user)
+		}
+	}
+	diff := diffUsers(before, c.Users)
+
+	// Without unsaved changes the store holds every change
+	if c.journal != nil && len(c.dirty) == 0 {
+		if err := c.journal.Compact(c.journal.Size()); err != nil {
+			return diff, err
+		}
+	}
+	c.markStale(store)
+
+	return diff, nil
+}
+
+// diffUsers compares the users by their JSON encoding
+func diffUsers(before, after map[string]*UserData) Changes {
+	diff := newChanges()
+	for user := range before {
+		if _, exists := after[user]; !exists {
+			diff.Removed = append(diff.Removed, user)
+		}
+	}
+	for user, userData := range after {
+		old, exists := before[user]
+		if !exists {
+			diff.Added = append(diff.Added, user)
+			continue
+		}
+		oldJSON, _ := json.Marshal(old)
+		newJSON, _ := json.Marshal(userData)
+		if string(oldJSON) != string(newJSON) {
+			diff.Changed = append(diff.Changed, user)
+		}
+	}
+
+	diff.sort()
+	return diff
+}
+
+// newChanges returns empty changes, which encode as empty lists
+func newChanges() Changes {
+	return Changes{Added: []string{}, Removed: []string{}, Changed: []string{}}
+}
+
+// sort sorts the lists of changes
+func (c Changes) sort() {
+	sort.Strings(c.Added)
+	sort.Strings(c.Removed)
+	sort.Strings(c.Changed)
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// SchemaVersion is the version of the configuration file written by this
+// server. Files of older versions are migrated when they are loaded.
+const SchemaVersion = 1
+
+// ErrNewerSchema is returned for configuration files written by a newer
+// server, which this one cannot read without losing what it does not know
+var ErrNewerSchema = fmt.Errorf("%w: written by a newer server", ErrUnreadableConfig)
+
+// migrations upgrade a configuration file from the version of their index
+// to the next one, so a file of any version is upgraded step by step. A
+// migration gets and returns the fields of the file besides the version.
+var migrations = []func(document map[string]json.RawMessage) (map[string]json.RawMessage, error){
+	// Version 0 has no envelope, the users are the whole file
+	func(document map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+		users, err := json.Marshal(document)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]json.RawMessage{"users": users}, nil
+	},
+}
+
+// schemaVersion returns the version of a configuration file. Files without
+// a version are version 0, whose users may include one named "version", but
+// never as a number.
+func schemaVersion(document map[string]json.RawMessage) (int, error) {
+	raw, exists := document["version"]
+	if !exists {
+		return 0, nil
+	}
+	var version json.Number
+	if err := json.Unmarshal(raw, &version); err != nil {
+		// A user named version
+		return 0, nil
+	}
+	n, err := strconv.Atoi(version.String())
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%w: invalid version %s", ErrInvalidConfig, version)
+	}
+	return n, nil
+}
+
+// migrate upgrades a configuration file to SchemaVersion and returns its
+// users and the version it had
+func migrate(data []byte) (map[string]json.RawMessage, int, error) {
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if document == nil {
+		return nil, 0, fmt.Errorf("%w: no users", ErrInvalidConfig)
+	}
+
+	version, err := schemaVersion(document)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version > SchemaVersion {
+		return nil, 0, fmt.Errorf("%w: version %d, expected at most %d", ErrNewerSchema, version, SchemaVersion)
+	}
+	if version > 0 {
+		delete(document, "version")
+	}
+
+	for v := version; v < SchemaVersion; v++ {
+		if document, err = migrations[v](document); err != nil {
+			return nil, 0, fmt.Errorf("%w: failed to migrate from version %d: %w", ErrInvalidConfig, v, err)
+		}
+	}
+
+	var users map[string]json.RawMessage
+	if raw, exists := document["users"]; exists {
+		if err := json.Unmarshal(raw, &users); err != nil {
+			return nil, 0, fmt.Errorf("%w: users: %w", ErrInvalidConfig, err)
+		}
+	}
+	if users == nil {
+		return nil, 0, fmt.Errorf("%w: no users", ErrInvalidConfig)
+	}
+	return users, version, nil
+}
+
+// migrationBackupPath returns the path the file of an older version is
+// kept at when it is first saved in the current version
+func migrationBackupPath(path string, version int) string {
+	return path + ".v" + strconv.Itoa(version)
+}
+
+// keepMigrationBackup keeps the file of an older version before it is
+// replaced. A backup kept before is never overwritten, as it holds the
+// file as it was before the first migration.
+func keepMigrationBackup(path string, version int, data []byte) error {
+	backup := migrationBackupPath(path, version)
+	if _, err := os.Stat(backup); err == nil || !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return replaceFile(backup, data, 0644)
+}
@@ -0,0 +1,276 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ErrNoStore is returned when saving a configuration without a store
+var ErrNoStore = errors.New("configuration has no store")
+
+// Store names accepted by OpenStore
+const (
+	StoreFile = "file"
+	StoreBolt = "bolt"
+	StoreDir  = "dir"
+)
+
+// Store persists the users of a configuration. The configuration is kept
+// in memory; a store only loads it on startup and saves what changed.
+type Store interface {
+	// Load returns all stored users. Before anything was saved it returns
+	// an error wrapping os.ErrNotExist; data that cannot be read or decoded
+	// returns an error wrapping ErrUnreadableConfig or ErrInvalidConfig.
+	Load() (map[string]*UserData, error)
+	// Save stores the JSON encoding of the users changed since the last
+	// save, nil for deleted users. Every user is stored atomically; when
+	// Save fails, the changes are passed again with the next save.
+	Save(changes map[string][]byte) error
+	// Close releases the store
+	Close() error
+}
+
+// OpenStore opens the store of the given kind at path, which is the JSON
+// file of the file store, the database of the bolt store or the directory
+// of the dir store
+func OpenStore(kind, path string) (Store, error) {
+	switch kind {
+	case StoreFile:
+		return NewFileStore(path), nil
+	case StoreBolt:
+		return OpenBoltStore(path)
+	case StoreDir:
+		return NewDirStore(path), nil
+	}
+	return nil, fmt.Errorf("unknown store %q, expected %s, %s or %s", kind, StoreFile, StoreBolt, StoreDir)
+}
+
+// FileStore keeps all users in one JSON, YAML or TOML file, which is
+// rewritten on every save
+type FileStore struct {
+	mutex  sync.Mutex
+	path   string
+	format string
+	// users holds the encoding of every user as last loaded or saved
+	users map[string][]byte
+	// stamp and hash identify the file as last loaded or saved
+	stamp fileStamp
+	hash  [sha256.Size]byte
+	// stale is set if the file was loaded in plaintext or sealed with an
+	// old key
+	stale bool
+	// version is the schema version of the file as last loaded or saved;
+	// original holds the file as loaded if its version is older, so it is
+	// kept when the next save replaces it
+	version  int
+	original []byte
+}
+
+// fileStamp is the modification time and size of a file, which change
+// when it is written
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+// stampOf returns the stamp of a file
+func stampOf(info os.FileInfo) fileStamp {
+	return fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}
+}
+
+// NewFileStore returns a store for the file at path, in the format of its
+// extension
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path, format: FormatOf(path), users: make(map[string][]byte)}
+}
+
+// Path returns the path of the file
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+// Load reads the file. A missing file returns the error of os.ReadFile.
+func (s *FileStore) Load() (map[string]*UserData, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", ErrUnreadableConfig, err)
+	}
+	plain, stale, err := unseal(data)
+	if err != nil {
+		return nil, err
+	}
+	plain, err = ToJSON(s.format, plain)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	raw, version, err := migrate(plain)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]*UserData, len(raw))
+	encoded := make(map[string][]byte, len(raw))
+	for user, rawUser := range raw {
+		userData, err := decodeUser(user, rawUser)
+		if err != nil {
+			return nil, err
+		}
+		var compact bytes.Buffer
+		json.Compact(&compact, rawUser)
+		users[user] = userData
+		encoded[user] = compact.Bytes()
+	}
+
+	s.mutex.Lock()
+	s.users = encoded
+	s.remember(data)
+	s.stale = stale
+	s.version, s.original = version, nil
+	if version < SchemaVersion {
+		s.original = data
+	}
+	s.mutex.Unlock()
+
+	return users, nil
+}
+
+// Save merges the changes into the users last loaded or saved and replaces
+// the file with all of them. The previous file is kept as a backup.
+func (s *FileStore) Save(changes map[string][]byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for user, data := range changes {
+		if data == nil {
+			delete(s.users, user)
+		} else {
+			s.users[user] = data
+		}
+	}
+
+	// Assemble the file like json.MarshalIndent of the versioned users map
+	// would
+	names := make([]string, 0, len(s.users))
+	for user := range s.users {
+		names = append(names, user)
+	}
+	sort.Strings(names)
+
+	var compact bytes.Buffer
+	fmt.Fprintf(&compact, `{"version":%d,"users":{`, SchemaVersion)
+	for i, user := range names {
+		if i > 0 {
+			compact.WriteByte(',')
+		}
+		key, _ := json.Marshal(user)
+		compact.Write(key)
+		compact.WriteByte(':')
+		compact.Write(s.users[user])
+	}
+	compact.WriteString("}}")
+
+	data, err := FromJSON(s.format, compact.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	sealed, err := encryption.Seal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt config: %w", err)
+	}
+	if s.original != nil {
+		if err := keepMigrationBackup(s.path, s.version, s.original); err != nil {
+			return fmt.Errorf("failed to keep config file %s of version %d: %w", s.path, s.version, err)
+		}
+	}
+	if err := writeFileAtomic(s.path, sealed, 0644, maxBackups); err != nil {
+		return fmt.Errorf("failed to write config file %s: %w", s.path, err)
+	}
+	s.remember(sealed)
+	s.stale = false
+	s.version, s.original = SchemaVersion, nil
+
+	return nil
+}
+
+// Stale returns all users if the file was loaded in plaintext or sealed
+// with an old key, as the whole file is sealed at once
+func (s *FileStore) Stale() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.stale {
+		return nil
+	}
+	users := make([]string, 0, len(s.users))
+	for user := range s.users {
+		users = append(users, user)
+	}
+	return users
+}
+
+// remember records the file as loaded or saved with data. The mutex must be
+// held.
+func (s *FileStore) remember(data []byte) {
+	if info, err := os.Stat(s.path); err == nil {
+		s.stamp = stampOf(info)
+	}
+	s.hash = sha256.Sum256(data)
+}
+
+// Modified reports whether the file was written by someone else since it
+// was last loaded or saved. A missing file is not modified, as there is
+// nothing to load.
+func (s *FileStore) Modified() (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if stampOf(info) == s.stamp {
+		return false, nil
+	}
+
+	// Touched files are not modified
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, err
+	}
+	if sha256.Sum256(data) == s.hash {
+		s.stamp = stampOf(info)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Close does nothing, the file is only open while it is read or written
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// decodeUser decodes the stored JSON encoding of a user
+func decodeUser(user string, data []byte) (*UserData, error) {
+	var userData *UserData
+	if err := json.Unmarshal(data, &userData); err != nil {
+		return nil, fmt.Errorf("%w: user %s: %w", ErrInvalidConfig, user, err)
+	}
+	if userData == nil {
+		return nil, fmt.Errorf("%w: user %s is null", ErrInvalidConfig, user)
+	}
+	return userData, nil
+}
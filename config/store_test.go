@@ -0,0 +1,340 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// storeOpener opens the store kept in dir, the same one on every call
+type storeOpener func(t *testing.T, dir string) Store
+
+func TestFileStore(t *testing.T) {
+	testStore(t, func(t *testing.T, dir string) Store {
+		return NewFileStore(filepath.Join(dir, "config.json"))
+	})
+	t.Run("YAML", func(t *testing.T) {
+		testStore(t, func(t *testing.T, dir string) Store {
+			return NewFileStore(filepath.Join(dir, "config.yaml"))
+		})
+	})
+}
+
+func TestFileFormats(t *testing.T) {
+	for path, expected := range map[string]string{
+		"config.json":     FormatJSON,
+		"config.yml":      FormatYAML,
+		"config.yaml.2":   FormatYAML,
+		"/etc/app.TOML":   FormatTOML,
+		"config.toml.bak": FormatJSON,
+		"config":          FormatJSON,
+	} {
+		if format := FormatOf(path); format != expected {
+			t.Errorf("FormatOf(%q) = %s, expected %s", path, format, expected)
+		}
+	}
+
+	// Hand-written files with comments are loaded
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yaml": "# Bootstrap users\nalice:\n  cron:\n    - id: job1 # hourly\n      cron: \"0 * * * *\"\n      url: https://example.com\n      active: true\n  data:\n    since: 2024-01-01T00:00:00Z\n",
+		"config.toml": "# Bootstrap users\n[alice]\ndata = { since = 2024-01-01T00:00:00Z }\n\n[[alice.cron]]\nid = \"job1\" # hourly\ncron = \"0 * * * *\"\nurl = \"https://example.com\"\nactive = true\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0644)
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("LoadConfig(%s) failed: %v", name, err)
+		}
+		if job, exists := cfg.GetUserJob("alice", "job1"); !exists || job.URL != "https://example.com" || !job.Active {
+			t.Errorf("LoadConfig(%s) returned job %v, expected job1", name, job)
+		}
+		if since, _ := cfg.GetUserData("alice", "since"); since != "2024-01-01T00:00:00Z" {
+			t.Errorf("LoadConfig(%s) returned %v for a time, expected a string like in JSON", name, since)
+		}
+
+		// Saving keeps the format and all values
+		cfg.SetUserData("alice", "settings", map[string]interface{}{"theme": "dark", "size": float64(2), "tags": []interface{}{"a", "1"}})
+		cfg.SetUserData("alice", "unset", nil)
+		if err := SaveConfig(cfg, path); err != nil {
+			t.Fatalf("SaveConfig(%s) failed: %v", name, err)
+		}
+		data, _ := os.ReadFile(path)
+		if bytes.HasPrefix(data, []byte("{")) {
+			t.Errorf("SaveConfig(%s) wrote JSON: %s", name, data)
+		}
+		loaded, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("LoadConfig(%s) failed after save: %v", name, err)
+		}
+		if value, _ := loaded.GetUserData("alice", "settings"); !reflect.DeepEqual(value, map[string]interface{}{"theme": "dark", "size": float64(2), "tags": []interface{}{"a", "1"}}) {
+			t.Errorf("LoadConfig(%s) returned %v after save, expected the saved value", name, value)
+		}
+	}
+
+	// TOML has no null, so null fields are left out
+	if _, err := FromJSON(FormatTOML, []byte(`{"alice":{"data":{"k":null}}}`)); err != nil {
+		t.Errorf("FromJSON() failed for a null field: %v", err)
+	}
+	if _, err := FromJSON(FormatTOML, []byte(`{"alice":{"data":{"k":[1,null]}}}`)); err == nil {
+		t.Error("FromJSON() returned no error for a null array element")
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	testStore(t, func(t *testing.T, dir string) Store {
+		store, err := OpenBoltStore(filepath.Join(dir, "config.db"))
+		if err != nil {
+			t.Fatalf("OpenBoltStore() failed: %v", err)
+		}
+		return store
+	})
+}
+
+func TestDirStore(t *testing.T) {
+	testStore(t, func(t *testing.T, dir string) Store {
+		return NewDirStore(filepath.Join(dir, "users"))
+	})
+
+	// User names cannot leave the directory, temporary files are skipped
+	dir := t.TempDir()
+	store := NewDirStore(dir)
+	if err := store.Save(map[string][]byte{"../alice": []byte(`{"cron":[],"data":{}}`)}); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "..%2Falice.json")); err != nil {
+		t.Errorf("Save() did not escape the user name: %v", err)
+	}
+	os.WriteFile(filepath.Join(dir, ".bob.json.tmp-123"), []byte("{"), 0644)
+	if users, err := store.Load(); err != nil || len(users) != 1 || users["../alice"] == nil {
+		t.Errorf("Load() returned %v, %v, expected ../alice", users, err)
+	}
+}
+
+func TestStoreBackups(t *testing.T) {
+	dir := t.TempDir()
+
+	// The file store keeps its previous generations
+	cfg := NewConfig()
+	cfg.SetStore(NewFileStore(filepath.Join(dir, "config.json")))
+	cfg.CreateUser("alice")
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	cfg.CreateUser("bob")
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	if backups, err := cfg.Backups(); err != nil || len(backups) != 1 {
+		t.Fatalf("Backups() returned %+v, %v, expected generation 1", backups, err)
+	}
+	if err := cfg.RestoreBackup(1, SystemPrincipal); err != nil {
+		t.Fatalf("RestoreBackup() failed: %v", err)
+	}
+	if cfg.GetUser("bob") != nil {
+		t.Error("RestoreBackup() did not restore generation 1")
+	}
+
+	// Other stores keep none, and their backups are never read from a file
+	store, err := OpenBoltStore(filepath.Join(dir, "config.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltStore() failed: %v", err)
+	}
+	defer store.Close()
+	for _, store := range []Store{store, NewDirStore(filepath.Join(dir, "users"))} {
+		cfg := NewConfig()
+		cfg.SetStore(store)
+		if _, err := cfg.Backups(); !errors.Is(err, ErrNoBackups) {
+			t.Errorf("Backups() error = %v for %T, expected ErrNoBackups", err, store)
+		}
+		if err := cfg.RestoreBackup(1, SystemPrincipal); !errors.Is(err, ErrNoBackups) {
+			t.Errorf("RestoreBackup() error = %v for %T, expected ErrNoBackups", err, store)
+		}
+	}
+}
+
+func TestEncryptedStores(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, KeySize), bytes.Repeat([]byte{2}, KeySize)
+	cipher, err := NewCipher(oldKey)
+	if err != nil {
+		t.Fatalf("NewCipher() failed: %v", err)
+	}
+	SetEncryption(cipher)
+	defer SetEncryption(nil)
+
+	t.Run("File", TestFileStore)
+	t.Run("Bolt", TestBoltStore)
+	t.Run("Dir", TestDirStore)
+
+	// Nothing is written in plaintext
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	cfg := NewConfig()
+	cfg.SetStore(NewFileStore(path))
+	cfg.SetUserData("alice", "token", "secret-token")
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	journal, _ := OpenJournal(path + ".journal")
+	cfg.SetJournal(journal)
+	cfg.SetUserData("alice", "token", "secret-token-2")
+	journal.Close()
+	for _, file := range []string{path, path + ".journal"} {
+		if data, _ := os.ReadFile(file); len(data) == 0 || bytes.Contains(data, []byte("secret-token")) {
+			t.Errorf("%s is not encrypted: %q", filepath.Base(file), data)
+		}
+	}
+
+	// After a key rotation, the old key is still read and the next save
+	// seals with the new key
+	cipher, _ = NewCipher(newKey, oldKey)
+	SetEncryption(cipher)
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed with the old key: %v", err)
+	}
+	if !loaded.Changed {
+		t.Error("LoadConfig() did not mark the configuration sealed with the old key as changed")
+	}
+	if err := loaded.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+	cipher, _ = NewCipher(newKey)
+	SetEncryption(cipher)
+	if loaded, err := LoadConfig(path); err != nil || loaded.Changed {
+		t.Errorf("LoadConfig() returned %v after the rotation, expected the new key only", err)
+	}
+
+	// Without the key the configuration cannot be read, which is not
+	// corruption
+	SetEncryption(nil)
+	if _, err := LoadConfig(path); !errors.Is(err, ErrUnreadableConfig) || !errors.Is(err, ErrNoEncryptionKey) {
+		t.Errorf("LoadConfig() returned %v without a key, expected %v", err, ErrNoEncryptionKey)
+	}
+}
+
+// testStore is the conformance suite every Store must pass
+func testStore(t *testing.T, open storeOpener) {
+	t.Run("Empty", func(t *testing.T) {
+		store := open(t, t.TempDir())
+		defer store.Close()
+
+		if _, err := store.Load(); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Load() returned %v before the first save, expected %v", err, os.ErrNotExist)
+		}
+
+		// An empty save creates an empty configuration
+		if err := store.Save(nil); err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+		if users, err := store.Load(); err != nil || len(users) != 0 {
+			t.Errorf("Load() returned %v, %v after an empty save, expected no users", users, err)
+		}
+	})
+
+	t.Run("SaveAndLoad", func(t *testing.T) {
+		dir := t.TempDir()
+		store := open(t, dir)
+
+		err := store.Save(map[string][]byte{
+			"alice": []byte(`{"cron":[{"id":"job1","cron":"* * * * *","url":"http://example.com","active":true}],"data":{"k":"v"}}`),
+			"bob":   []byte(`{"cron":[],"data":{}}`),
+			"carol": []byte(`{"cron":[],"data":{}}`),
+		})
+		if err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+
+		// Only the changed users are passed, nil deletes
+		err = store.Save(map[string][]byte{
+			"bob":   []byte(`{"cron":[],"data":{"n":1}}`),
+			"carol": nil,
+			"dave":  nil,
+		})
+		if err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+		store.Close()
+
+		// Saved users survive reopening the store
+		store = open(t, dir)
+		defer store.Close()
+		users, err := store.Load()
+		if err != nil {
+			t.Fatalf("Load() failed: %v", err)
+		}
+		if len(users) != 2 || users["carol"] != nil {
+			t.Fatalf("Load() returned users %v, expected alice and bob", users)
+		}
+		if jobs := users["alice"].Cron; len(jobs) != 1 || jobs[0].ID != "job1" || !jobs[0].Active {
+			t.Errorf("Load() returned jobs %v for alice, expected job1", jobs)
+		}
+		if value := users["alice"].Data["k"]; value != "v" {
+			t.Errorf("Load() returned %v for alice's data, expected v", value)
+		}
+		if value := users["bob"].Data["n"]; value != float64(1) {
+			t.Errorf("Load() returned %v for bob's data, expected 1", value)
+		}
+	})
+
+	t.Run("InvalidUser", func(t *testing.T) {
+		store := open(t, t.TempDir())
+		defer store.Close()
+
+		store.Save(map[string][]byte{"alice": []byte("null")})
+		if _, err := store.Load(); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("Load() returned %v for a null user, expected %v", err, ErrInvalidConfig)
+		}
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		dir := t.TempDir()
+		store := open(t, dir)
+
+		cfg := NewConfig()
+		cfg.SetStore(store)
+		cfg.CreateUser("alice")
+		cfg.CreateUser("bob")
+		cfg.SetUserData("alice", "counter", float64(1))
+		cfg.AddUserJob("bob", &CronJob{ID: "job1", Cron: "* * * * *", URL: "http://example.com"})
+		cfg.CreateOrg("team")
+		cfg.SetOrgMember("team", "bob", "member")
+		if err := cfg.Save(); err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+
+		// Deleting a user changes the organizations it was a member of
+		cfg.DeleteUser("bob")
+		cfg.SetUserData("alice", "counter", float64(2))
+		if err := cfg.Save(); err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+		if cfg.Changed {
+			t.Error("Save() left the configuration changed")
+		}
+		store.Close()
+
+		loaded, err := LoadStore(open(t, dir))
+		if err != nil {
+			t.Fatalf("LoadStore() failed: %v", err)
+		}
+		defer loaded.Store().Close()
+		if string(loaded.SnapshotUsers()) != string(cfg.SnapshotUsers()) {
+			t.Errorf("LoadStore() = %s, expected %s", loaded.SnapshotUsers(), cfg.SnapshotUsers())
+		}
+
+		// Replacing all users deletes the ones not replaced
+		loaded.ReplaceUsers(map[string]*UserData{"carol": {Cron: []*CronJob{}, Data: map[string]interface{}{}}})
+		if err := loaded.Save(); err != nil {
+			t.Fatalf("Save() failed: %v", err)
+		}
+		users, err := loaded.Store().Load()
+		if err != nil || len(users) != 1 || users["carol"] == nil {
+			t.Errorf("Load() returned %v, %v after replacing all users, expected carol", users, err)
+		}
+	})
+}
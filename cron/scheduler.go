@@ -4,6 +4,7 @@ import (
 	"data-cron-server/config"
 	"log"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -24,6 +25,7 @@ type Scheduler struct {
 	config     *config.Config
 	entryIDs   map[string]map[string]cron.EntryID // user -> jobID -> entryID
 	jobStatus  map[string]map[string]*JobStatus   // user -> jobID -> status
+	scheduled  map[string]map[string]config.CronJob // user -> jobID -> job as scheduled
 	httpClient *http.Client
 	mutex      sync.RWMutex
 }
@@ -35,6 +37,7 @@ func NewScheduler(cfg *config.Config) *Scheduler {
 		config:     cfg,
 		entryIDs:   make(map[string]map[string]cron.EntryID),
 		jobStatus:  make(map[string]map[string]*JobStatus),
+		scheduled:  make(map[string]map[string]config.CronJob),
 		httpClient: &http.Client{Timeout: 30 * time.Second},
 	}
 
@@ -70,10 +73,15 @@ func (s *Scheduler) AddJob(user string, job *config.CronJob) error {
 	if _, exists := s.jobStatus[user]; !exists {
 		s.jobStatus[user] = make(map[string]*JobStatus)
 	}
+	if _, exists := s.scheduled[user]; !exists {
+		s.scheduled[user] = make(map[string]config.CronJob)
+	}
 
 	// Remove existing job if it exists
 	if entryID, exists := s.entryIDs[user][job.ID]; exists {
 		s.cron.Remove(entryID)
+		delete(s.entryIDs[user], job.ID)
+		delete(s.scheduled[user], job.ID)
 	}
 
 	// Only add the job if it's active
@@ -91,6 +99,7 @@ func (s *Scheduler) AddJob(user string, job *config.CronJob) error {
 
 		// Store entry ID
 		s.entryIDs[user][job.ID] = entryID
+		s.scheduled[user][job.ID] = *job
 
 		// Initialize job status
 		s.jobStatus[user][job.ID] = &JobStatus{
@@ -110,14 +119,103 @@ func (s *Scheduler) RemoveJob(user, jobID string) {
 		if entryID, exists := userEntries[jobID]; exists {
 			s.cron.Remove(entryID)
 			delete(userEntries, jobID)
+			delete(s.scheduled[user], jobID)
 		}
 	}
 }
 
+// JobsDiff lists the jobs, as "user/job", that Reconcile added to,
+// removed from or rescheduled in the scheduler
+type JobsDiff struct {
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Changed   []string `json:"changed"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// Reconcile brings the scheduler in line with the configuration, e.g.
+// after it was reloaded. Only jobs that were added, removed, activated,
+// deactivated or changed are touched; the status of the others is kept.
+func (s *Scheduler) Reconcile() JobsDiff {
+	diff := JobsDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+
+	// The active jobs of the configuration, and all existing ones
+	wanted := make(map[string]map[string]config.CronJob)
+	exists := make(map[[2]string]bool)
+	for user, jobs := range s.config.AllUserJobs() {
+		wanted[user] = make(map[string]config.CronJob)
+		for _, job := range jobs {
+			exists[[2]string{user, job.ID}] = true
+			if job.Active {
+				wanted[user][job.ID] = job
+			}
+		}
+	}
+
+	s.mutex.RLock()
+	var removed [][2]string
+	for user, jobs := range s.scheduled {
+		for jobID := range jobs {
+			if _, keep := wanted[user][jobID]; !keep {
+				removed = append(removed, [2]string{user, jobID})
+			}
+		}
+	}
+	s.mutex.RUnlock()
+
+	for _, job := range removed {
+		user, jobID := job[0], job[1]
+		s.RemoveJob(user, jobID)
+		if !exists[job] {
+			// Deactivated jobs keep their status, deleted ones not
+			s.mutex.Lock()
+			delete(s.jobStatus[user], jobID)
+			s.mutex.Unlock()
+		}
+		diff.Removed = append(diff.Removed, user+"/"+jobID)
+	}
+
+	for user, jobs := range wanted {
+		for jobID, job := range jobs {
+			s.mutex.RLock()
+			current, scheduled := s.scheduled[user][jobID]
+			s.mutex.RUnlock()
+
+			name := user + "/" + jobID
+			if scheduled && current == job {
+				diff.Unchanged++
+				continue
+			}
+
+			job := job
+			if err := s.AddJob(user, &job); err != nil {
+				log.Printf("Error scheduling job %s for user %s: %v", jobID, user, err)
+				continue
+			}
+			if scheduled {
+				diff.Changed = append(diff.Changed, name)
+			} else {
+				diff.Added = append(diff.Added, name)
+			}
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+
+	return diff
+}
+
 // executeJob executes a job by making an HTTP GET request
 func (s *Scheduler) executeJob(user string, job *config.CronJob) {
 	s.mutex.Lock()
 	status := s.jobStatus[user][job.ID]
+	if status == nil {
+		// The job was deleted while it was due
+		s.mutex.Unlock()
+		return
+	}
 	status.LastRun = time.Now()
 	s.mutex.Unlock()
 
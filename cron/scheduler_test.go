@@ -0,0 +1,147 @@
+package cron
+
+import (
+	"data-cron-server/config"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// hourly runs at the start of every hour, so no job runs during a test
+const hourly = "0 0 * * * *"
+
+// runScheduled runs the job the scheduler has scheduled for user and jobID
+// as cron would, reporting false if none is scheduled
+func runScheduled(s *Scheduler, user, jobID string) bool {
+	s.mutex.RLock()
+	entryID, exists := s.entryIDs[user][jobID]
+	s.mutex.RUnlock()
+	if !exists {
+		return false
+	}
+	entry := s.cron.Entry(entryID)
+	if !entry.Valid() {
+		return false
+	}
+	entry.Job.Run()
+	return true
+}
+
+func TestReconcile(t *testing.T) {
+	var mutex sync.Mutex
+	var hits []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		hits = append(hits, r.URL.Path)
+		mutex.Unlock()
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		// change changes the configuration the scheduler was started with
+		change func(cfg *config.Config)
+		diff   JobsDiff
+		// schedule and path are the schedule of alice/job1 afterwards and
+		// where it sends its request, empty if it is not scheduled
+		schedule string
+		path     string
+		// status reports whether alice/job1 keeps a status
+		status bool
+	}{
+		{
+			name:     "unchanged",
+			change:   func(cfg *config.Config) {},
+			diff:     JobsDiff{Added: []string{}, Removed: []string{}, Changed: []string{}, Unchanged: 2},
+			schedule: hourly,
+			path:     "/v1",
+			status:   true,
+		},
+		{
+			name: "changed",
+			change: func(cfg *config.Config) {
+				cfg.AddUserJob("alice", &config.CronJob{ID: "job1", Cron: "0 30 * * * *", URL: server.URL + "/v2", Active: true})
+			},
+			diff:     JobsDiff{Added: []string{}, Removed: []string{}, Changed: []string{"alice/job1"}, Unchanged: 1},
+			schedule: "0 30 * * * *",
+			path:     "/v2",
+			status:   true,
+		},
+		{
+			name: "deactivated",
+			change: func(cfg *config.Config) {
+				cfg.SetUserJobActive("alice", "job1", false)
+			},
+			diff:   JobsDiff{Added: []string{}, Removed: []string{"alice/job1"}, Changed: []string{}, Unchanged: 1},
+			status: true,
+		},
+		{
+			name: "removed",
+			change: func(cfg *config.Config) {
+				cfg.DeleteUserJob("alice", "job1")
+			},
+			diff: JobsDiff{Added: []string{}, Removed: []string{"alice/job1"}, Changed: []string{}, Unchanged: 1},
+		},
+		{
+			name: "user removed and job added",
+			change: func(cfg *config.Config) {
+				cfg.DeleteUser("bob")
+				cfg.AddUserJob("alice", &config.CronJob{ID: "job2", Cron: hourly, URL: server.URL + "/new", Active: true})
+			},
+			diff:     JobsDiff{Added: []string{"alice/job2"}, Removed: []string{"bob/job1"}, Changed: []string{}, Unchanged: 1},
+			schedule: hourly,
+			path:     "/v1",
+			status:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			cfg.AddUserJob("alice", &config.CronJob{ID: "job1", Cron: hourly, URL: server.URL + "/v1", Active: true})
+			cfg.AddUserJob("bob", &config.CronJob{ID: "job1", Cron: hourly, URL: server.URL + "/bob", Active: true})
+
+			s := NewScheduler(cfg)
+			defer s.Stop()
+
+			tt.change(cfg)
+			if diff := s.Reconcile(); !reflect.DeepEqual(diff, tt.diff) {
+				t.Errorf("Reconcile() = %+v, expected %+v", diff, tt.diff)
+			}
+
+			// The scheduler runs the jobs of the configuration
+			if len(s.cron.Entries()) != tt.diff.Unchanged+len(tt.diff.Changed)+len(tt.diff.Added) {
+				t.Errorf("Reconcile() left %d scheduled entries, expected %d", len(s.cron.Entries()), tt.diff.Unchanged+len(tt.diff.Changed)+len(tt.diff.Added))
+			}
+			mutex.Lock()
+			hits = nil
+			mutex.Unlock()
+			ran := runScheduled(s, "alice", "job1")
+			if ran != (tt.path != "") {
+				t.Fatalf("alice/job1 scheduled = %v, expected %v", ran, tt.path != "")
+			}
+			mutex.Lock()
+			if ran && (len(hits) != 1 || hits[0] != tt.path) {
+				t.Errorf("alice/job1 requested %v, expected %s", hits, tt.path)
+			}
+			mutex.Unlock()
+			s.mutex.RLock()
+			schedule := s.scheduled["alice"]["job1"].Cron
+			s.mutex.RUnlock()
+			if schedule != tt.schedule {
+				t.Errorf("alice/job1 scheduled with %q, expected %q", schedule, tt.schedule)
+			}
+
+			if status := s.GetJobStatus("alice", "job1") != nil; status != tt.status {
+				t.Errorf("GetJobStatus() returned a status = %v, expected %v", status, tt.status)
+			}
+
+			// Reconciling again changes nothing
+			if diff := s.Reconcile(); len(diff.Added)+len(diff.Removed)+len(diff.Changed) != 0 {
+				t.Errorf("Reconcile() = %+v on the second call, expected no changes", diff)
+			}
+		})
+	}
+}
@@ -2,4 +2,12 @@ module data-cron-server
 
 go 1.20
 
-require github.com/robfig/cron/v3 v3.0.1
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/robfig/cron/v3 v3.0.1
+	go.etcd.io/bbolt v1.3.7
+	golang.org/x/crypto v0.11.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.10.0 // indirect
@@ -1,49 +1,324 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/tls"
 	"data-cron-server/api"
+	"data-cron-server/audit"
+	"data-cron-server/auth"
 	"data-cron-server/config"
 	"data-cron-server/cron"
+	"data-cron-server/tlsconfig"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
 
 func main() {
+	// Offline commands, e.g. to decrypt the configuration for disaster
+	// recovery
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// Load environment variables or use defaults
 	port := getEnvOrDefault("PORT", "8080")
 	superAdminKey := getEnvOrDefault("SUPER_ADMIN_KEY", "super_admin_key")
 	configFilePath := getEnvOrDefault("CONFIG_FILE_PATH", "./config/config.json")
+	adminsFilePath := getEnvOrDefault("ADMINS_FILE_PATH", filepath.Join(filepath.Dir(configFilePath), "admins.json"))
 	autoSaveIntervalStr := getEnvOrDefault("AUTO_SAVE_INTERVAL", "60")
+	watchIntervalStr := getEnvOrDefault("CONFIG_WATCH_INTERVAL", "0")
+	maxBackupsStr := getEnvOrDefault("CONFIG_MAX_BACKUPS", strconv.Itoa(config.DefaultMaxBackups))
+	onCorrupt := getEnvOrDefault("CONFIG_ON_CORRUPT", "refuse")
+	journalPath := getEnvOrDefault("CONFIG_JOURNAL_PATH", configFilePath+".journal")
+	historyPath := getEnvOrDefault("CONFIG_HISTORY_PATH", configFilePath+".history")
+	maxRevisionsStr := getEnvOrDefault("CONFIG_MAX_REVISIONS", strconv.Itoa(config.DefaultMaxRevisions))
+	storeKind := getEnvOrDefault("CONFIG_STORE", config.StoreFile)
+	dbPath := getEnvOrDefault("CONFIG_DB_PATH", filepath.Join(filepath.Dir(configFilePath), "config.db"))
+	dirPath := getEnvOrDefault("CONFIG_DIR_PATH", filepath.Join(filepath.Dir(configFilePath), "users"))
+	legacyPathKeysStr := getEnvOrDefault("LEGACY_PATH_KEYS", "true")
+	requireSignedStr := getEnvOrDefault("REQUIRE_SIGNED_REQUESTS", "false")
+	signatureMaxSkewStr := getEnvOrDefault("SIGNATURE_MAX_SKEW", "300")
+	shareSecret := getEnvOrDefault("SHARE_SECRET", "")
+	signingSecret := getEnvOrDefault("SIGNING_SECRET", "")
+	signingSecretFile := getEnvOrDefault("SIGNING_SECRET_FILE", filepath.Join(filepath.Dir(configFilePath), "signing.secret"))
+	lockoutMaxFailuresStr := getEnvOrDefault("LOCKOUT_MAX_FAILURES", "5")
+	lockoutBaseStr := getEnvOrDefault("LOCKOUT_BASE", "30")
+	lockoutMaxStr := getEnvOrDefault("LOCKOUT_MAX", "900")
+	trustedProxies := splitList(getEnvOrDefault("TRUSTED_PROXIES", ""))
+	superAdminCIDRs := splitList(getEnvOrDefault("SUPER_ADMIN_ALLOWED_CIDRS", ""))
+	tlsCertFile := getEnvOrDefault("TLS_CERT_FILE", "")
+	tlsKeyFile := getEnvOrDefault("TLS_KEY_FILE", "")
+	tlsClientCAFile := getEnvOrDefault("TLS_CLIENT_CA_FILE", "")
+	tlsClientAuthStr := getEnvOrDefault("TLS_CLIENT_AUTH", "none")
+	tlsReloadIntervalStr := getEnvOrDefault("TLS_RELOAD_INTERVAL", "60")
+	clientCertIdentity := getEnvOrDefault("CLIENT_CERT_IDENTITY", auth.CertIdentityCN)
+	jwtJWKSFile := getEnvOrDefault("JWT_JWKS_FILE", "")
+	jwtPublicKeyFiles := splitList(getEnvOrDefault("JWT_PUBLIC_KEY_FILES", ""))
+	jwtIssuer := getEnvOrDefault("JWT_ISSUER", "")
+	jwtAudience := getEnvOrDefault("JWT_AUDIENCE", "")
+	jwtUserClaim := getEnvOrDefault("JWT_USER_CLAIM", "sub")
+	jwtRoleClaim := getEnvOrDefault("JWT_ROLE_CLAIM", "")
+	jwtLeewayStr := getEnvOrDefault("JWT_LEEWAY", "60")
+	keyExpiryWarningDaysStr := getEnvOrDefault("KEY_EXPIRY_WARNING_DAYS", "14")
+	keyExpiryWebhookURL := getEnvOrDefault("KEY_EXPIRY_WEBHOOK_URL", "")
+	auditLogPath := getEnvOrDefault("AUDIT_LOG_PATH", filepath.Join(filepath.Dir(configFilePath), "audit.log"))
 
 	autoSaveInterval, err := strconv.Atoi(autoSaveIntervalStr)
 	if err != nil {
 		log.Fatalf("Invalid AUTO_SAVE_INTERVAL: %v", err)
 	}
 
+	watchInterval, err := strconv.Atoi(watchIntervalStr)
+	if err != nil || watchInterval < 0 {
+		log.Fatalf("Invalid CONFIG_WATCH_INTERVAL: %s", watchIntervalStr)
+	}
+
+	maxBackups, err := strconv.Atoi(maxBackupsStr)
+	if err != nil || maxBackups < 0 {
+		log.Fatalf("Invalid CONFIG_MAX_BACKUPS: %s", maxBackupsStr)
+	}
+	config.SetMaxBackups(maxBackups)
+
+	maxRevisions, err := strconv.Atoi(maxRevisionsStr)
+	if err != nil || maxRevisions < 0 {
+		log.Fatalf("Invalid CONFIG_MAX_REVISIONS: %s", maxRevisionsStr)
+	}
+
+	if onCorrupt != "refuse" && onCorrupt != "readonly" {
+		log.Fatalf("Invalid CONFIG_ON_CORRUPT: %s (expected refuse or readonly)", onCorrupt)
+	}
+
+	legacyPathKeys, err := strconv.ParseBool(legacyPathKeysStr)
+	if err != nil {
+		log.Fatalf("Invalid LEGACY_PATH_KEYS: %v", err)
+	}
+
+	requireSigned, err := strconv.ParseBool(requireSignedStr)
+	if err != nil {
+		log.Fatalf("Invalid REQUIRE_SIGNED_REQUESTS: %v", err)
+	}
+
+	signatureMaxSkew, err := strconv.Atoi(signatureMaxSkewStr)
+	if err != nil {
+		log.Fatalf("Invalid SIGNATURE_MAX_SKEW: %v", err)
+	}
+
+	lockoutMaxFailures, err := strconv.Atoi(lockoutMaxFailuresStr)
+	if err != nil || lockoutMaxFailures < 1 {
+		log.Fatalf("Invalid LOCKOUT_MAX_FAILURES: %s", lockoutMaxFailuresStr)
+	}
+
+	lockoutBase, err := strconv.Atoi(lockoutBaseStr)
+	if err != nil {
+		log.Fatalf("Invalid LOCKOUT_BASE: %v", err)
+	}
+
+	lockoutMax, err := strconv.Atoi(lockoutMaxStr)
+	if err != nil {
+		log.Fatalf("Invalid LOCKOUT_MAX: %v", err)
+	}
+
+	tlsClientAuth, err := tlsconfig.ParseClientAuth(tlsClientAuthStr)
+	if err != nil {
+		log.Fatalf("Invalid TLS_CLIENT_AUTH: %v", err)
+	}
+
+	tlsReloadInterval, err := strconv.Atoi(tlsReloadIntervalStr)
+	if err != nil {
+		log.Fatalf("Invalid TLS_RELOAD_INTERVAL: %v", err)
+	}
+
+	jwtLeeway, err := strconv.Atoi(jwtLeewayStr)
+	if err != nil {
+		log.Fatalf("Invalid JWT_LEEWAY: %v", err)
+	}
+
+	keyExpiryWarningDays, err := strconv.Atoi(keyExpiryWarningDaysStr)
+	if err != nil || keyExpiryWarningDays < 0 {
+		log.Fatalf("Invalid KEY_EXPIRY_WARNING_DAYS: %s", keyExpiryWarningDaysStr)
+	}
+
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		log.Fatalf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if tlsCertFile == "" && tlsClientAuth != tls.NoClientCert {
+		log.Fatalf("TLS_CLIENT_AUTH needs TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	// Encrypt the configuration at rest
+	encryption, err := encryptionFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid configuration encryption key: %v", err)
+	}
+	if encryption != nil {
+		config.SetEncryption(encryption)
+		if os.Getenv("CONFIG_ENCRYPTION_KEY") == "" && os.Getenv("CONFIG_ENCRYPTION_KEY_FILE") == "" {
+			log.Printf("Only old encryption keys are set, the configuration is decrypted with the next saves")
+		} else {
+			log.Printf("Encrypting the configuration at rest")
+		}
+	}
+
 	// Initialize configuration
-	log.Printf("Loading configuration from %s", configFilePath)
-	cfg, err := config.LoadConfig(configFilePath)
+	storePath := map[string]string{
+		config.StoreFile: configFilePath,
+		config.StoreBolt: dbPath,
+		config.StoreDir:  dirPath,
+	}[storeKind]
+	store, err := config.OpenStore(storeKind, storePath)
 	if err != nil {
-		log.Printf("Starting with empty configuration: %v", err)
-		cfg = config.NewConfig()
+		log.Fatalf("Failed to open configuration store: %v", err)
+	}
+	log.Printf("Loading configuration from %s", storePath)
+	cfg := loadConfig(store, configFilePath, onCorrupt)
+
+	// Journal changes between saves
+	var journal *config.Journal
+	if journalPath != "" {
+		journal, err = config.OpenJournal(journalPath)
+		if err != nil {
+			log.Fatalf("Failed to open configuration journal: %v", err)
+		}
+		// The journal of a corrupt configuration is kept for when it is
+		// repaired
+		if _, readOnly := cfg.ReadOnly(); !readOnly {
+			replayed, err := journal.Replay(cfg)
+			if err != nil {
+				log.Fatalf("Failed to replay configuration journal: %v", err)
+			}
+			if replayed > 0 {
+				log.Printf("Replayed %d changes from the configuration journal", replayed)
+			}
+		}
+		cfg.SetJournal(journal)
 	} else {
-		log.Printf("Configuration loaded successfully")
+		log.Printf("CONFIG_JOURNAL_PATH is empty, changes since the last auto-save are lost on a crash")
+	}
+
+	// Record a revision with every save
+	if historyPath != "" {
+		history, err := config.OpenHistory(historyPath, maxRevisions)
+		if err != nil {
+			log.Fatalf("Failed to open configuration history: %v", err)
+		}
+		if err := cfg.SetHistory(history); err != nil {
+			log.Fatalf("Failed to record configuration revision: %v", err)
+		}
 	}
 
+	// Initialize admin accounts
+	admins, err := config.LoadAdmins(adminsFilePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Fatalf("Failed to load admins from %s: %v", adminsFilePath, err)
+		}
+		admins = config.NewAdmins()
+	} else {
+		log.Printf("Loaded %d admin accounts from %s", len(admins.Accounts), adminsFilePath)
+	}
+	admins.SetFilePath(adminsFilePath)
+
 	// Initialize cron scheduler
 	scheduler := cron.NewScheduler(cfg)
 
 	// Start auto-save goroutine
 	stopChan := make(chan struct{})
-	go autoSaveConfig(cfg, configFilePath, time.Duration(autoSaveInterval)*time.Second, stopChan)
+	go autoSaveConfig(cfg, admins, time.Duration(autoSaveInterval)*time.Second, stopChan)
+
+	// Initialize authentication
+	authenticator := auth.NewAuthenticator(cfg, superAdminKey)
+	authenticator.SetAdmins(admins)
+	authenticator.SetPathKeys(legacyPathKeys)
+	authenticator.SetSignatures(requireSigned, time.Duration(signatureMaxSkew)*time.Second)
+	authenticator.SetLockoutPolicy(lockoutMaxFailures, time.Duration(lockoutBase)*time.Second, time.Duration(lockoutMax)*time.Second)
+	if err := authenticator.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	if err := authenticator.SetSuperAdminAllowedCIDRs(superAdminCIDRs); err != nil {
+		log.Fatalf("Invalid SUPER_ADMIN_ALLOWED_CIDRS: %v", err)
+	}
+	if tlsClientAuth != tls.NoClientCert {
+		if err := authenticator.SetClientCertIdentity(clientCertIdentity); err != nil {
+			log.Fatalf("Invalid CLIENT_CERT_IDENTITY: %v", err)
+		}
+		log.Printf("Client certificates authenticate users by %s", clientCertIdentity)
+	}
+	if jwtJWKSFile != "" || len(jwtPublicKeyFiles) > 0 {
+		verifier := auth.NewJWTVerifier()
+		verifier.Issuer = jwtIssuer
+		verifier.Audience = jwtAudience
+		verifier.UserClaim = jwtUserClaim
+		verifier.RoleClaim = jwtRoleClaim
+		verifier.Leeway = time.Duration(jwtLeeway) * time.Second
+		if jwtJWKSFile != "" {
+			if err := verifier.LoadJWKS(jwtJWKSFile); err != nil {
+				log.Fatalf("Failed to load JWT_JWKS_FILE: %v", err)
+			}
+		}
+		for _, file := range jwtPublicKeyFiles {
+			if err := verifier.LoadPublicKeyPEM(file); err != nil {
+				log.Fatalf("Failed to load JWT_PUBLIC_KEY_FILES: %v", err)
+			}
+		}
+		if jwtIssuer == "" || jwtAudience == "" {
+			log.Printf("JWT_ISSUER or JWT_AUDIENCE not set, tokens for other services signed with the same keys are accepted")
+		}
+		authenticator.SetJWTVerifier(verifier)
+		log.Printf("Accepting JWTs signed with %d keys", verifier.KeyCount())
+	}
+	if !legacyPathKeys {
+		log.Printf("Legacy path keys disabled, credentials are only accepted via headers")
+	}
+	if requireSigned {
+		log.Printf("User routes only accept signed requests")
+	}
+	authenticator.SetKeyExpiryWarning(time.Duration(keyExpiryWarningDays) * 24 * time.Hour)
+	if keyExpiryWebhookURL != "" {
+		go authenticator.WatchKeyExpiry(keyExpiryWebhookURL, time.Hour, stopChan)
+		log.Printf("Notifying the key expiry webhook of keys expiring within %d days", keyExpiryWarningDays)
+	}
+	if shareSecret != "" {
+		authenticator.SetShareSecret([]byte(shareSecret))
+	} else {
+		log.Printf("SHARE_SECRET not set, share links will stop working on restart")
+	}
+	if signingSecret == "" {
+		signingSecret, err = loadSigningSecret(signingSecretFile)
+		if err != nil {
+			log.Fatalf("Failed to load signing secret: %v", err)
+		}
+	}
+	authenticator.SetSigningSecret([]byte(signingSecret))
+
+	// Open the audit log, an empty path disables it
+	var auditLog *audit.Log
+	if auditLogPath != "" {
+		auditLog, err = audit.Open(auditLogPath)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		log.Printf("Recording writes and authentication failures in %s", auditLogPath)
+	} else {
+		log.Printf("AUDIT_LOG_PATH is empty, the audit log is disabled")
+	}
 
 	// Initialize API router
-	router := api.NewRouter(cfg, scheduler, superAdminKey)
+	router := api.NewRouter(cfg, admins, scheduler, authenticator, auditLog)
+
+	// Reload the configuration when it is edited
+	if watchInterval > 0 {
+		go watchConfig(cfg, scheduler, auditLog, time.Duration(watchInterval)*time.Second, stopChan)
+	}
 
 	// Start HTTP server
 	server := &http.Server{
@@ -51,6 +326,33 @@ func main() {
 		Handler: router,
 	}
 
+	// Terminate TLS with certificates that can be reloaded
+	var reloader *tlsconfig.Reloader
+	if tlsCertFile != "" {
+		reloader, err = tlsconfig.NewReloader(tlsCertFile, tlsKeyFile, tlsClientCAFile, tlsClientAuth)
+		if err != nil {
+			log.Fatalf("Failed to load TLS files: %v", err)
+		}
+		server.TLSConfig = reloader.TLSConfig()
+
+		if tlsReloadInterval > 0 {
+			go reloader.Watch(time.Duration(tlsReloadInterval)*time.Second, stopChan)
+		}
+
+		// Reload the certificate on SIGHUP
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		go func() {
+			for range hupChan {
+				if err := reloader.Reload(); err != nil {
+					log.Printf("Error reloading TLS files: %v", err)
+					continue
+				}
+				log.Printf("TLS certificate reloaded")
+			}
+		}()
+	}
+
 	// Handle graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -60,9 +362,12 @@ func main() {
 		log.Println("Shutting down server...")
 
 		// Save configuration one last time
-		if err := config.SaveConfig(cfg, configFilePath); err != nil {
+		if err := cfg.Save(); err != nil {
 			log.Printf("Error saving config on shutdown: %v", err)
 		}
+		if err := admins.Save(); err != nil {
+			log.Printf("Error saving admins on shutdown: %v", err)
+		}
 
 		// Stop auto-save goroutine
 		close(stopChan)
@@ -74,10 +379,24 @@ func main() {
 		if err := server.Close(); err != nil {
 			log.Printf("Error shutting down server: %v", err)
 		}
+
+		if auditLog != nil {
+			auditLog.Close()
+		}
+		if journal != nil {
+			journal.Close()
+		}
+		store.Close()
 	}()
 
-	log.Printf("Server starting on port %s", port)
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+	if reloader != nil {
+		log.Printf("Server starting with TLS on port %s", port)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("Server starting on port %s", port)
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
@@ -89,20 +408,66 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
-func autoSaveConfig(cfg *config.Config, filePath string, interval time.Duration, stopChan <-chan struct{}) {
+// splitList splits a comma separated environment variable, dropping empty
+// entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadSigningSecret reads the secret that the signing secrets of API keys
+// are encrypted with from a file, which is created with a random secret if
+// it does not exist. It is kept apart from the configuration, so copies of
+// the configuration cannot be used to sign requests.
+func loadSigningSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		secret := strings.TrimSpace(string(data))
+		if secret == "" {
+			return "", fmt.Errorf("%s is empty", path)
+		}
+		return secret, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(random)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		return "", err
+	}
+	log.Printf("Generated signing secret in %s", path)
+	return secret, nil
+}
+
+func autoSaveConfig(cfg *config.Config, admins *config.Admins, interval time.Duration, stopChan <-chan struct{}) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			if err := config.SaveConfig(cfg, filePath); err != nil {
+			if saved, err := cfg.SaveChanged(); errors.Is(err, config.ErrReadOnly) {
+				// Read-only since startup, nothing to save
+			} else if err != nil {
 				log.Printf("Error auto-saving config: %v", err)
-			} else {
-				// Only log if config was actually saved (had changes)
-				if cfg.Changed {
-					log.Printf("Configuration auto-saved to %s", filePath)
-				}
+			} else if saved {
+				log.Printf("Configuration auto-saved")
+			}
+			if err := admins.Save(); err != nil {
+				log.Printf("Error auto-saving admins: %v", err)
 			}
 		case <-stopChan:
 			log.Println("Auto-save stopped")
@@ -110,3 +475,128 @@ func autoSaveConfig(cfg *config.Config, filePath string, interval time.Duration,
 		}
 	}
 }
+
+// watchConfig reloads the configuration whenever its store was changed by
+// someone else, e.g. by editing the configuration file, until stopChan is
+// closed. Only the jobs that changed are rescheduled.
+func watchConfig(cfg *config.Config, scheduler *cron.Scheduler, auditLog *audit.Log, interval time.Duration, stopChan <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// A file being edited may fail to load several times, log that once
+	lastErr := ""
+	logOnce := func(format string, err error) {
+		if err.Error() != lastErr {
+			log.Printf(format, err)
+			lastErr = err.Error()
+		}
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			modified, err := cfg.StoreModified()
+			if err != nil {
+				logOnce("Error checking the configuration for changes: %v", err)
+				continue
+			}
+			if !modified {
+				continue
+			}
+
+			before := audit.Hash(cfg.SnapshotUsers())
+			users, err := cfg.Reload(config.SystemPrincipal)
+			if err != nil {
+				logOnce("Error reloading the changed configuration: %v", err)
+				continue
+			}
+			lastErr = ""
+			jobs := scheduler.Reconcile()
+			log.Printf("Configuration changed, reloaded: users %d added, %d removed, %d changed; jobs %d added, %d removed, %d changed",
+				len(users.Added), len(users.Removed), len(users.Changed), len(jobs.Added), len(jobs.Removed), len(jobs.Changed))
+
+			if auditLog != nil {
+				err := auditLog.Record(audit.Event{
+					Principal: "system",
+					Action:    "config.reload",
+					Before:    before,
+					After:     audit.Hash(cfg.SnapshotUsers()),
+				})
+				if err != nil {
+					log.Printf("Error writing audit log: %v", err)
+				}
+			}
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+// loadConfig loads the configuration from the store. Only a missing file,
+// database or directory starts an empty configuration; the bolt and dir
+// stores import the configuration file instead if there is one. A configuration that
+// cannot be read or parsed is never overwritten: an invalid file is moved
+// aside to a timestamped name, then the server either refuses to start or,
+// with CONFIG_ON_CORRUPT=readonly, starts with an empty read-only
+// configuration that reports unhealthy.
+func loadConfig(store config.Store, filePath, onCorrupt string) *config.Config {
+	cfg, err := config.LoadStore(store)
+	if err == nil {
+		log.Printf("Configuration loaded successfully")
+		return cfg
+	}
+
+	_, isFile := store.(*config.FileStore)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && !isFile:
+		imported, importErr := config.LoadConfig(filePath)
+		if importErr == nil {
+			log.Printf("Importing %d users from %s into the empty store", len(imported.Users), filePath)
+			imported.SetStore(store)
+			return imported
+		}
+		if !os.IsNotExist(importErr) {
+			log.Fatalf("Failed to import %s: %v", filePath, importErr)
+		}
+		log.Printf("Starting with empty configuration: %v", err)
+		cfg = config.NewConfig()
+		cfg.SetStore(store)
+		return cfg
+	case errors.Is(err, os.ErrNotExist):
+		// A file quarantined before is still missing, so starting empty
+		// would lose its data on the first save
+		quarantined, _ := config.ListQuarantined(filePath)
+		if len(quarantined) == 0 {
+			log.Printf("Starting with empty configuration: %v", err)
+			cfg = config.NewConfig()
+			cfg.SetStore(store)
+			return cfg
+		}
+		err = fmt.Errorf("configuration file missing, but %s was quarantined before", quarantined[len(quarantined)-1])
+	case errors.Is(err, config.ErrInvalidConfig) && isFile:
+		quarantined, qerr := config.Quarantine(filePath, time.Now())
+		if qerr != nil {
+			log.Printf("Error quarantining configuration: %v", qerr)
+		} else {
+			err = fmt.Errorf("%w (moved to %s)", err, quarantined)
+		}
+	}
+
+	if errors.Is(err, config.ErrNewerSchema) {
+		// Saving would drop what this server does not know
+		log.Fatalf("Refusing to start: %v. Run a server that supports it, or restore the configuration kept before it was migrated, e.g. %s.v%d.", err, filePath, config.SchemaVersion)
+	}
+	if errors.Is(err, config.ErrNoEncryptionKey) || errors.Is(err, config.ErrUnknownEncryptionKey) {
+		// Backups are sealed with the same keys
+		log.Fatalf("Refusing to start: %v. Set the key the configuration was encrypted with in CONFIG_ENCRYPTION_KEY or CONFIG_ENCRYPTION_OLD_KEYS.", err)
+	}
+	if onCorrupt != "readonly" {
+		log.Fatalf("Refusing to start: %v. Restore the configuration, e.g. from the backup %s.1, or set CONFIG_ON_CORRUPT=readonly to start read-only and restore a backup through the API.", err, filePath)
+	}
+
+	log.Printf("Starting with empty read-only configuration: %v", err)
+	cfg = config.NewConfig()
+	cfg.SetStore(store)
+	cfg.SetReadOnly(err.Error())
+	return cfg
+}
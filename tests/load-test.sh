@@ -18,6 +18,14 @@ for i in $(seq 1 $TOTAL_USERS); do
 done
 wait
 
+# Issue an API key for each user
+echo -e "\nIssuing API keys..."
+declare -A USER_KEYS
+for i in $(seq 1 $TOTAL_USERS); do
+  user="load_user_$i"
+  USER_KEYS[$user]=$(curl -s -X POST "${SERVER}/admin/${SUPER_KEY}/users/${user}/keys" | jq -r '.key')
+done
+
 # Get all users
 echo -e "\nVerifying all users were created..."
 curl -s "${SERVER}/admin/${SUPER_KEY}/users" | jq
@@ -35,7 +43,7 @@ for i in $(seq 1 $TOTAL_USERS); do
     
     # Use different cron schedules
     minute=$((j * 5 % 60))
-    curl -s -X POST "${SERVER}/cron/${user}/jobs" \
+    curl -s -X POST "${SERVER}/cron/${USER_KEYS[$user]}/jobs" \
       -d "{\"id\":\"job_${j}\",\"cron\":\"0 ${minute} * * * *\",\"url\":\"https://example.com/${user}/job_${j}\",\"active\":${active}}" &
   done
 done
@@ -46,7 +54,7 @@ echo -e "\nStoring $DATA_ITEMS_PER_USER data items for each user (total: $((TOTA
 for i in $(seq 1 $TOTAL_USERS); do
   user="load_user_$i"
   for j in $(seq 1 $DATA_ITEMS_PER_USER); do
-    curl -s -X PUT "${SERVER}/data/${user}/data_key_${j}" \
+    curl -s -X PUT "${SERVER}/data/${USER_KEYS[$user]}/data_key_${j}" \
       -d "{\"value\":\"data_value_${j}\",\"timestamp\":\"$(date +%s)\",\"metadata\":{\"source\":\"load_test\",\"index\":${j}}}" &
   done
 done
@@ -58,34 +66,34 @@ user="load_user_1"
 job_id="job_1"
 for i in $(seq 1 10); do
   updated_url="https://example.com/updated_${i}_$(date +%s)"
-  curl -s -X PUT "${SERVER}/cron/${user}/job/${job_id}" \
+  curl -s -X PUT "${SERVER}/cron/${USER_KEYS[$user]}/job/${job_id}" \
     -d "{\"cron\":\"0 ${i} * * * *\",\"url\":\"${updated_url}\",\"active\":true}" &
 done
 wait
 
 # Check the final state of the job
 echo -e "\nChecking final state of the concurrently updated job..."
-curl -s "${SERVER}/cron/${user}/job/${job_id}" | jq
+curl -s "${SERVER}/cron/${USER_KEYS[$user]}/job/${job_id}" | jq
 
 # Test concurrent updates to user data
 echo -e "\nTesting concurrent updates to user data..."
 for i in $(seq 1 10); do
-  curl -s -X PUT "${SERVER}/data/${user}/concurrent_test" \
+  curl -s -X PUT "${SERVER}/data/${USER_KEYS[$user]}/concurrent_test" \
     -d "{\"counter\":${i},\"timestamp\":\"$(date +%s)\"}" &
 done
 wait
 
 # Check final state of user data
 echo -e "\nChecking final state of concurrently updated data..."
-curl -s "${SERVER}/data/${user}/concurrent_test" | jq
+curl -s "${SERVER}/data/${USER_KEYS[$user]}/concurrent_test" | jq
 
 # Get job status
 echo -e "\nGetting job status for a user..."
-curl -s "${SERVER}/status/${user}" | jq
+curl -s "${SERVER}/status/${USER_KEYS[$user]}" | jq
 
 # Verify data keys
 echo -e "\nVerifying data keys for a user..."
-curl -s "${SERVER}/data/${user}/keys" | jq
+curl -s "${SERVER}/data/${USER_KEYS[$user]}/keys" | jq
 
 # Get configuration
 echo -e "\nGetting full configuration..."
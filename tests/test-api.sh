@@ -16,62 +16,71 @@ curl -s "${SERVER}/health"
 echo -e "\n\nCreating a new user: ${USER}..."
 curl -s -X POST "${SERVER}/admin/${SUPER_KEY}/users" -d "{\"user\":\"${USER}\"}"
 
+# Issue an API key for the user
+echo -e "\n\nIssuing an API key for ${USER}..."
+USER_KEY=$(curl -s -X POST "${SERVER}/admin/${SUPER_KEY}/users/${USER}/keys" -d "{\"label\":\"test-api\"}" | jq -r '.key')
+echo "Issued key: ${USER_KEY}"
+
+# List API keys of the user
+echo -e "\n\nListing API keys for ${USER}..."
+curl -s "${SERVER}/admin/${SUPER_KEY}/users/${USER}/keys" | jq
+
 # Get all users
 echo -e "\n\nGetting all users..."
 curl -s "${SERVER}/admin/${SUPER_KEY}/users" | jq
 
 # Create a new cron job
 echo -e "\n\nCreating a new cron job..."
-curl -s -X POST "${SERVER}/cron/${USER}" \
+curl -s -X POST "${SERVER}/cron/${USER_KEY}" \
   -d "{\"id\":\"job1\",\"cron\":\"0 */5 * * * *\",\"url\":\"https://example.com\",\"active\":true}"
 
 # Get all jobs
 echo -e "\n\nGetting all jobs for user ${USER}..."
-curl -s "${SERVER}/cron/${USER}" | jq
+curl -s "${SERVER}/cron/${USER_KEY}" | jq
 
 # Store data
 echo -e "\n\nStoring data..."
-curl -s -X PUT "${SERVER}/data/${USER}/settings" \
+curl -s -X PUT "${SERVER}/data/${USER_KEY}/settings" \
   -d "{\"theme\":\"dark\",\"notifications\":true}"
 
 # Get data
 echo -e "\n\nGetting data..."
-curl -s "${SERVER}/data/${USER}/settings" | jq
+curl -s "${SERVER}/data/${USER_KEY}/settings" | jq
 
 # Get job status
 echo -e "\n\nGetting job status..."
-curl -s "${SERVER}/status/${USER}" | jq
+curl -s "${SERVER}/status/${USER_KEY}" | jq
 
 # Create another job that's inactive
 echo -e "\n\nCreating an inactive job..."
-curl -s -X POST "${SERVER}/cron/${USER}" \
+curl -s -X POST "${SERVER}/cron/${USER_KEY}" \
   -d "{\"id\":\"job2\",\"cron\":\"0 0 * * * *\",\"url\":\"https://example.com/backup\",\"active\":false}"
 
 # Update an existing job
 echo -e "\n\nUpdating existing job..."
-curl -s -X PUT "${SERVER}/cron/${USER}/job1" \
+curl -s -X PUT "${SERVER}/cron/${USER_KEY}/job1" \
   -d "{\"cron\":\"0 */10 * * * *\",\"url\":\"https://example.com/updated\",\"active\":true}"
 
 # Get specific job after update
 echo -e "\n\nGetting specific job after update..."
-curl -s "${SERVER}/cron/${USER}/job1" | jq
+curl -s "${SERVER}/cron/${USER_KEY}/job1" | jq
 
 # Store nested data
 echo -e "\n\nStoring nested data..."
-curl -s -X PUT "${SERVER}/data/${USER}/user_profile" \
+curl -s -X PUT "${SERVER}/data/${USER_KEY}/user_profile" \
   -d "{\"name\":\"John Doe\",\"contact\":{\"email\":\"john@example.com\",\"phone\":\"555-1234\"},\"preferences\":{\"language\":\"en\",\"timezone\":\"UTC\"}}"
 
 # Get nested data
 echo -e "\n\nGetting nested data..."
-curl -s "${SERVER}/data/${USER}/user_profile" | jq
+curl -s "${SERVER}/data/${USER_KEY}/user_profile" | jq
 
 # List data keys
 echo -e "\n\nListing data keys..."
-curl -s "${SERVER}/data/${USER}/keys" | jq
+curl -s "${SERVER}/data/${USER_KEY}/keys" | jq
 
 # Delete a job
 echo -e "\n\nDeleting a job..."
-curl -s -X DELETE "${SERVER}/cron/${USER}/job2"
+curl -s -X DELETE "${SERVER}/cron/${USER_KEY}/job2"
 
 # Create another user
 echo -e "\n\nCreating another user..."
@@ -86,26 +95,26 @@ echo -e "\n\nTesting job activation/deactivation..."
 
 # First deactivate the job
 echo "Deactivating job..."
-curl -s "${SERVER}/cron/${USER}/job1/off" | jq
+curl -s "${SERVER}/cron/${USER_KEY}/job1/off" | jq
 
 # Wait a moment
 sleep 1
 
 # Check job status (should be inactive)
 echo -e "\nChecking job status after deactivation..."
-jobStatus=$(curl -s "${SERVER}/cron/${USER}/job1" | jq -r '.active')
+jobStatus=$(curl -s "${SERVER}/cron/${USER_KEY}/job1" | jq -r '.active')
 echo "Job active status: $jobStatus"
 
 # Now activate the job
 echo -e "\nActivating job..."
-curl -s "${SERVER}/cron/${USER}/job1/on" | jq
+curl -s "${SERVER}/cron/${USER_KEY}/job1/on" | jq
 
 # Wait a moment
 sleep 1
 
 # Check job status again (should be active)
 echo -e "\nChecking job status after activation..."
-jobStatus=$(curl -s "${SERVER}/cron/${USER}/job1" | jq -r '.active')
+jobStatus=$(curl -s "${SERVER}/cron/${USER_KEY}/job1" | jq -r '.active')
 echo "Job active status: $jobStatus"
 
 # Get full configuration
@@ -121,25 +130,25 @@ echo -e "\n\nTesting global job activation/deactivation..."
 
 # First deactivate all jobs
 echo "Deactivating all jobs..."
-curl -s "${SERVER}/cron/${USER}/off" | jq
+curl -s "${SERVER}/cron/${USER_KEY}/off" | jq
 
 # Wait a moment
 sleep 1
 
 # Check job status (should all be inactive)
 echo -e "\nChecking job status after global deactivation..."
-curl -s "${SERVER}/cron/${USER}" | jq '.[] | .active'
+curl -s "${SERVER}/cron/${USER_KEY}" | jq '.[] | .active'
 
 # Now activate all jobs
 echo -e "\nActivating all jobs..."
-curl -s "${SERVER}/cron/${USER}/on" | jq
+curl -s "${SERVER}/cron/${USER_KEY}/on" | jq
 
 # Wait a moment
 sleep 1
 
 # Check job status again (should all be active)
 echo -e "\nChecking job status after global activation..."
-curl -s "${SERVER}/cron/${USER}" | jq '.[] | .active'
+curl -s "${SERVER}/cron/${USER_KEY}" | jq '.[] | .active'
 
 # Test configuration reload
 echo -e "\n\nTesting configuration reload..."
@@ -0,0 +1,176 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// ParseClientAuth parses the client certificate mode: none, optional or
+// require
+func ParseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("expected none, optional or require, got %q", mode)
+	}
+}
+
+// Reloader serves the server certificate and the client CA pool from files
+// and can reload them without restarting the server
+type Reloader struct {
+	certFile   string
+	keyFile    string
+	caFile     string
+	clientAuth tls.ClientAuthType
+
+	mutex     sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+// NewReloader loads the certificate, key and, if given, the client CA file
+func NewReloader(certFile, keyFile, caFile string, clientAuth tls.ClientAuthType) (*Reloader, error) {
+	if clientAuth != tls.NoClientCert && caFile == "" {
+		return nil, fmt.Errorf("client certificates need a client CA file")
+	}
+
+	r := &Reloader{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		caFile:     caFile,
+		clientAuth: clientAuth,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload reads the files again. On error the previous certificate and CA
+// pool stay in use.
+func (r *Reloader) Reload() error {
+	modTimes := r.currentModTimes()
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.caFile != "" {
+		data, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in client CA file %s", r.caFile)
+		}
+	}
+
+	r.mutex.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	r.mutex.Unlock()
+
+	return nil
+}
+
+// Watch reloads the files whenever one of them changes, checking at the
+// given interval until stop is closed
+func (r *Reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				log.Printf("Error reloading TLS files: %v", err)
+				continue
+			}
+			log.Printf("TLS certificate reloaded after file change")
+		case <-stop:
+			return
+		}
+	}
+}
+
+// TLSConfig returns a server configuration that always uses the currently
+// loaded certificate and client CA pool
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetCertificate:     r.getCertificate,
+		GetConfigForClient: r.configForClient,
+	}
+}
+
+// getCertificate returns the current server certificate
+func (r *Reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.cert, nil
+}
+
+// configForClient returns the configuration for a new connection. The
+// certificate is looked up by getCertificate as well, so the connection
+// uses the one current when it is served.
+func (r *Reloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+		ClientAuth:     r.clientAuth,
+		ClientCAs:      r.clientCAs,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}, nil
+}
+
+// changed reports whether one of the files was modified since the last load
+func (r *Reloader) changed() bool {
+	modTimes := r.currentModTimes()
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for file, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// currentModTimes returns the modification times of the files
+func (r *Reloader) currentModTimes() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+	return modTimes
+}
@@ -0,0 +1,257 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate with the common name and its
+// key to the files, and moves their modification time forward, so the
+// change is seen even within the resolution of the file system
+func writeCert(t *testing.T, certFile, keyFile, commonName string, modTime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() failed: %v", err)
+	}
+
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modTime)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), modTime)
+}
+
+// writeFile writes a file with the modification time
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Chtimes() failed: %v", err)
+	}
+}
+
+// commonName returns the common name of the certificate the reloader serves
+func commonName(t *testing.T, r *Reloader) string {
+	t.Helper()
+
+	cert, err := r.getCertificate(nil)
+	if err != nil || cert == nil {
+		t.Fatalf("getCertificate() = %v, %v", cert, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("ParseCertificate() failed: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestParseClientAuth(t *testing.T) {
+	tests := []struct {
+		mode     string
+		expected tls.ClientAuthType
+		valid    bool
+	}{
+		{"", tls.NoClientCert, true},
+		{"none", tls.NoClientCert, true},
+		{"optional", tls.VerifyClientCertIfGiven, true},
+		{"require", tls.RequireAndVerifyClientCert, true},
+		{"required", tls.NoClientCert, false},
+		{"REQUIRE", tls.NoClientCert, false},
+	}
+
+	for _, tt := range tests {
+		clientAuth, err := ParseClientAuth(tt.mode)
+		if (err == nil) != tt.valid || clientAuth != tt.expected {
+			t.Errorf("ParseClientAuth(%q) = %v, %v, expected %v, valid %v", tt.mode, clientAuth, err, tt.expected, tt.valid)
+		}
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	start := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, "first", start)
+
+	r, err := NewReloader(certFile, keyFile, "", tls.NoClientCert)
+	if err != nil {
+		t.Fatalf("NewReloader() failed: %v", err)
+	}
+	if name := commonName(t, r); name != "first" {
+		t.Errorf("getCertificate() returned %s, expected first", name)
+	}
+
+	tests := []struct {
+		name string
+		// change replaces the files at the modification time
+		change   func(modTime time.Time)
+		changed  bool
+		reloaded bool
+		expected string
+	}{
+		{
+			name:     "unchanged",
+			change:   func(time.Time) {},
+			changed:  false,
+			reloaded: true,
+			expected: "first",
+		},
+		{
+			name: "rotated",
+			change: func(modTime time.Time) {
+				writeCert(t, certFile, keyFile, "second", modTime)
+			},
+			changed:  true,
+			reloaded: true,
+			expected: "second",
+		},
+		{
+			name: "broken certificate",
+			change: func(modTime time.Time) {
+				writeFile(t, certFile, []byte("not a certificate"), modTime)
+			},
+			changed:  true,
+			reloaded: false,
+			expected: "second",
+		},
+		{
+			name: "key of another certificate",
+			change: func(modTime time.Time) {
+				writeCert(t, certFile, filepath.Join(dir, "other.key"), "third", modTime)
+			},
+			changed:  true,
+			reloaded: false,
+			expected: "second",
+		},
+		{
+			name: "repaired",
+			change: func(modTime time.Time) {
+				writeCert(t, certFile, keyFile, "fourth", modTime)
+			},
+			changed:  true,
+			reloaded: true,
+			expected: "fourth",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change(start.Add(time.Duration(i+1) * time.Minute))
+
+			if changed := r.changed(); changed != tt.changed {
+				t.Errorf("changed() = %v, expected %v", changed, tt.changed)
+			}
+			if err := r.Reload(); (err == nil) != tt.reloaded {
+				t.Errorf("Reload() error = %v, expected reloaded %v", err, tt.reloaded)
+			}
+			if name := commonName(t, r); name != tt.expected {
+				t.Errorf("getCertificate() returned %s, expected %s", name, tt.expected)
+			}
+			if tt.reloaded && r.changed() {
+				t.Error("changed() returned true after Reload()")
+			}
+		})
+	}
+}
+
+func TestReloaderClientCAs(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.crt")
+	writeCert(t, certFile, keyFile, "server", time.Now())
+	writeCert(t, caFile, filepath.Join(dir, "ca.key"), "client CA", time.Now())
+
+	if _, err := NewReloader(certFile, keyFile, "", tls.RequireAndVerifyClientCert); err == nil {
+		t.Error("NewReloader() accepted client certificates without a CA file")
+	}
+
+	r, err := NewReloader(certFile, keyFile, caFile, tls.RequireAndVerifyClientCert)
+	if err != nil {
+		t.Fatalf("NewReloader() failed: %v", err)
+	}
+
+	config, err := r.configForClient(nil)
+	if err != nil {
+		t.Fatalf("configForClient() failed: %v", err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert || config.ClientCAs == nil {
+		t.Errorf("configForClient() returned client auth %v and CAs %v, expected required with the CA file", config.ClientAuth, config.ClientCAs)
+	}
+
+	// The certificate is looked up when a connection is served, so a
+	// configuration handed out before a reload serves the new certificate
+	if config.GetCertificate == nil || len(config.Certificates) != 0 {
+		t.Fatal("configForClient() did not look up the certificate with GetCertificate")
+	}
+	writeCert(t, certFile, keyFile, "rotated", time.Now().Add(time.Minute))
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	cert, err := config.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate() failed: %v", err)
+	}
+	if leaf, _ := x509.ParseCertificate(cert.Certificate[0]); leaf == nil || leaf.Subject.CommonName != "rotated" {
+		t.Error("GetCertificate() did not return the reloaded certificate")
+	}
+}
+
+func TestReloaderHandshake(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	writeCert(t, certFile, keyFile, "server", time.Now())
+
+	r, err := NewReloader(certFile, keyFile, "", tls.NoClientCert)
+	if err != nil {
+		t.Fatalf("NewReloader() failed: %v", err)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := tls.Server(serverConn, r.TLSConfig())
+	go server.Handshake()
+
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	if err := client.Handshake(); err != nil {
+		t.Fatalf("Handshake() failed: %v", err)
+	}
+	if peers := client.ConnectionState().PeerCertificates; len(peers) == 0 || peers[0].Subject.CommonName != "server" {
+		t.Error("Handshake() did not present the loaded certificate")
+	}
+}